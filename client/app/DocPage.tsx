@@ -438,16 +438,25 @@ const DocPage: React.FC<DocPageProps> = ({ sessionId = "default" }) => {
       const token = localStorage.getItem("authToken");
       if (!token) return [];
 
-      const response = await fetch(buildDocumentUrl(), {
-        headers: {
-          Authorization: `Bearer ${token}`,
-        },
-      });
+      // the list is paged, follow nextCursor until the last page
+      const documents = [];
+      let cursor = "";
+      do {
+        const params = new URLSearchParams({ limit: "100" });
+        if (cursor) params.set("cursor", cursor);
+
+        const response = await fetch(`${buildDocumentUrl()}?${params}`, {
+          headers: {
+            Authorization: `Bearer ${token}`,
+          },
+        });
+        if (!response.ok) break;
 
-      if (response.ok) {
         const data = await response.json();
-        return data.documents || [];
-      }
+        documents.push(...(data.documents || []));
+        cursor = data.nextCursor || "";
+      } while (cursor);
+      return documents;
     } catch (error) {
       console.error("Error fetching documents:", error);
     }
@@ -379,16 +379,25 @@ const ExcalidrawWrapper: React.FC<ExcalidrawWrapperProps> = ({
       const token = localStorage.getItem("authToken");
       if (!token) return [];
 
-      const response = await fetch(buildDrawingUrl(), {
-        headers: {
-          Authorization: `Bearer ${token}`,
-        },
-      });
+      // the list is paged, follow nextCursor until the last page
+      const drawings = [];
+      let cursor = "";
+      do {
+        const params = new URLSearchParams({ limit: "100" });
+        if (cursor) params.set("cursor", cursor);
+
+        const response = await fetch(`${buildDrawingUrl()}?${params}`, {
+          headers: {
+            Authorization: `Bearer ${token}`,
+          },
+        });
+        if (!response.ok) break;
 
-      if (response.ok) {
         const data = await response.json();
-        return data.drawings || [];
-      }
+        drawings.push(...(data.drawings || []));
+        cursor = data.nextCursor || "";
+      } while (cursor);
+      return drawings;
     } catch (error) {
       console.error("Error fetching drawings:", error);
     }
@@ -1,6 +1,7 @@
 "use client";
-import React, { useState } from "react";
+import React, { useState, useEffect } from "react";
 import { useAuth } from "../contexts/AuthContext";
+import { API_ENDPOINTS } from "../config/api";
 
 interface AuthModalProps {
   isOpen: boolean;
@@ -14,8 +15,15 @@ export const AuthModal: React.FC<AuthModalProps> = ({ isOpen, onClose }) => {
   const [password, setPassword] = useState("");
   const [error, setError] = useState("");
   const [isLoading, setIsLoading] = useState(false);
+  const [needsCode, setNeedsCode] = useState(false);
+  const [code, setCode] = useState("");
 
-  const { login, register } = useAuth();
+  const { login, verifyTwoFactor, register, pendingTwoFactor } = useAuth();
+
+  // SSO sign in of a 2FA account lands here for the code
+  useEffect(() => {
+    if (pendingTwoFactor) setNeedsCode(true);
+  }, [pendingTwoFactor]);
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
@@ -24,8 +32,19 @@ export const AuthModal: React.FC<AuthModalProps> = ({ isOpen, onClose }) => {
 
     try {
       let success = false;
-      if (isLogin) {
-        success = await login(email, password);
+      if (needsCode) {
+        success = await verifyTwoFactor(code);
+        if (!success) {
+          setError("Invalid code");
+          return;
+        }
+      } else if (isLogin) {
+        const result = await login(email, password);
+        if (result === "2fa") {
+          setNeedsCode(true);
+          return;
+        }
+        success = result;
       } else {
         success = await register(name, email, password);
       }
@@ -36,6 +55,8 @@ export const AuthModal: React.FC<AuthModalProps> = ({ isOpen, onClose }) => {
         setName("");
         setEmail("");
         setPassword("");
+        setCode("");
+        setNeedsCode(false);
       } else {
         setError(isLogin ? "Invalid email or password" : "Registration failed");
       }
@@ -47,9 +68,32 @@ export const AuthModal: React.FC<AuthModalProps> = ({ isOpen, onClose }) => {
     }
   };
 
+  const requestPasswordReset = async () => {
+    if (!email) {
+      setError("Enter your email first");
+      return;
+    }
+    setError("");
+    try {
+      await fetch(API_ENDPOINTS.AUTH.REQUEST_PASSWORD_RESET, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({ email }),
+      });
+      setError("If the account exists, a reset link is on its way");
+    } catch (error) {
+      console.error("Password reset error:", error);
+      setError("An error occurred. Please try again.");
+    }
+  };
+
   const switchMode = () => {
     setIsLogin(!isLogin);
     setError("");
+    setNeedsCode(false);
+    setCode("");
     setName("");
     setEmail("");
     setPassword("");
@@ -157,8 +201,39 @@ export const AuthModal: React.FC<AuthModalProps> = ({ isOpen, onClose }) => {
               required
               minLength={6}
             />
+            {isLogin && !needsCode && (
+              <button
+                type="button"
+                onClick={requestPasswordReset}
+                className="mt-2 text-sm text-white/70 hover:text-white transition-colors"
+              >
+                Forgot password?
+              </button>
+            )}
           </div>
 
+          {needsCode && (
+            <div>
+              <label
+                htmlFor="code"
+                className="block text-sm font-medium text-white/90 mb-2"
+              >
+                Authentication Code
+              </label>
+              <input
+                type="text"
+                id="code"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                className="w-full px-4 py-3 bg-white/10 backdrop-blur-sm border border-white/30 rounded-2xl focus:ring-2 focus:ring-purple-400 focus:border-transparent outline-none text-white placeholder-white/60 transition-all duration-200"
+                placeholder="6-digit code or recovery code"
+                autoComplete="one-time-code"
+                autoFocus
+                required
+              />
+            </div>
+          )}
+
           <div className="flex gap-3 pt-6">
             <button
               type="button"
@@ -185,6 +260,13 @@ export const AuthModal: React.FC<AuthModalProps> = ({ isOpen, onClose }) => {
               )}
             </button>
           </div>
+
+          <a
+            href={API_ENDPOINTS.AUTH.OIDC_LOGIN}
+            className="block w-full px-6 py-3 bg-white/10 border border-white/30 text-white text-center rounded-2xl hover:bg-white/20 transition-all duration-200 font-medium backdrop-blur-sm"
+          >
+            Sign in with SSO
+          </a>
         </form>
 
         <div className="mt-8 text-center">
@@ -9,10 +9,24 @@ export const API_ENDPOINTS = {
   AUTH: {
     LOGIN: `${API_CONFIG.BASE_URL}/api/auth/login`,
     REGISTER: `${API_CONFIG.BASE_URL}/api/auth/register`,
+    REFRESH: `${API_CONFIG.BASE_URL}/api/auth/refresh`,
+    LOGOUT: `${API_CONFIG.BASE_URL}/api/auth/logout`,
+    OIDC_LOGIN: `${API_CONFIG.BASE_URL}/api/auth/oidc/login`,
+    VERIFY_EMAIL: `${API_CONFIG.BASE_URL}/api/auth/verify-email`,
+    REQUEST_PASSWORD_RESET: `${API_CONFIG.BASE_URL}/api/auth/password-reset/request`,
+    RESET_PASSWORD: `${API_CONFIG.BASE_URL}/api/auth/password-reset/confirm`,
+    VERIFY_TWO_FACTOR: `${API_CONFIG.BASE_URL}/api/auth/2fa/verify`,
+    CONFIRM_EMAIL_CHANGE: `${API_CONFIG.BASE_URL}/api/auth/email-change/confirm`,
     PROFILE: `${API_CONFIG.BASE_URL}/api/profile`,
+    CHANGE_PASSWORD: `${API_CONFIG.BASE_URL}/api/profile/password`,
+    CHANGE_EMAIL: `${API_CONFIG.BASE_URL}/api/profile/email`,
   },
   DOCUMENTS: `${API_CONFIG.BASE_URL}/api/documents`,
   DRAWINGS: `${API_CONFIG.BASE_URL}/api/drawings`,
+  WORKSPACES: `${API_CONFIG.BASE_URL}/api/workspaces`,
+  FOLDERS: `${API_CONFIG.BASE_URL}/api/folders`,
+  SEARCH: `${API_CONFIG.BASE_URL}/api/search`,
+  TRASH: `${API_CONFIG.BASE_URL}/api/trash`,
   WEBSOCKET: `${API_CONFIG.WS_URL}/ws`,
 } as const;
 
@@ -34,3 +48,17 @@ export const buildDrawingUrl = (drawingId?: string): string => {
     ? `${API_ENDPOINTS.DRAWINGS}/${drawingId}`
     : API_ENDPOINTS.DRAWINGS;
 };
+
+// Utility function to build workspace API URL
+export const buildWorkspaceUrl = (workspaceId?: string): string => {
+  return workspaceId
+    ? `${API_ENDPOINTS.WORKSPACES}/${workspaceId}`
+    : API_ENDPOINTS.WORKSPACES;
+};
+
+// Utility function to build folder API URL
+export const buildFolderUrl = (folderId?: string): string => {
+  return folderId
+    ? `${API_ENDPOINTS.FOLDERS}/${folderId}`
+    : API_ENDPOINTS.FOLDERS;
+};
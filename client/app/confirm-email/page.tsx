@@ -0,0 +1,58 @@
+"use client";
+import React, { useEffect, useState } from "react";
+import Link from "next/link";
+import { API_ENDPOINTS } from "../config/api";
+
+export default function ConfirmEmailPage() {
+  const [status, setStatus] = useState<"confirming" | "confirmed" | "failed">(
+    "confirming"
+  );
+  const [message, setMessage] = useState("Confirming your new email...");
+
+  useEffect(() => {
+    const token = new URLSearchParams(window.location.search).get("token");
+    if (!token) {
+      setStatus("failed");
+      setMessage("This confirmation link is missing its token.");
+      return;
+    }
+
+    fetch(API_ENDPOINTS.AUTH.CONFIRM_EMAIL_CHANGE, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify({ token }),
+    })
+      .then(async (response) => {
+        const data = await response.json();
+        setStatus(response.ok ? "confirmed" : "failed");
+        setMessage(response.ok ? data.message : data.error);
+      })
+      .catch(() => {
+        setStatus("failed");
+        setMessage("Could not reach the server, please try again.");
+      });
+  }, []);
+
+  return (
+    <div className="min-h-screen bg-gradient-to-br from-blue-900 via-teal-900 to-emerald-800 flex items-center justify-center">
+      <div className="bg-white/10 backdrop-blur-lg rounded-3xl p-8 border border-white/20 shadow-2xl max-w-md w-full mx-4 text-center">
+        <h1 className="text-2xl font-bold text-white mb-4">
+          {status === "confirmed"
+            ? "Email Changed"
+            : status === "failed"
+            ? "Confirmation Failed"
+            : "Confirming..."}
+        </h1>
+        <p className="text-white/80 mb-6 leading-relaxed">{message}</p>
+        <Link
+          href="/"
+          className="inline-block px-6 py-3 bg-gradient-to-r from-blue-500 to-teal-500 hover:from-blue-600 hover:to-teal-600 text-white rounded-xl transition-all duration-200 font-medium shadow-lg hover:shadow-xl transform hover:scale-105"
+        >
+          Go to Home
+        </Link>
+      </div>
+    </div>
+  );
+}
@@ -1,18 +1,30 @@
 "use client";
-import React, { createContext, useContext, useState, useEffect } from "react";
+import React, {
+  createContext,
+  useContext,
+  useState,
+  useEffect,
+  useRef,
+} from "react";
 import { API_ENDPOINTS } from "../config/api";
 
 interface User {
   id: string;
   name: string;
   email: string;
+  avatarUrl?: string;
+  cursorColor?: string;
 }
 
 interface AuthContextType {
   user: User | null;
-  login: (email: string, password: string) => Promise<boolean>;
+  // "2fa" means the password was right and verifyTwoFactor needs a code
+  login: (email: string, password: string) => Promise<boolean | "2fa">;
+  verifyTwoFactor: (code: string) => Promise<boolean>;
+  // SSO sign in of an account with 2FA on, waiting for verifyTwoFactor
+  pendingTwoFactor: boolean;
   register: (name: string, email: string, password: string) => Promise<boolean>;
-  logout: () => void;
+  logout: (allDevices?: boolean) => Promise<void>;
   isLoading: boolean;
 }
 
@@ -31,8 +43,81 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({
 }) => {
   const [user, setUser] = useState<User | null>(null);
   const [isLoading, setIsLoading] = useState(true);
+  const refreshTimer = useRef<ReturnType<typeof setTimeout> | null>(null);
+  const challengeToken = useRef<string | null>(null);
+  const [pendingTwoFactor, setPendingTwoFactor] = useState(false);
+
+  // stores a new token pair and refreshes a minute before the access token expires
+  const storeTokens = (data: {
+    token: string;
+    refreshToken: string;
+    expiresIn: number;
+  }) => {
+    localStorage.setItem("authToken", data.token);
+    localStorage.setItem("refreshToken", data.refreshToken);
+
+    if (refreshTimer.current) clearTimeout(refreshTimer.current);
+    const delay = Math.max((data.expiresIn - 60) * 1000, 10000);
+    refreshTimer.current = setTimeout(() => {
+      refreshTokens();
+    }, delay);
+  };
+
+  const clearTokens = () => {
+    if (refreshTimer.current) clearTimeout(refreshTimer.current);
+    localStorage.removeItem("authToken");
+    localStorage.removeItem("refreshToken");
+  };
+
+  // swaps the refresh token for a new pair, returns the new access token
+  const refreshTokens = async (): Promise<string | null> => {
+    const refreshToken = localStorage.getItem("refreshToken");
+    if (!refreshToken) return null;
+
+    try {
+      const response = await fetch(API_ENDPOINTS.AUTH.REFRESH, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({ refreshToken }),
+      });
+
+      if (!response.ok) {
+        console.log("AuthContext: Refresh failed, signing out");
+        clearTokens();
+        setUser(null);
+        return null;
+      }
+
+      const data = await response.json();
+      storeTokens(data);
+      return data.token;
+    } catch (error) {
+      console.error("AuthContext: Refresh error:", error);
+      return null;
+    }
+  };
 
   useEffect(() => {
+    // SSO sign in comes back with the tokens in the url fragment
+    const fragment = new URLSearchParams(window.location.hash.slice(1));
+    if (fragment.get("token") && fragment.get("refreshToken")) {
+      storeTokens({
+        token: fragment.get("token")!,
+        refreshToken: fragment.get("refreshToken")!,
+        expiresIn: Number(fragment.get("expiresIn")) || 900,
+      });
+      window.history.replaceState(null, "", window.location.pathname);
+    } else if (fragment.get("twoFactorRequired") && fragment.get("challengeToken")) {
+      challengeToken.current = fragment.get("challengeToken");
+      setPendingTwoFactor(true);
+      window.history.replaceState(null, "", window.location.pathname);
+    } else if (fragment.get("error")) {
+      console.error("SSO sign in failed:", fragment.get("error"));
+      window.history.replaceState(null, "", window.location.pathname);
+    }
+
     // Check for existing token on mount
     const token = localStorage.getItem("authToken");
     console.log(
@@ -51,12 +136,24 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({
   const validateToken = async (token: string) => {
     console.log("AuthContext: Validating token...");
     try {
-      const response = await fetch(API_ENDPOINTS.AUTH.PROFILE, {
+      let response = await fetch(API_ENDPOINTS.AUTH.PROFILE, {
         headers: {
           Authorization: `Bearer ${token}`,
         },
       });
 
+      // access tokens are short lived, try once more with a fresh one
+      if (response.status === 401) {
+        const refreshed = await refreshTokens();
+        if (refreshed) {
+          response = await fetch(API_ENDPOINTS.AUTH.PROFILE, {
+            headers: {
+              Authorization: `Bearer ${refreshed}`,
+            },
+          });
+        }
+      }
+
       console.log("AuthContext: Profile response status:", response.status);
 
       if (response.ok) {
@@ -65,12 +162,12 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({
         setUser(userData);
       } else {
         console.log("AuthContext: Token validation failed, removing token");
-        localStorage.removeItem("authToken");
+        clearTokens();
         setUser(null);
       }
     } catch (error) {
       console.error("AuthContext: Token validation error:", error);
-      localStorage.removeItem("authToken");
+      clearTokens();
       setUser(null);
     } finally {
       console.log("AuthContext: Setting isLoading to false");
@@ -78,7 +175,10 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({
     }
   };
 
-  const login = async (email: string, password: string): Promise<boolean> => {
+  const login = async (
+    email: string,
+    password: string
+  ): Promise<boolean | "2fa"> => {
     try {
       const response = await fetch(API_ENDPOINTS.AUTH.LOGIN, {
         method: "POST",
@@ -90,7 +190,11 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({
 
       if (response.ok) {
         const data = await response.json();
-        localStorage.setItem("authToken", data.token);
+        if (data.twoFactorRequired) {
+          challengeToken.current = data.challengeToken;
+          return "2fa";
+        }
+        storeTokens(data);
         setUser(data.user);
         return true;
       } else {
@@ -104,6 +208,36 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({
     }
   };
 
+  const verifyTwoFactor = async (code: string): Promise<boolean> => {
+    if (!challengeToken.current) return false;
+
+    try {
+      const response = await fetch(API_ENDPOINTS.AUTH.VERIFY_TWO_FACTOR, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({ challengeToken: challengeToken.current, code }),
+      });
+
+      if (response.ok) {
+        const data = await response.json();
+        challengeToken.current = null;
+        setPendingTwoFactor(false);
+        storeTokens(data);
+        setUser(data.user);
+        return true;
+      } else {
+        const errorData = await response.json();
+        console.error("Two-factor verification failed:", errorData.error);
+        return false;
+      }
+    } catch (error) {
+      console.error("Two-factor verification error:", error);
+      return false;
+    }
+  };
+
   const register = async (
     name: string,
     email: string,
@@ -120,7 +254,7 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({
 
       if (response.ok) {
         const data = await response.json();
-        localStorage.setItem("authToken", data.token);
+        storeTokens(data);
         setUser(data.user);
         return true;
       } else {
@@ -134,14 +268,30 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({
     }
   };
 
-  const logout = () => {
-    localStorage.removeItem("authToken");
+  const logout = async (allDevices = false) => {
+    const refreshToken = localStorage.getItem("refreshToken");
+    if (refreshToken) {
+      try {
+        await fetch(API_ENDPOINTS.AUTH.LOGOUT, {
+          method: "POST",
+          headers: {
+            "Content-Type": "application/json",
+          },
+          body: JSON.stringify({ refreshToken, all: allDevices }),
+        });
+      } catch (error) {
+        console.error("Logout error:", error);
+      }
+    }
+    clearTokens();
     setUser(null);
   };
 
   const value: AuthContextType = {
     user,
     login,
+    verifyTwoFactor,
+    pendingTwoFactor,
     register,
     logout,
     isLoading,
@@ -7,10 +7,14 @@ import Image from "next/image";
 
 export default function Home() {
   const router = useRouter();
-  const { user, logout } = useAuth();
+  const { user, logout, pendingTwoFactor } = useAuth();
   const [joinSessionId, setJoinSessionId] = useState("");
   const [showJoinModal, setShowJoinModal] = useState(false);
   const [showAuthModal, setShowAuthModal] = useState(false);
+
+  useEffect(() => {
+    if (pendingTwoFactor) setShowAuthModal(true);
+  }, [pendingTwoFactor]);
   const [joinType, setJoinType] = useState<"excalidraw" | "doc">("excalidraw");
   const [particles, setParticles] = useState<
     Array<{
@@ -128,7 +132,7 @@ export default function Home() {
                     </span>
                   </div>
                   <button
-                    onClick={logout}
+                    onClick={() => logout()}
                     className="px-6 py-2 bg-white/20 hover:bg-white/30 backdrop-blur-sm text-white rounded-xl transition-all duration-200 font-medium border border-white/30 hover:border-white/50"
                   >
                     Sign Out
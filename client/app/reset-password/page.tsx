@@ -0,0 +1,103 @@
+"use client";
+import React, { useState } from "react";
+import Link from "next/link";
+import { API_ENDPOINTS } from "../config/api";
+
+export default function ResetPasswordPage() {
+  const [password, setPassword] = useState("");
+  const [confirm, setConfirm] = useState("");
+  const [isLoading, setIsLoading] = useState(false);
+  const [done, setDone] = useState(false);
+  const [error, setError] = useState("");
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError("");
+
+    if (password !== confirm) {
+      setError("Passwords do not match");
+      return;
+    }
+
+    const token = new URLSearchParams(window.location.search).get("token");
+    if (!token) {
+      setError("This reset link is missing its token.");
+      return;
+    }
+
+    setIsLoading(true);
+    try {
+      const response = await fetch(API_ENDPOINTS.AUTH.RESET_PASSWORD, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({ token, password }),
+      });
+
+      if (response.ok) {
+        setDone(true);
+      } else {
+        const data = await response.json();
+        setError(data.error);
+      }
+    } catch {
+      setError("Could not reach the server, please try again.");
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  return (
+    <div className="min-h-screen bg-gradient-to-br from-blue-900 via-teal-900 to-emerald-800 flex items-center justify-center">
+      <div className="bg-white/10 backdrop-blur-lg rounded-3xl p-8 border border-white/20 shadow-2xl max-w-md w-full mx-4">
+        <h1 className="text-2xl font-bold text-white mb-6 text-center">
+          Choose a New Password
+        </h1>
+
+        {done ? (
+          <div className="text-center">
+            <p className="text-white/80 mb-6 leading-relaxed">
+              Your password was reset. Sign in again with the new password.
+            </p>
+            <Link
+              href="/"
+              className="inline-block px-6 py-3 bg-gradient-to-r from-blue-500 to-teal-500 hover:from-blue-600 hover:to-teal-600 text-white rounded-xl transition-all duration-200 font-medium shadow-lg hover:shadow-xl transform hover:scale-105"
+            >
+              Go to Home & Sign In
+            </Link>
+          </div>
+        ) : (
+          <form onSubmit={handleSubmit} className="space-y-4">
+            <input
+              type="password"
+              value={password}
+              onChange={(e) => setPassword(e.target.value)}
+              className="w-full px-4 py-3 bg-white/10 backdrop-blur-sm border border-white/30 rounded-2xl focus:ring-2 focus:ring-teal-400 focus:border-transparent outline-none text-white placeholder-white/60"
+              placeholder="New password"
+              required
+              minLength={6}
+            />
+            <input
+              type="password"
+              value={confirm}
+              onChange={(e) => setConfirm(e.target.value)}
+              className="w-full px-4 py-3 bg-white/10 backdrop-blur-sm border border-white/30 rounded-2xl focus:ring-2 focus:ring-teal-400 focus:border-transparent outline-none text-white placeholder-white/60"
+              placeholder="Repeat new password"
+              required
+              minLength={6}
+            />
+            {error && <p className="text-red-300 text-sm">{error}</p>}
+            <button
+              type="submit"
+              disabled={isLoading}
+              className="w-full px-6 py-3 bg-gradient-to-r from-blue-500 to-teal-500 hover:from-blue-600 hover:to-teal-600 text-white rounded-2xl font-semibold transition-all duration-200 disabled:opacity-50 disabled:cursor-not-allowed"
+            >
+              {isLoading ? "Please wait..." : "Reset Password"}
+            </button>
+          </form>
+        )}
+      </div>
+    </div>
+  );
+}
@@ -0,0 +1,268 @@
+package access
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Role is what a user may do with a document or drawing
+type Role string
+
+const (
+	RoleOwner     Role = "owner"
+	RoleEditor    Role = "editor"
+	RoleCommenter Role = "commenter"
+	RoleViewer    Role = "viewer"
+)
+
+// higher rank includes everything below it
+var roleRank = map[Role]int{
+	RoleViewer:    1,
+	RoleCommenter: 2,
+	RoleEditor:    3,
+	RoleOwner:     4,
+}
+
+// Collaborator is one entry of the ACL stored on a document or drawing. entries
+// for emails without a verified account yet have no UserID and are matched by
+// email, see ClaimInvites
+type Collaborator struct {
+	UserID  string    `json:"userId,omitempty" bson:"userId,omitempty"`
+	Email   string    `json:"email" bson:"email"`
+	Role    Role      `json:"role" bson:"role"`
+	AddedBy string    `json:"addedBy" bson:"addedBy"`
+	AddedAt time.Time `json:"addedAt" bson:"addedAt"`
+}
+
+// ShareRequest represents the request body for granting access
+type ShareRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  Role   `json:"role" binding:"required"`
+}
+
+// roles that can be handed out, ownership is never shared
+func (role Role) Grantable() bool {
+	return role == RoleEditor || role == RoleCommenter || role == RoleViewer
+}
+
+// AtLeast returns true if role includes everything other allows
+func (role Role) AtLeast(other Role) bool {
+	return roleRank[role] >= roleRank[other]
+}
+
+func (role Role) CanRead() bool {
+	return role.AtLeast(RoleViewer)
+}
+
+// commenters can read but not change content
+func (role Role) CanComment() bool {
+	return role.AtLeast(RoleCommenter)
+}
+
+func (role Role) CanWrite() bool {
+	return role.AtLeast(RoleEditor)
+}
+
+// only owners share, unshare and delete
+func (role Role) CanManage() bool {
+	return role == RoleOwner
+}
+
+// returns the role user has given the owner, the workspace the record is in
+// and the ACL, "" when none. the workspace role cascades to everything in it
+// and the higher of the two wins
+func RoleFor(ownerID string, workspaceID string, collaborators []Collaborator, user Principal) Role {
+	if user.ID == "" {
+		return ""
+	}
+	if ownerID == user.ID {
+		return RoleOwner
+	}
+
+	var role Role
+	if workspaceID != "" {
+		role = user.Workspaces[workspaceID]
+	}
+	for _, collaborator := range collaborators {
+		matches := collaborator.UserID == user.ID
+		if collaborator.UserID == "" {
+			matches = user.EmailVerified && user.Email != "" && strings.EqualFold(collaborator.Email, user.Email)
+		}
+		if matches && roleRank[collaborator.Role] > roleRank[role] {
+			role = collaborator.Role
+		}
+	}
+	return role
+}
+
+// FindCollaborator returns the ACL entry for email
+func FindCollaborator(collaborators []Collaborator, email string) (Collaborator, bool) {
+	for _, collaborator := range collaborators {
+		if strings.EqualFold(collaborator.Email, email) {
+			return collaborator, true
+		}
+	}
+	return Collaborator{}, false
+}
+
+// matches records owned by or shared with user, without workspace access.
+// email invites only count once the email is verified. records in the trash
+// are left out, see TrashFilter
+func DirectFilter(user Principal) bson.M {
+	branches := bson.A{
+		bson.M{"ownerId": user.ID},
+		bson.M{"collaborators.userId": user.ID},
+	}
+	if user.EmailVerified && user.Email != "" {
+		branches = append(branches, bson.M{"collaborators": bson.M{"$elemMatch": bson.M{
+			"email":  user.Email,
+			"userId": bson.M{"$exists": false},
+		}}})
+	}
+	return bson.M{
+		"$or":       branches,
+		"deletedAt": bson.M{"$exists": false},
+	}
+}
+
+// matches records user can open, directly or through a workspace they belong to
+func Filter(user Principal) bson.M {
+	filter := DirectFilter(user)
+	if len(user.Workspaces) > 0 {
+		workspaceIDs := make(bson.A, 0, len(user.Workspaces))
+		for workspaceID := range user.Workspaces {
+			workspaceIDs = append(workspaceIDs, workspaceID)
+		}
+		filter["$or"] = append(filter["$or"].(bson.A), bson.M{"workspaceId": bson.M{"$in": workspaceIDs}})
+	}
+	return filter
+}
+
+// matches records in the trash user can restore or delete for good, the ones
+// they own and the ones in workspaces they own
+func TrashFilter(user Principal) bson.M {
+	branches := bson.A{bson.M{"ownerId": user.ID}}
+	var owned bson.A
+	for workspaceID, role := range user.Workspaces {
+		if role == RoleOwner {
+			owned = append(owned, workspaceID)
+		}
+	}
+	if len(owned) > 0 {
+		branches = append(branches, bson.M{"workspaceId": bson.M{"$in": owned}})
+	}
+	return bson.M{
+		"$or":       branches,
+		"deletedAt": bson.M{"$exists": true},
+	}
+}
+
+// adds a collaborator to the record matching filter or updates their role
+func Grant(ctx context.Context, collection *mongo.Collection, filter bson.M, collaborator Collaborator) error {
+	existing := bson.M{"collaborators.email": collaborator.Email}
+	if collaborator.UserID != "" {
+		existing = bson.M{"collaborators": bson.M{"$elemMatch": bson.M{"$or": bson.A{
+			bson.M{"userId": collaborator.UserID},
+			bson.M{"email": collaborator.Email},
+		}}}}
+	}
+	for key, value := range filter {
+		existing[key] = value
+	}
+
+	set := bson.M{"collaborators.$.role": collaborator.Role}
+	if collaborator.UserID != "" {
+		set["collaborators.$.userId"] = collaborator.UserID
+		set["collaborators.$.email"] = collaborator.Email
+	}
+	result, err := collection.UpdateOne(ctx, existing, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	_, err = collection.UpdateOne(ctx, filter, bson.M{
+		"$push": bson.M{"collaborators": collaborator},
+	})
+	return err
+}
+
+// ClaimInvites binds the email invites for user's verified address to their
+// id, so they keep working after the email changes
+func ClaimInvites(ctx context.Context, collection *mongo.Collection, user Principal) error {
+	if !user.EmailVerified || user.Email == "" {
+		return nil
+	}
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"collaborators": bson.M{"$elemMatch": bson.M{"email": user.Email, "userId": bson.M{"$exists": false}}}},
+		bson.M{"$set": bson.M{"collaborators.$[entry].userId": user.ID}},
+		options.UpdateMany().SetArrayFilters([]interface{}{bson.M{"entry.email": user.Email, "entry.userId": bson.M{"$exists": false}}}),
+	)
+	return err
+}
+
+// removes a collaborator from the record matching filter, false if they had no access
+func Revoke(ctx context.Context, collection *mongo.Collection, filter bson.M, email string) (bool, error) {
+	result, err := collection.UpdateOne(ctx, filter, bson.M{
+		"$pull": bson.M{"collaborators": bson.M{"email": email}},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// ReplaceUser refreshes the emails shown for a user after they changed theirs,
+// access itself follows the user id
+func ReplaceUser(ctx context.Context, collection *mongo.Collection, user Principal, oldEmail string) error {
+	_, err := collection.UpdateMany(ctx, bson.M{"ownerId": user.ID}, bson.M{
+		"$set": bson.M{"createdBy": user.Email},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.UpdateMany(ctx,
+		bson.M{"collaborators.userId": user.ID},
+		bson.M{"$set": bson.M{"collaborators.$[entry].email": user.Email}},
+		options.UpdateMany().SetArrayFilters([]interface{}{bson.M{"entry.userId": user.ID}}),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.UpdateMany(ctx,
+		bson.M{"collaborators.addedBy": oldEmail},
+		bson.M{"$set": bson.M{"collaborators.$[entry].addedBy": user.Email}},
+		options.UpdateMany().SetArrayFilters([]interface{}{bson.M{"entry.addedBy": oldEmail}}),
+	)
+	return err
+}
+
+// TransferOwnership hands every record owned by from to another user, who
+// stops being listed as a collaborator on them
+func TransferOwnership(ctx context.Context, collection *mongo.Collection, from Principal, to Principal) error {
+	_, err := collection.UpdateMany(ctx, bson.M{"ownerId": from.ID}, bson.M{
+		"$set":  bson.M{"ownerId": to.ID, "createdBy": to.Email},
+		"$pull": bson.M{"collaborators": bson.M{"$or": bson.A{bson.M{"userId": to.ID}, bson.M{"email": to.Email}}}},
+	})
+	return err
+}
+
+// RemoveCollaborator takes a user off every ACL in collection
+func RemoveCollaborator(ctx context.Context, collection *mongo.Collection, user Principal) error {
+	_, err := collection.UpdateMany(ctx, bson.M{"$or": bson.A{
+		bson.M{"collaborators.userId": user.ID},
+		bson.M{"collaborators.email": user.Email},
+	}}, bson.M{
+		"$pull": bson.M{"collaborators": bson.M{"$or": bson.A{bson.M{"userId": user.ID}, bson.M{"email": user.Email}}}},
+	})
+	return err
+}
@@ -0,0 +1,63 @@
+package access
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestRoleFor(t *testing.T) {
+	verified := Principal{ID: "u1", Email: "ann@example.com", EmailVerified: true, Workspaces: map[string]Role{"w1": RoleViewer}}
+	unverified := Principal{ID: "u1", Email: "ann@example.com"}
+
+	tests := []struct {
+		name          string
+		ownerID       string
+		workspaceID   string
+		collaborators []Collaborator
+		user          Principal
+		want          Role
+	}{
+		{"owner", "u1", "", nil, verified, RoleOwner},
+		{"collaborator by id", "u2", "", []Collaborator{{UserID: "u1", Email: "old@example.com", Role: RoleEditor}}, unverified, RoleEditor},
+		{"email invite, verified", "u2", "", []Collaborator{{Email: "ANN@example.com", Role: RoleCommenter}}, verified, RoleCommenter},
+		{"email invite, unverified", "u2", "", []Collaborator{{Email: "ann@example.com", Role: RoleEditor}}, unverified, ""},
+		{"invite bound to someone else", "u2", "", []Collaborator{{UserID: "u3", Email: "ann@example.com", Role: RoleEditor}}, verified, ""},
+		{"workspace role", "u2", "w1", nil, verified, RoleViewer},
+		{"higher of workspace and acl", "u2", "w1", []Collaborator{{UserID: "u1", Role: RoleEditor}}, verified, RoleEditor},
+		{"signed out", "u2", "", nil, Principal{}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := RoleFor(test.ownerID, test.workspaceID, test.collaborators, test.user); got != test.want {
+				t.Errorf("RoleFor = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDirectFilterEmailInvites(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     Principal
+		branches int
+	}{
+		{"verified email matches invites", Principal{ID: "u1", Email: "ann@example.com", EmailVerified: true}, 3},
+		{"unverified email doesn't", Principal{ID: "u1", Email: "ann@example.com"}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := DirectFilter(test.user)
+			branches := filter["$or"].(bson.A)
+			if len(branches) != test.branches {
+				t.Errorf("got %d branches, want %d: %v", len(branches), test.branches, branches)
+			}
+			if !reflect.DeepEqual(filter["deletedAt"], bson.M{"$exists": false}) {
+				t.Errorf("deletedAt = %v", filter["deletedAt"])
+			}
+		})
+	}
+}
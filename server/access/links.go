@@ -0,0 +1,286 @@
+package access
+
+import (
+	"collabify-backend/tokens"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ResourceDocument = "document"
+	ResourceDrawing  = "drawing"
+
+	defaultLinkLifetime = 7 * 24 * time.Hour
+	maxLinkLifetime     = 365 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidShareLink  = errors.New("invalid or expired share link")
+	ErrShareLinkPassword = errors.New("share link password required")
+	ErrShareLinkLocked   = errors.New("too many wrong share link passwords")
+)
+
+// ShareLink lets people without an account open one document or drawing
+type ShareLink struct {
+	ID           interface{} `json:"id" bson:"_id,omitempty"`
+	LinkID       string      `json:"linkId" bson:"linkId"`
+	ResourceType string      `json:"resourceType" bson:"resourceType"` // ResourceDocument or ResourceDrawing
+	ResourceID   string      `json:"resourceId" bson:"resourceId"`
+	Role         Role        `json:"role" bson:"role"`
+	PasswordHash string      `json:"-" bson:"passwordHash,omitempty"`
+	HasPassword  bool        `json:"hasPassword" bson:"hasPassword"`
+	ExpiresAt    time.Time   `json:"expiresAt" bson:"expiresAt"`
+	OwnerID      string      `json:"-" bson:"ownerId,omitempty"` // owner of the resource when the link was made
+	CreatedBy    string      `json:"createdBy" bson:"createdBy"`
+	CreatedAt    time.Time   `json:"createdAt" bson:"createdAt"`
+	Revoked      bool        `json:"revoked" bson:"revoked"`
+}
+
+// CreateShareLinkRequest represents the request body for minting a link
+type CreateShareLinkRequest struct {
+	Role           Role   `json:"role"`           // defaults to viewer
+	ExpiresInHours int    `json:"expiresInHours"` // defaults to 7 days
+	Password       string `json:"password"`       // optional
+}
+
+var shareLinksCollection *mongo.Collection
+
+// ShareLinkThrottle slows down guessing link passwords, the auth package
+// provides it so links share the login lockouts
+type ShareLinkThrottle struct {
+	LockedFor     func(linkID string, ip string) (time.Duration, error)
+	RecordFailure func(linkID string, ip string)
+}
+
+var shareLinkThrottle ShareLinkThrottle
+
+// SetShareLinkThrottle sets the throttle for link passwords
+func SetShareLinkThrottle(throttle ShareLinkThrottle) {
+	shareLinkThrottle = throttle
+}
+
+// SetShareLinksCollection sets the share links collection
+func SetShareLinksCollection(collection *mongo.Collection) {
+	shareLinksCollection = collection
+}
+
+// CreateShareLink stores a new link and returns it with its signed token
+func CreateShareLink(ctx context.Context, resourceType, resourceID string, owner Principal, req CreateShareLinkRequest) (*ShareLink, string, error) {
+	if req.Role == "" {
+		req.Role = RoleViewer
+	}
+	if !req.Role.Grantable() {
+		return nil, "", fmt.Errorf("role must be editor, commenter or viewer")
+	}
+
+	lifetime := defaultLinkLifetime
+	if req.ExpiresInHours > 0 {
+		lifetime = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if lifetime > maxLinkLifetime {
+		return nil, "", fmt.Errorf("links can live at most %d hours", int(maxLinkLifetime.Hours()))
+	}
+
+	linkID, err := randomID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	link := ShareLink{
+		LinkID:       linkID,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Role:         req.Role,
+		ExpiresAt:    time.Now().Add(lifetime),
+		OwnerID:      owner.ID,
+		CreatedBy:    owner.Email,
+		CreatedAt:    time.Now(),
+	}
+
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", err
+		}
+		link.PasswordHash = string(hash)
+		link.HasPassword = true
+	}
+
+	tokenString, err := tokens.Sign(tokens.KindShare, jwt.MapClaims{
+		"link_id":       link.LinkID,
+		"resource_type": link.ResourceType,
+		"resource_id":   link.ResourceID,
+		"role":          string(link.Role),
+		"exp":           link.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	result, err := shareLinksCollection.InsertOne(ctx, link)
+	if err != nil {
+		return nil, "", err
+	}
+	link.ID = result.InsertedID
+
+	return &link, tokenString, nil
+}
+
+// OwnerFilter matches the record the link was made for, links from before
+// owner ids only know the owner's email
+func (link *ShareLink) OwnerFilter() bson.M {
+	if link.OwnerID != "" {
+		return bson.M{"ownerId": link.OwnerID}
+	}
+	return bson.M{"createdBy": link.CreatedBy}
+}
+
+// TransferShareLinks keeps the links of resources working after they changed owner
+func TransferShareLinks(ctx context.Context, resourceType string, resourceIDs []string, owner Principal) error {
+	if shareLinksCollection == nil || len(resourceIDs) == 0 {
+		return nil
+	}
+
+	_, err := shareLinksCollection.UpdateMany(ctx, bson.M{
+		"resourceType": resourceType,
+		"resourceId":   bson.M{"$in": resourceIDs},
+	}, bson.M{"$set": bson.M{"ownerId": owner.ID, "createdBy": owner.Email}})
+	return err
+}
+
+// ListShareLinks returns every link minted for a resource
+func ListShareLinks(ctx context.Context, resourceType, resourceID string) ([]ShareLink, error) {
+	cursor, err := shareLinksCollection.Find(ctx, bson.M{
+		"resourceType": resourceType,
+		"resourceId":   resourceID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var links []ShareLink
+	if err := cursor.All(ctx, &links); err != nil {
+		return nil, err
+	}
+	if links == nil {
+		links = []ShareLink{}
+	}
+	return links, nil
+}
+
+// RevokeShareLink disables a link, false if it does not belong to the resource
+func RevokeShareLink(ctx context.Context, resourceType, resourceID, linkID string) (bool, error) {
+	result, err := shareLinksCollection.UpdateOne(ctx, bson.M{
+		"linkId":       linkID,
+		"resourceType": resourceType,
+		"resourceId":   resourceID,
+	}, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// ValidateShareLink checks the token signature, expiry, revocation and password.
+// wrong passwords count against the link from ip, ErrShareLinkLocked once there are too many
+func ValidateShareLink(tokenString string, password string, ip string) (*ShareLink, error) {
+	claims, err := tokens.Parse(tokens.KindShare, tokenString)
+	if err != nil {
+		return nil, ErrInvalidShareLink
+	}
+
+	linkID, ok := claims["link_id"].(string)
+	if !ok || shareLinksCollection == nil {
+		return nil, ErrInvalidShareLink
+	}
+
+	var link ShareLink
+	err = shareLinksCollection.FindOne(context.Background(), bson.M{"linkId": linkID}).Decode(&link)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidShareLink
+		}
+		return nil, err
+	}
+
+	if link.Revoked || time.Now().After(link.ExpiresAt) {
+		return nil, ErrInvalidShareLink
+	}
+
+	if link.HasPassword {
+		if shareLinkThrottle.LockedFor != nil {
+			wait, err := shareLinkThrottle.LockedFor(link.LinkID, ip)
+			if err != nil {
+				return nil, err
+			}
+			if wait > 0 {
+				return nil, ErrShareLinkLocked
+			}
+		}
+		if password == "" {
+			return nil, ErrShareLinkPassword
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			if shareLinkThrottle.RecordFailure != nil {
+				shareLinkThrottle.RecordFailure(link.LinkID, ip)
+			}
+			return nil, ErrShareLinkPassword
+		}
+	}
+
+	return &link, nil
+}
+
+// ShareLinkMiddleware authenticates link holders from the X-Share-Token header
+// (or "share" query) and an optional X-Share-Password header
+func ShareLinkMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := c.GetHeader("X-Share-Token")
+		if tokenString == "" {
+			tokenString = c.Query("share")
+		}
+		if tokenString == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Share token required"})
+			c.Abort()
+			return
+		}
+
+		link, err := ValidateShareLink(tokenString, c.GetHeader("X-Share-Password"), c.ClientIP())
+		if err != nil {
+			if err == ErrShareLinkLocked {
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many wrong passwords, try again later"})
+			} else if err == ErrShareLinkPassword {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Password required", "passwordRequired": true})
+			} else if err == ErrInvalidShareLink {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired share link"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check share link"})
+			}
+			c.Abort()
+			return
+		}
+
+		c.Set("share_link", link)
+		c.Next()
+	}
+}
+
+func randomID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
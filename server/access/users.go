@@ -0,0 +1,263 @@
+package access
+
+import (
+	"context"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Principal is a signed in user. access is decided by ID, the email is kept
+// for display and for invites sent before the person had an account
+type Principal struct {
+	ID            string          `json:"id"`
+	Email         string          `json:"email"`
+	EmailVerified bool            `json:"-"` // only verified emails match email invites
+	Workspaces    map[string]Role `json:"-"` // workspace id to the user's role in it
+}
+
+var (
+	usersCollection *mongo.Collection
+
+	// looks up a user's workspace roles, set by the workspaces package through main
+	workspaceRoles func(ctx context.Context, userID string) (map[string]Role, error)
+)
+
+// SetUsersCollection sets the users collection used to resolve emails to ids
+func SetUsersCollection(collection *mongo.Collection) {
+	usersCollection = collection
+}
+
+// SetWorkspaceRoles sets how workspace memberships are looked up
+func SetWorkspaceRoles(lookup func(ctx context.Context, userID string) (map[string]Role, error)) {
+	workspaceRoles = lookup
+}
+
+// LoadWorkspaces fills in the workspaces user belongs to
+func LoadWorkspaces(ctx context.Context, user *Principal) error {
+	if workspaceRoles == nil || user.ID == "" {
+		return nil
+	}
+	roles, err := workspaceRoles(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	user.Workspaces = roles
+	return nil
+}
+
+// CurrentUser returns the user AuthMiddleware or RequireScope put on the
+// request, with their workspace roles
+func CurrentUser(c *gin.Context) (Principal, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return Principal{}, false
+	}
+	userEmail, _ := c.Get("user_email")
+	email, _ := userEmail.(string)
+
+	user := Principal{ID: userID.(string), Email: email}
+	// without them the user still reaches what they own or was shared with them
+	if err := LoadWorkspaces(context.Background(), &user); err != nil {
+		log.Printf("load workspaces of %s error: %v", user.ID, err)
+	}
+	// without it email invites just don't match
+	if err := loadEmailVerified(context.Background(), &user); err != nil {
+		log.Printf("load email verification of %s error: %v", user.ID, err)
+	}
+	return user, true
+}
+
+// fills in whether user has verified their email
+func loadEmailVerified(ctx context.Context, user *Principal) error {
+	id, err := bson.ObjectIDFromHex(user.ID)
+	if err != nil || usersCollection == nil {
+		return nil
+	}
+
+	var account struct {
+		EmailVerified bool `bson:"emailVerified"`
+	}
+	err = usersCollection.FindOne(ctx, bson.M{"_id": id},
+		options.FindOne().SetProjection(bson.M{"emailVerified": 1}),
+	).Decode(&account)
+	if err != nil {
+		return err
+	}
+	user.EmailVerified = account.EmailVerified
+	return nil
+}
+
+// LoadPrincipal looks up a user by id along with their workspace roles
+func LoadPrincipal(ctx context.Context, userID string) (Principal, error) {
+	id, err := bson.ObjectIDFromHex(userID)
+	if err != nil || usersCollection == nil {
+		return Principal{}, mongo.ErrNoDocuments
+	}
+
+	var user struct {
+		Email         string `bson:"email"`
+		EmailVerified bool   `bson:"emailVerified"`
+	}
+	if err := usersCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+		return Principal{}, err
+	}
+
+	principal := Principal{ID: userID, Email: user.Email, EmailVerified: user.EmailVerified}
+	if err := LoadWorkspaces(ctx, &principal); err != nil {
+		return Principal{}, err
+	}
+	return principal, nil
+}
+
+// LookupUser finds the account registered with email, mongo.ErrNoDocuments when there is none
+func LookupUser(ctx context.Context, email string) (Principal, error) {
+	if usersCollection == nil {
+		return Principal{}, mongo.ErrNoDocuments
+	}
+
+	var user struct {
+		ID            bson.ObjectID `bson:"_id"`
+		Email         string        `bson:"email"`
+		EmailVerified bool          `bson:"emailVerified"`
+	}
+	err := usersCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{ID: user.ID.Hex(), Email: user.Email, EmailVerified: user.EmailVerified}, nil
+}
+
+// DisplayNames maps user ids to the users' current names, or their emails when
+// they haven't set one. ids without an account are left out
+func DisplayNames(ctx context.Context, userIDs []string) (map[string]string, error) {
+	names := map[string]string{}
+	ids := bson.A{}
+	for _, userID := range userIDs {
+		if id, err := bson.ObjectIDFromHex(userID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 || usersCollection == nil {
+		return names, nil
+	}
+
+	cursor, err := usersCollection.Find(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"name": 1, "email": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var users []struct {
+		ID    bson.ObjectID `bson:"_id"`
+		Name  string        `bson:"name"`
+		Email string        `bson:"email"`
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		names[user.ID.Hex()] = user.Name
+		if user.Name == "" {
+			names[user.ID.Hex()] = user.Email
+		}
+	}
+	return names, nil
+}
+
+// MigrateOwnerIDs fills in ownerId and collaborator user ids on records saved
+// when everything was keyed by email. it only touches records still missing
+// them, so it is cheap to run on every start. collaborators without a
+// verified account stay email invites
+func MigrateOwnerIDs(ctx context.Context, collection *mongo.Collection) error {
+	cursor, err := collection.Find(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"ownerId": bson.M{"$exists": false}},
+			bson.M{"collaborators": bson.M{"$elemMatch": bson.M{"userId": bson.M{"$exists": false}}}},
+		}},
+		options.Find().SetProjection(bson.M{"ownerId": 1, "createdBy": 1, "collaborators": 1}),
+	)
+	if err != nil {
+		return err
+	}
+
+	var records []struct {
+		OwnerID       string         `bson:"ownerId"`
+		CreatedBy     string         `bson:"createdBy"`
+		Collaborators []Collaborator `bson:"collaborators"`
+	}
+	if err := cursor.All(ctx, &records); err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	owners := map[string]bool{}
+	members := map[string]bool{}
+	for _, record := range records {
+		if record.OwnerID == "" && record.CreatedBy != "" {
+			owners[record.CreatedBy] = true
+		}
+		for _, collaborator := range record.Collaborators {
+			if collaborator.UserID == "" {
+				members[collaborator.Email] = true
+			}
+		}
+	}
+
+	var migrated int64
+	for email := range owners {
+		user, err := LookupUser(ctx, email)
+		if err == mongo.ErrNoDocuments {
+			log.Printf("migrate %s: no account for owner %s", collection.Name(), email)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		result, err := collection.UpdateMany(ctx,
+			bson.M{"createdBy": email, "ownerId": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"ownerId": user.ID}},
+		)
+		if err != nil {
+			return err
+		}
+		migrated += result.ModifiedCount
+	}
+
+	for email := range members {
+		user, err := LookupUser(ctx, email)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// whoever registered an address without verifying it may not own it
+		if !user.EmailVerified {
+			continue
+		}
+
+		result, err := collection.UpdateMany(ctx,
+			bson.M{"collaborators": bson.M{"$elemMatch": bson.M{"email": email, "userId": bson.M{"$exists": false}}}},
+			bson.M{"$set": bson.M{"collaborators.$[entry].userId": user.ID}},
+			options.UpdateMany().SetArrayFilters([]interface{}{bson.M{"entry.email": email, "entry.userId": bson.M{"$exists": false}}}),
+		)
+		if err != nil {
+			return err
+		}
+		migrated += result.ModifiedCount
+	}
+
+	if migrated > 0 {
+		log.Printf("Migrated %d %s records to user ids", migrated, collection.Name())
+	}
+	return nil
+}
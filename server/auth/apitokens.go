@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// what a personal access token may do
+const (
+	ScopeProfileRead   = "profile:read"
+	ScopeDocsRead      = "docs:read"
+	ScopeDocsWrite     = "docs:write"
+	ScopeDrawingsRead  = "drawings:read"
+	ScopeDrawingsWrite = "drawings:write"
+
+	// personal tokens start with this so they are easy to tell from JWTs and to spot in leaks
+	apiTokenPrefix = "clb_"
+	// only this many tokens per user
+	maxAPITokens = 50
+	// lastUsedAt is written at most this often
+	apiTokenTouchInterval = time.Minute
+)
+
+var (
+	validScopes = map[string]bool{
+		ScopeProfileRead:   true,
+		ScopeDocsRead:      true,
+		ScopeDocsWrite:     true,
+		ScopeDrawingsRead:  true,
+		ScopeDrawingsWrite: true,
+	}
+
+	apiTokensCollection *mongo.Collection
+)
+
+// APIToken is a long lived personal access token, only its hash is stored
+type APIToken struct {
+	ID         interface{} `json:"-" bson:"_id,omitempty"`
+	TokenID    string      `json:"id" bson:"tokenId"`
+	UserID     string      `json:"-" bson:"userId"`
+	UserEmail  string      `json:"-" bson:"userEmail"` // kept current when the email changes
+	Name       string      `json:"name" bson:"name"`
+	Scopes     []string    `json:"scopes" bson:"scopes"`
+	TokenHash  string      `json:"-" bson:"tokenHash"`
+	Hint       string      `json:"hint" bson:"hint"` // first characters, to recognize a token
+	CreatedAt  time.Time   `json:"createdAt" bson:"createdAt"`
+	LastUsedAt *time.Time  `json:"lastUsedAt" bson:"lastUsedAt"`
+	ExpiresAt  *time.Time  `json:"expiresAt" bson:"expiresAt"` // nil never expires
+	Revoked    bool        `json:"-" bson:"revoked"`
+}
+
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	ExpiresInDays int      `json:"expiresInDays"` // 0 never expires
+}
+
+func isAPIToken(token string) bool {
+	return strings.HasPrefix(token, apiTokenPrefix)
+}
+
+// looks up an unrevoked, unexpired token by its hash
+func findAPIToken(raw string) (*APIToken, error) {
+	var token APIToken
+	err := apiTokensCollection.FindOne(context.Background(), bson.M{
+		"tokenHash": hashToken(raw),
+		"revoked":   false,
+	}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+		return nil, mongo.ErrNoDocuments
+	}
+	// tokens whose owner could not be migrated to a user id belong to nobody
+	if token.UserID == "" {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > apiTokenTouchInterval {
+		now := time.Now()
+		_, _ = apiTokensCollection.UpdateOne(context.Background(),
+			bson.M{"tokenId": token.TokenID},
+			bson.M{"$set": bson.M{"lastUsedAt": now}},
+		)
+	}
+	return &token, nil
+}
+
+// revokes every personal access token of a user, after a password or identity change
+func revokeAPITokens(userID string) error {
+	_, err := apiTokensCollection.UpdateMany(context.Background(),
+		bson.M{"userId": userID, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	return err
+}
+
+// RequireScope lets personal access tokens through when they carry scope.
+// AuthMiddleware never sets the user for a personal token, so routes without
+// RequireScope stay closed to them. browser sessions pass with every scope
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, isToken := c.Get("api_token")
+		if !isToken {
+			c.Next()
+			return
+		}
+
+		token := value.(*APIToken)
+		for _, granted := range token.Scopes {
+			if granted == scope {
+				c.Set("user_id", token.UserID)
+				c.Set("user_email", token.UserEmail)
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the " + scope + " scope"})
+		c.Abort()
+	}
+}
+
+// creates a personal access token, the raw value is returned only here
+func CreateAPIToken(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be 1 to 100 characters"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !validScopes[scope] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + scope})
+			return
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresInDays can't be negative"})
+		return
+	}
+
+	count, err := apiTokensCollection.CountDocuments(context.Background(), bson.M{"userId": userID, "revoked": false})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	if count >= maxAPITokens {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many tokens, revoke unused ones first"})
+		return
+	}
+
+	tokenID, err := randomToken(8)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	raw := apiTokenPrefix + secret
+
+	token := APIToken{
+		TokenID:   tokenID,
+		UserID:    userID.(string),
+		UserEmail: c.GetString("user_email"),
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		TokenHash: hashToken(raw),
+		Hint:      raw[:len(apiTokenPrefix)+6],
+		CreatedAt: time.Now(),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := token.CreatedAt.Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if _, err := apiTokensCollection.InsertOne(context.Background(), token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":    raw,
+		"apiToken": token,
+	})
+}
+
+// lists the user's active personal access tokens
+func GetAPITokens(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	cursor, err := apiTokensCollection.Find(context.Background(),
+		bson.M{"userId": userID, "revoked": false},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var apiTokens []APIToken
+	if err := cursor.All(context.Background(), &apiTokens); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode tokens"})
+		return
+	}
+	if apiTokens == nil {
+		apiTokens = []APIToken{}
+	}
+
+	c.JSON(http.StatusOK, apiTokens)
+}
+
+// revokes one of the user's personal access tokens
+func RevokeAPIToken(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	result, err := apiTokensCollection.UpdateOne(context.Background(),
+		bson.M{"tokenId": c.Param("tokenId"), "userId": userID, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Token revoked successfully",
+	})
+}
@@ -1,8 +1,11 @@
 package auth
 
 import (
+	"collabify-backend/tokens"
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -18,10 +21,17 @@ import (
 )
 
 type User struct {
-	ID       interface{} `json:"id" bson:"_id,omitempty"`
+	ID       bson.ObjectID `json:"id" bson:"_id,omitempty"`
 	Email    string      `json:"email" bson:"email"`
 	Password string      `json:"password" bson:"password"`
 	Name     string      `json:"name" bson:"name"`
+	EmailVerified bool `json:"emailVerified" bson:"emailVerified"`
+	TwoFactorEnabled bool `json:"twoFactorEnabled" bson:"twoFactorEnabled"`
+	TOTP *TOTPSettings `json:"-" bson:"totp,omitempty"`
+	Identities []Identity `json:"identities,omitempty" bson:"identities,omitempty"` // linked SSO accounts
+	AvatarURL string `json:"avatarUrl,omitempty" bson:"avatarUrl,omitempty"`
+	CursorColor string `json:"cursorColor,omitempty" bson:"cursorColor,omitempty"` // presence color, random when empty
+	PendingEmail string `json:"pendingEmail,omitempty" bson:"pendingEmail,omitempty"` // waiting for confirmation
 }
 
 type LoginRequest struct {
@@ -38,22 +48,36 @@ type RegisterRequest struct {
 
 
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"` // access token lifetime in seconds
+	User         User   `json:"user"`
 }
 
 var (
 	usersCollection *mongo.Collection
 	DB_URL          string
 	JWT_KEY         string
-	jwtSecret       []byte
+
+	ErrInvalidToken   = errors.New("invalid token")
+	ErrSessionRevoked = errors.New("session has been revoked")
 )
 
+// AccessClaims is who an access token was issued to. the email is the
+// account's current one, read from the login session
+type AccessClaims struct {
+	UserID    string
+	UserEmail string
+	SessionID string
+}
+
 func init() {
 	_ = godotenv.Load()
 	DB_URL = os.Getenv("DATABASE_URL")
 	JWT_KEY = os.Getenv("JWT_KEY")
-	jwtSecret = []byte(JWT_KEY)
+	loadTokenConfig()
+	loadOIDCConfig()
+	loadMailConfig()
 }
 
 // initializes MongoDB connection
@@ -75,29 +99,142 @@ func InitMongoDB() error {
 	}
 
 	usersCollection = client.Database("collabify").Collection("users")
+	sessionsCollection = client.Database("collabify").Collection("sessions")
+	oidcStatesCollection = client.Database("collabify").Collection("oidc_states")
+	oneTimeTokensCollection = client.Database("collabify").Collection("one_time_tokens")
+	loginAttemptsCollection = client.Database("collabify").Collection("login_attempts")
+	authAuditCollection = client.Database("collabify").Collection("auth_audit")
+	apiTokensCollection = client.Database("collabify").Collection("api_tokens")
 	
 	fmt.Println("Connected to MongoDB!")
 	return nil
 }
 
+// EnsureIndexes creates the indexes auth lookups rely on. the session lookup
+// runs on every request, and sessions and login counters clean themselves up
+// once they expire
+func EnsureIndexes(ctx context.Context) error {
+	indexes := map[*mongo.Collection][]mongo.IndexModel{
+		sessionsCollection: {
+			{Keys: bson.D{{Key: "sessionId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userId", Value: 1}}},
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		apiTokensCollection: {
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userId", Value: 1}}},
+		},
+		// one counter per key across server instances
+		loginAttemptsCollection: {
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	}
+	for collection, models := range indexes {
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("%s: %w", collection.Name(), err)
+		}
+	}
+	return nil
+}
+
+// MigrateUserIDs adds user ids to sessions and personal access tokens created
+// while everything was keyed by email. run at startup, records that already
+// have one are left alone
+func MigrateUserIDs() error {
+	ctx := context.Background()
+
+	for _, collection := range []*mongo.Collection{sessionsCollection, apiTokensCollection} {
+		cursor, err := collection.Find(ctx,
+			bson.M{"userId": bson.M{"$exists": false}},
+			options.Find().SetProjection(bson.M{"userEmail": 1}),
+		)
+		if err != nil {
+			return err
+		}
+		var records []struct {
+			UserEmail string `bson:"userEmail"`
+		}
+		if err := cursor.All(ctx, &records); err != nil {
+			return err
+		}
+
+		emails := map[string]bool{}
+		for _, record := range records {
+			emails[record.UserEmail] = true
+		}
+
+		for email := range emails {
+			var user User
+			err := usersCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+			if err == mongo.ErrNoDocuments {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			_, err = collection.UpdateMany(ctx,
+				bson.M{"userEmail": email, "userId": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"userId": user.ID.Hex()}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+		if len(records) > 0 {
+			log.Printf("Migrated %d %s to user ids", len(records), collection.Name())
+		}
+	}
+	return nil
+}
+
 // returns the users collection for use in other packages
 func GetUsersCollection() *mongo.Collection {
 	return usersCollection
 }
 
-// generates a JWT token for a user
-func GenerateJWT(userEmail string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// generates a short lived access token for a user's login session, sub is the
+// user id and user_email is only for display
+func GenerateJWT(userID string, userEmail string, sessionID string) (string, error) {
+	return tokens.Sign(tokens.KindAccess, jwt.MapClaims{
+		"sub":        userID,
 		"user_email": userEmail,
-		"exp":        time.Now().Add(time.Hour * 24 * 2).Unix(), // 2 dayys
+		"sid":        sessionID,
+		"exp":        time.Now().Add(accessTokenTTL).Unix(),
 	})
+}
+
+// validates an access token and its login session, shared by REST and WebSocket auth
+func ParseAccessToken(tokenString string) (*AccessClaims, error) {
+	claims, err := tokens.Parse(tokens.KindAccess, tokenString)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// revoked or logged out sessions are rejected even before the token expires
+	sessionID, _ := claims["sid"].(string)
+	session, ok := activeSession(sessionID)
+	if !ok {
+		return nil, ErrSessionRevoked
+	}
 
-	tokenString, err := token.SignedString(jwtSecret)
+	// tokens from before user ids carry no sub, their session knows the user
+	userID, err := sessionUserID(session)
 	if err != nil {
-		return "", err
+		return nil, ErrInvalidToken
+	}
+	if subject, _ := claims["sub"].(string); subject != "" && subject != userID {
+		return nil, ErrInvalidToken
 	}
 
-	return tokenString, nil
+	return &AccessClaims{UserID: userID, UserEmail: session.UserEmail, SessionID: session.SessionID}, nil
+}
+
+// publishes the public keys tokens are signed with so other services can verify them
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, tokens.PublicKeys())
 }
 
 // hashes a password using bcrypt
@@ -147,19 +284,24 @@ func Register(c *gin.Context) {
 
 	// Get the inserted ID as string
 	insertedID := result.InsertedID
-	user.ID = insertedID
+	user.ID = insertedID.(bson.ObjectID)
 	user.Password = "" 
 
-	// Generate JWT using email
-	token, err := GenerateJWT(user.Email)
+	// the account works right away, the email gets a link to verify it
+	go sendVerificationEmail(user.Email)
+
+	// Generate JWT and refresh token for a new session
+	token, refreshToken, err := createSession(&user, c.Request.UserAgent())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, AuthResponse{
-		Token: token,
-		User:  user,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		User:         user,
 	})
 }
 
@@ -171,31 +313,57 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	// locked accounts and IPs are turned away before the password is checked
+	if rejectIfLocked(c, req.Email) {
+		return
+	}
+
 	var user User
 	err := usersCollection.FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&user)
 	if err != nil {
+		recordLoginFailure(c, req.Email)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	// check password
 	if !CheckPasswordHash(req.Password, user.Password) {
+		recordLoginFailure(c, req.Email)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	// with 2FA on the password alone only earns a challenge, failures keep counting until the code
+	if user.TwoFactorEnabled {
+		challengeToken, err := issueChallenge(user.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, TwoFactorChallenge{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+			ExpiresIn:         int(challengeLifetime.Seconds()),
+		})
+		return
+	}
+
+	accountThrottle.reset(req.Email)
+
 	user.Password = "" 
 
-	// Generate JWT using email
-	token, err := GenerateJWT(user.Email)
+	// Generate JWT and refresh token for a new session
+	token, refreshToken, err := createSession(&user, c.Request.UserAgent())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, AuthResponse{
-		Token: token,
-		User:  user,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		User:         user,
 	})
 }
 
@@ -211,52 +379,42 @@ func AuthMiddleware() gin.HandlerFunc {
 		// get token from "Bearer <token>"
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 
-		// parse and validate token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		// personal access tokens only get a user on routes guarded by RequireScope
+		if isAPIToken(tokenString) {
+			apiToken, err := findAPIToken(tokenString)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
 			}
-			return jwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
-
-		// get user email from token
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
+			c.Set("api_token", apiToken)
+			c.Next()
 			return
 		}
 
-		userEmail, ok := claims["user_email"].(string)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user email in token"})
+		// parse and validate token
+		claims, err := ParseAccessToken(tokenString)
+		if err != nil {
+			message := "Invalid token"
+			if err == ErrSessionRevoked {
+				message = "Session has been revoked"
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": message})
 			c.Abort()
 			return
 		}
 
-		c.Set("user_email", userEmail)
+		c.Set("user_id", claims.UserID)
+		c.Set("user_email", claims.UserEmail)
+		c.Set("session_id", claims.SessionID)
 		c.Next()
 	}
 }
 
 // returns the current user's profile
 func GetProfile(c *gin.Context) {
-	userEmail, exists := c.Get("user_email")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
-		return
-	}
-
-	var user User
-	err := usersCollection.FindOne(context.Background(), bson.M{"email": userEmail}).Decode(&user)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	user, ok := currentUser(c)
+	if !ok {
 		return
 	}
 
@@ -0,0 +1,644 @@
+package auth
+
+import (
+	"collabify-backend/tokens"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	// how long a user has to finish signing in at the provider
+	oidcStateLifetime = 10 * time.Minute
+	// discovery documents are refetched after this
+	oidcDiscoveryTTL = time.Hour
+	// unknown key ids trigger a JWKS refetch at most this often
+	oidcKeysRefetchInterval = time.Minute
+	oidcClockSkew           = 30 * time.Second
+	// ties a pending sign in to the browser that started it
+	oidcStateCookie = "collabify_oidc_state"
+)
+
+var (
+	oidc                 *oidcProvider // nil when SSO is not configured
+	oidcStatesCollection *mongo.Collection
+	oidcHTTPClient       = &http.Client{Timeout: 10 * time.Second}
+
+	ErrOIDCNotConfigured = errors.New("SSO is not configured")
+)
+
+// OIDCConfig is the client registration at the identity provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for public clients, PKCE still applies
+	RedirectURL  string // our /api/auth/oidc/callback
+	FrontendURL  string // where tokens are handed to the app, JSON is returned when empty
+	Scopes       []string
+}
+
+// Identity links a user to an account at an external provider
+type Identity struct {
+	Provider string    `json:"provider" bson:"provider"` // issuer url
+	Subject  string    `json:"subject" bson:"subject"`
+	Email    string    `json:"email" bson:"email"`
+	LinkedAt time.Time `json:"linkedAt" bson:"linkedAt"`
+}
+
+// IDTokenClaims are the ID token claims we use
+type IDTokenClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// pending sign in, removed when the callback uses it
+type oidcState struct {
+	State        string    `bson:"state"`
+	Nonce        string    `bson:"nonce"`
+	CodeVerifier string    `bson:"codeVerifier"`
+	ExpiresAt    time.Time `bson:"expiresAt"`
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type oidcProvider struct {
+	mutex         sync.Mutex
+	config        OIDCConfig
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// reads OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URL,
+// OIDC_FRONTEND_URL and OIDC_SCOPES, SSO stays off without an issuer and client id
+func loadOIDCConfig() {
+	config := OIDCConfig{
+		Issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		FrontendURL:  os.Getenv("OIDC_FRONTEND_URL"),
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+	}
+	if config.Issuer == "" || config.ClientID == "" {
+		return
+	}
+	if config.RedirectURL == "" {
+		log.Printf("OIDC_REDIRECT_URL is required for SSO, SSO is disabled")
+		return
+	}
+	ConfigureOIDC(config)
+}
+
+// ConfigureOIDC turns on SSO with the given provider
+func ConfigureOIDC(config OIDCConfig) {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	oidc = &oidcProvider{config: config}
+}
+
+// fetches and caches the provider's discovery document
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", discovery.Issuer, p.config.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+
+	p.discovery = &discovery
+	p.discoveredAt = time.Now()
+	return p.discovery, nil
+}
+
+// returns the provider key for a kid, refetching the JWKS when the provider rotated keys
+func (p *oidcProvider) publicKey(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// providers with a single key may leave out the kid
+	find := func() (crypto.PublicKey, bool) {
+		if keyID == "" && len(p.keys) == 1 {
+			for _, key := range p.keys {
+				return key, true
+			}
+		}
+		key, exists := p.keys[keyID]
+		return key, exists
+	}
+
+	if key, exists := find(); exists {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetchedAt) < oidcKeysRefetchInterval {
+		return nil, fmt.Errorf("unknown key id %q", keyID)
+	}
+
+	var set tokens.JWKS
+	if err := getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			log.Printf("skipping provider key %q: %v", jwk.KeyID, err)
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	key, exists := find()
+	if !exists {
+		return nil, fmt.Errorf("unknown key id %q", keyID)
+	}
+	return key, nil
+}
+
+// AuthCodeURL builds the provider sign in url for one attempt
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange trades an authorization code for the raw ID token
+func (p *oidcProvider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body oidcTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("oidc token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("oidc token exchange failed: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token
+func (p *oidcProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*IDTokenClaims, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, keyID)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(oidcClockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	// tokens issued to several clients must name us as the authorized party
+	if audience, _ := claims.GetAudience(); len(audience) > 1 && claims["azp"] != p.config.ClientID {
+		return nil, errors.New("invalid id token: azp does not match client id")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce == "" || tokenNonce != nonce {
+		return nil, errors.New("invalid id token: nonce mismatch")
+	}
+
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, errors.New("invalid id token: missing sub")
+	}
+
+	result := &IDTokenClaims{Subject: subject}
+	result.Email, _ = claims["email"].(string)
+	result.Name, _ = claims["name"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+	return result, nil
+}
+
+func getJSON(ctx context.Context, target string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// S256 code challenge for a PKCE verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// finds the user for an external identity, linking or creating one on first sign in
+func linkOIDCUser(provider string, claims *IDTokenClaims) (*User, error) {
+	ctx := context.Background()
+
+	var user User
+	err := usersCollection.FindOne(ctx, bson.M{
+		"identities.provider": provider,
+		"identities.subject":  claims.Subject,
+	}).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	if claims.Email == "" {
+		return nil, errors.New("the identity provider did not share an email address")
+	}
+
+	identity := Identity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		LinkedAt: time.Now(),
+	}
+
+	err = usersCollection.FindOne(ctx, bson.M{"email": claims.Email}).Decode(&user)
+	if err == nil {
+		// only a verified email proves the account is theirs
+		if !claims.EmailVerified {
+			return nil, errors.New("an account with this email exists, sign in with your password to link it")
+		}
+		if !user.EmailVerified {
+			return takeOverUnverifiedUser(&user, identity)
+		}
+		_, err = usersCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+			"$push": bson.M{"identities": identity},
+		})
+		if err != nil {
+			return nil, err
+		}
+		user.Identities = append(user.Identities, identity)
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = strings.Split(claims.Email, "@")[0]
+	}
+
+	// sso users have no password, Login never matches an empty hash
+	user = User{
+		Email:         claims.Email,
+		Name:          name,
+		EmailVerified: claims.EmailVerified,
+		Identities:    []Identity{identity},
+	}
+	result, err := usersCollection.InsertOne(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	user.ID = result.InsertedID.(bson.ObjectID)
+	if user.EmailVerified {
+		emailVerified(&user)
+	}
+	return &user, nil
+}
+
+// links an identity to a local account that never verified its email. whoever
+// registered it may not own the address, so their password, 2FA, pending email
+// change, sessions and tokens are dropped and the account goes to the provider's user
+func takeOverUnverifiedUser(user *User, identity Identity) (*User, error) {
+	ctx := context.Background()
+
+	result, err := usersCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "emailVerified": false},
+		bson.M{
+			"$push":  bson.M{"identities": identity},
+			"$set":   bson.M{"emailVerified": true, "twoFactorEnabled": false},
+			"$unset": bson.M{"password": "", "totp": "", "pendingEmail": ""},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, errors.New("the account changed while signing in, please try again")
+	}
+
+	userID := user.ID.Hex()
+	if err := revokeSessions(bson.M{"userId": userID, "revoked": false}); err != nil {
+		log.Printf("revoke sessions of unverified account %s error: %v", userID, err)
+	}
+	if err := revokeAPITokens(userID); err != nil {
+		log.Printf("revoke api tokens of unverified account %s error: %v", userID, err)
+	}
+	if err := invalidateOneTimeTokens(PurposeChangeEmail, user.Email); err != nil {
+		log.Printf("invalidate email change tokens of unverified account %s error: %v", userID, err)
+	}
+
+	log.Printf("SSO sign in took over unverified account %s", userID)
+	user.Password = ""
+	user.TOTP = nil
+	user.TwoFactorEnabled = false
+	user.PendingEmail = ""
+	user.EmailVerified = true
+	user.Identities = append(user.Identities, identity)
+	emailVerified(user)
+	return user, nil
+}
+
+// remembers which sign in the browser started, OIDCLogin stores a hash of the state
+func setOIDCStateCookie(c *gin.Context, state string, maxAge int) {
+	secure := strings.HasPrefix(oidc.config.RedirectURL, "https://")
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   maxAge,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// redirects to the identity provider to sign in
+func OIDCLogin(c *gin.Context) {
+	if oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrOIDCNotConfigured.Error()})
+		return
+	}
+
+	state, err := randomToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign in"})
+		return
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign in"})
+		return
+	}
+	codeVerifier, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign in"})
+		return
+	}
+
+	authURL, err := oidc.AuthCodeURL(c.Request.Context(), state, nonce, pkceChallenge(codeVerifier))
+	if err != nil {
+		log.Printf("oidc login error: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	_, err = oidcStatesCollection.InsertOne(context.Background(), oidcState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(oidcStateLifetime),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign in"})
+		return
+	}
+
+	setOIDCStateCookie(c, hashToken(state), int(oidcStateLifetime.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// finishes sign in after the provider redirects back with a code
+func OIDCCallback(c *gin.Context) {
+	if oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrOIDCNotConfigured.Error()})
+		return
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		oidcFail(c, http.StatusUnauthorized, "Sign in was cancelled or denied")
+		return
+	}
+
+	code, stateParam := c.Query("code"), c.Query("state")
+	if code == "" || stateParam == "" {
+		oidcFail(c, http.StatusBadRequest, "Missing code or state")
+		return
+	}
+
+	// the callback must land in the browser that started the sign in, so nobody
+	// can finish their own sign in in someone else's browser
+	cookie, err := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie), []byte(hashToken(stateParam))) != 1 {
+		oidcFail(c, http.StatusBadRequest, "Sign in was started in another browser, please try again")
+		return
+	}
+
+	// each state works once
+	var state oidcState
+	err = oidcStatesCollection.FindOneAndDelete(context.Background(), bson.M{"state": stateParam}).Decode(&state)
+	if err != nil || time.Now().After(state.ExpiresAt) {
+		oidcFail(c, http.StatusBadRequest, "Sign in expired, please try again")
+		return
+	}
+
+	ctx := c.Request.Context()
+	rawIDToken, err := oidc.Exchange(ctx, code, state.CodeVerifier)
+	if err != nil {
+		log.Printf("oidc exchange error: %v", err)
+		oidcFail(c, http.StatusBadGateway, "Could not complete sign in with the identity provider")
+		return
+	}
+
+	claims, err := oidc.VerifyIDToken(ctx, rawIDToken, state.Nonce)
+	if err != nil {
+		log.Printf("oidc verify error: %v", err)
+		oidcFail(c, http.StatusUnauthorized, "Invalid identity token")
+		return
+	}
+
+	user, err := linkOIDCUser(oidc.config.Issuer, claims)
+	if err != nil {
+		log.Printf("oidc link user error: %v", err)
+		oidcFail(c, http.StatusConflict, err.Error())
+		return
+	}
+
+	// the identity provider stands in for the password, 2FA accounts still need their code
+	if user.TwoFactorEnabled {
+		challengeToken, err := issueChallenge(user.Email)
+		if err != nil {
+			oidcFail(c, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		challenge := TwoFactorChallenge{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+			ExpiresIn:         int(challengeLifetime.Seconds()),
+		}
+		if oidc.config.FrontendURL == "" {
+			c.JSON(http.StatusOK, challenge)
+			return
+		}
+		fragment := url.Values{
+			"twoFactorRequired": {"true"},
+			"challengeToken":    {challenge.ChallengeToken},
+			"expiresIn":         {fmt.Sprint(challenge.ExpiresIn)},
+		}
+		c.Redirect(http.StatusFound, oidc.config.FrontendURL+"#"+fragment.Encode())
+		return
+	}
+
+	token, refreshToken, err := createSession(user, c.Request.UserAgent())
+	if err != nil {
+		oidcFail(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	user.Password = ""
+	response := AuthResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		User:         *user,
+	}
+
+	if oidc.config.FrontendURL == "" {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// the fragment never reaches servers or logs
+	fragment := url.Values{
+		"token":        {response.Token},
+		"refreshToken": {response.RefreshToken},
+		"expiresIn":    {fmt.Sprint(response.ExpiresIn)},
+	}
+	c.Redirect(http.StatusFound, oidc.config.FrontendURL+"#"+fragment.Encode())
+}
+
+// reports a failed sign in to the app, or as JSON when there is no app url
+func oidcFail(c *gin.Context, status int, message string) {
+	if oidc.config.FrontendURL == "" {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+	c.Redirect(http.StatusFound, oidc.config.FrontendURL+"#"+url.Values{"error": {message}}.Encode())
+}
@@ -0,0 +1,487 @@
+package auth
+
+import (
+	"collabify-backend/access"
+	"collabify-backend/mail"
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	cursorColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+	// packages that store user emails follow account changes through these
+	accountHooks []AccountHooks
+)
+
+// AccountHooks are called when an account changes email, verifies one or is
+// deleted. they let documents and drawings follow along without auth importing them
+type AccountHooks struct {
+	EmailChanged   func(user access.Principal, oldEmail string) error
+	EmailVerified  func(user access.Principal) error                              // the account now provably owns user.Email
+	AccountDeleted func(user access.Principal, transferTo access.Principal) error // empty transferTo deletes owned content
+}
+
+// fields left out of the request are not changed, "" clears avatarUrl and cursorColor
+type UpdateProfileRequest struct {
+	Name        *string `json:"name"`
+	AvatarURL   *string `json:"avatarUrl"`
+	CursorColor *string `json:"cursorColor"` // #rrggbb
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"newEmail" binding:"required"`
+	Password string `json:"password"`
+}
+
+type DeleteAccountRequest struct {
+	Password   string `json:"password"`
+	Code       string `json:"code"`       // needed when 2FA is on
+	TransferTo string `json:"transferTo"` // email of a user to give owned documents and drawings to
+}
+
+// OnAccountChanges registers hooks for email changes and deleted accounts
+func OnAccountChanges(hooks AccountHooks) {
+	accountHooks = append(accountHooks, hooks)
+}
+
+// tells every package the account proved it owns its email, so invites sent
+// to the address can be bound to it
+func emailVerified(user *User) {
+	principal := access.Principal{ID: user.ID.Hex(), Email: user.Email, EmailVerified: true}
+	for _, hooks := range accountHooks {
+		if hooks.EmailVerified == nil {
+			continue
+		}
+		if err := hooks.EmailVerified(principal); err != nil {
+			log.Printf("email verified for %s hook error: %v", user.Email, err)
+		}
+	}
+}
+
+// accepts absolute http(s) urls only, so avatars can't be javascript: or data: urls
+func validAvatarURL(value string) bool {
+	if len(value) > 2048 {
+		return false
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// updates the name, avatar and cursor color of the current user
+func UpdateProfile(c *gin.Context) {
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	set := bson.M{}
+	unset := bson.M{}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || len(name) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be 1 to 100 characters"})
+			return
+		}
+		set["name"] = name
+	}
+
+	if req.AvatarURL != nil {
+		avatarURL := strings.TrimSpace(*req.AvatarURL)
+		if avatarURL == "" {
+			unset["avatarUrl"] = ""
+		} else if !validAvatarURL(avatarURL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar URL must be an http or https URL"})
+			return
+		} else {
+			set["avatarUrl"] = avatarURL
+		}
+	}
+
+	if req.CursorColor != nil {
+		color := strings.TrimSpace(*req.CursorColor)
+		if color == "" {
+			unset["cursorColor"] = ""
+		} else if !cursorColorPattern.MatchString(color) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cursor color must look like #1a2b3c"})
+			return
+		} else {
+			set["cursorColor"] = strings.ToLower(color)
+		}
+	}
+
+	if len(set) == 0 && len(unset) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var updated User
+	err := usersCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": user.ID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	updated.Password = ""
+	c.JSON(http.StatusOK, updated)
+}
+
+// checks the password of a signed in user for sensitive changes, wrong guesses
+// count against the login throttle. writes the response and returns false on failure
+func confirmPassword(c *gin.Context, user *User, password string) bool {
+	if rejectIfLocked(c, user.Email) {
+		return false
+	}
+	if !CheckPasswordHash(password, user.Password) {
+		recordLoginFailure(c, user.Email)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return false
+	}
+	return true
+}
+
+// changes the password and signs out every other session
+func ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	// SSO only accounts have no password to confirm, they set one with a reset link
+	if user.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This account has no password, use password reset to set one"})
+		return
+	}
+	if !confirmPassword(c, user, req.CurrentPassword) {
+		return
+	}
+
+	hashedPassword, err := HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	_, err = usersCollection.UpdateOne(context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"password": hashedPassword}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	// reset links sent before the change must not undo it
+	if err := invalidateOneTimeTokens(PurposeResetPassword, user.Email); err != nil {
+		log.Printf("invalidate reset tokens for %s error: %v", user.Email, err)
+	}
+
+	sessionID, _ := c.Get("session_id")
+	err = revokeSessions(bson.M{"userId": user.ID.Hex(), "revoked": false, "sessionId": bson.M{"$ne": sessionID}})
+	if err != nil {
+		log.Printf("revoke other sessions after password change for %s error: %v", user.Email, err)
+	}
+
+	// a password change after a compromise must also cut off stolen personal access tokens
+	if err := revokeAPITokens(user.ID.Hex()); err != nil {
+		log.Printf("revoke api tokens after password change for %s error: %v", user.Email, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password changed successfully, personal access tokens were revoked",
+	})
+}
+
+// starts an email change, the new address gets a link and nothing changes until it is opened
+func RequestEmailChange(c *gin.Context) {
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	newEmail := strings.TrimSpace(req.NewEmail)
+	if !strings.Contains(newEmail, "@") || len(newEmail) > 254 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "That is already your email address"})
+		return
+	}
+	if user.Password != "" && !confirmPassword(c, user, req.Password) {
+		return
+	}
+
+	count, err := usersCollection.CountDocuments(context.Background(), bson.M{"email": newEmail})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already in use"})
+		return
+	}
+
+	// only the newest link works
+	if err := invalidateOneTimeTokens(PurposeChangeEmail, user.Email); err != nil {
+		log.Printf("invalidate email change tokens for %s error: %v", user.Email, err)
+	}
+
+	token, err := storeOneTimeToken(OneTimeToken{
+		Purpose:   PurposeChangeEmail,
+		UserID:    user.ID.Hex(),
+		UserEmail: user.Email,
+		NewEmail:  newEmail,
+	}, changeEmailTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	_, err = usersCollection.UpdateOne(context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"pendingEmail": newEmail}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = mailer.Send(ctx, mail.Message{
+		To:      newEmail,
+		Subject: "Confirm your new Collabify email",
+		Body: "Open the link below to use this address for your Collabify account:\n\n" +
+			appLink("/confirm-email", token) + "\n\nThe link expires in 24 hours.",
+	})
+	if err != nil {
+		log.Printf("send email change link to %s error: %v", newEmail, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send confirmation email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Check your new email address for a confirmation link",
+	})
+}
+
+// switches the account to the confirmed address and, like a password reset,
+// revokes every session and personal access token
+func ConfirmEmailChange(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := consumeOneTimeToken(PurposeChangeEmail, req.Token)
+	if err != nil {
+		if err == ErrInvalidOneTimeToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+	ctx := context.Background()
+	newEmail := token.NewEmail
+
+	// someone may have registered the address since the link was sent
+	count, err := usersCollection.CountDocuments(ctx, bson.M{"email": newEmail})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already in use"})
+		return
+	}
+
+	userID, err := bson.ObjectIDFromHex(token.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation link"})
+		return
+	}
+
+	var user User
+	err = usersCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID, "pendingEmail": newEmail},
+		bson.M{
+			"$set":   bson.M{"email": newEmail, "emailVerified": true},
+			"$unset": bson.M{"pendingEmail": ""},
+		},
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+	oldEmail := user.Email
+
+	// the identity changed, every session and personal access token has to sign in again
+	if err := revokeSessions(bson.M{"userId": token.UserID, "revoked": false}); err != nil {
+		log.Printf("revoke sessions of %s after email change error: %v", token.UserID, err)
+	}
+	if err := revokeAPITokens(token.UserID); err != nil {
+		log.Printf("revoke api tokens of %s after email change error: %v", token.UserID, err)
+	}
+	if _, err := oneTimeTokensCollection.UpdateMany(ctx,
+		bson.M{"userEmail": oldEmail, "usedAt": nil},
+		bson.M{"$set": bson.M{"usedAt": time.Now()}},
+	); err != nil {
+		log.Printf("invalidate one-time tokens for %s error: %v", oldEmail, err)
+	}
+
+	principal := access.Principal{ID: token.UserID, Email: newEmail, EmailVerified: true}
+	for _, hooks := range accountHooks {
+		if hooks.EmailChanged == nil {
+			continue
+		}
+		if err := hooks.EmailChanged(principal, oldEmail); err != nil {
+			log.Printf("email change from %s to %s hook error: %v", oldEmail, newEmail, err)
+		}
+	}
+	user.Email = newEmail
+	emailVerified(&user)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email changed successfully, please sign in again",
+		"email":   newEmail,
+	})
+}
+
+// deletes the current account. owned documents and drawings go to transferTo
+// or are deleted, and every session and token of the user stops working
+func DeleteAccount(c *gin.Context) {
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.Password != "" && !confirmPassword(c, user, req.Password) {
+		return
+	}
+	if user.TwoFactorEnabled {
+		valid, err := checkSecondFactor(user, req.Code)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check code"})
+			return
+		}
+		if !valid {
+			recordLoginFailure(c, user.Email)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+	}
+	ctx := context.Background()
+
+	var transferTo access.Principal
+	if email := strings.TrimSpace(req.TransferTo); email != "" {
+		if strings.EqualFold(email, user.Email) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Can't transfer to the account being deleted"})
+			return
+		}
+		var recipient User
+		err := usersCollection.FindOne(ctx, bson.M{"email": email}).Decode(&recipient)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Transfer recipient not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+			return
+		}
+		transferTo = access.Principal{ID: recipient.ID.Hex(), Email: recipient.Email}
+	}
+
+	// content is handled first, so a failure leaves the account in place to retry
+	principal := access.Principal{ID: user.ID.Hex(), Email: user.Email}
+	for _, hooks := range accountHooks {
+		if hooks.AccountDeleted == nil {
+			continue
+		}
+		if err := hooks.AccountDeleted(principal, transferTo); err != nil {
+			log.Printf("account deletion hook for %s error: %v", user.Email, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clean up documents and drawings, please try again"})
+			return
+		}
+	}
+
+	if _, err := usersCollection.DeleteOne(ctx, bson.M{"_id": user.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	if err := revokeSessions(bson.M{"userId": principal.ID, "revoked": false}); err != nil {
+		log.Printf("revoke sessions of deleted account %s error: %v", user.Email, err)
+	}
+	if _, err := apiTokensCollection.DeleteMany(ctx, bson.M{"userId": principal.ID}); err != nil {
+		log.Printf("delete api tokens of %s error: %v", user.Email, err)
+	}
+	if _, err := oneTimeTokensCollection.DeleteMany(ctx, bson.M{"userEmail": user.Email}); err != nil {
+		log.Printf("delete one-time tokens of %s error: %v", user.Email, err)
+	}
+	accountThrottle.reset(user.Email)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Account deleted successfully",
+	})
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestConfirmEmailChangeRevokesCredentials(t *testing.T) {
+	testDatabase(t)
+	user := testAccount(t, "old@example.com")
+
+	_, err := usersCollection.UpdateOne(context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"pendingEmail": "new@example.com"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := storeOneTimeToken(OneTimeToken{
+		Purpose:   PurposeChangeEmail,
+		UserID:    user.ID.Hex(),
+		UserEmail: user.Email,
+		NewEmail:  "new@example.com",
+	}, changeEmailTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := postJSON(t, ConfirmEmailChange, VerifyEmailRequest{Token: token})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	if sessions, apiTokens := activeCredentials(t, user); sessions != 0 || apiTokens != 0 {
+		t.Errorf("after the email change %d sessions and %d personal access tokens still work", sessions, apiTokens)
+	}
+}
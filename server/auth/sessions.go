@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+var (
+	// lifetime of the JWT sent with every request
+	accessTokenTTL = 15 * time.Minute
+	// lifetime of a login, refreshing rotates the token but keeps the expiry
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	sessionsCollection *mongo.Collection
+
+	// called with the revoked session ids so live connections can be closed
+	sessionsRevokedHook func(sessionIDs []string)
+
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+// Session is one login, it owns the current refresh token
+type Session struct {
+	ID         interface{} `json:"-" bson:"_id,omitempty"`
+	SessionID  string      `json:"sessionId" bson:"sessionId"`
+	UserID     string      `json:"userId" bson:"userId"`
+	UserEmail  string      `json:"userEmail" bson:"userEmail"` // kept current when the email changes
+	TokenHash  string      `json:"-" bson:"tokenHash"`         // sha256 of the current refresh secret
+	UserAgent  string      `json:"userAgent" bson:"userAgent"`
+	Revoked    bool        `json:"revoked" bson:"revoked"`
+	CreatedAt  time.Time   `json:"createdAt" bson:"createdAt"`
+	LastUsedAt time.Time   `json:"lastUsedAt" bson:"lastUsedAt"`
+	ExpiresAt  time.Time   `json:"expiresAt" bson:"expiresAt"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+	All          bool   `json:"all"` // sign out every device of this user
+}
+
+// reads ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL (Go durations like "15m"), called from init
+func loadTokenConfig() {
+	if value := os.Getenv("ACCESS_TOKEN_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			accessTokenTTL = d
+		} else {
+			log.Printf("ignoring invalid ACCESS_TOKEN_TTL %q", value)
+		}
+	}
+	if value := os.Getenv("REFRESH_TOKEN_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			refreshTokenTTL = d
+		} else {
+			log.Printf("ignoring invalid REFRESH_TOKEN_TTL %q", value)
+		}
+	}
+}
+
+// OnSessionsRevoked registers a callback for revoked sessions, used by the socket package
+func OnSessionsRevoked(hook func(sessionIDs []string)) {
+	sessionsRevokedHook = hook
+}
+
+// starts a new login session and returns an access token and refresh token for it
+func createSession(user *User, userAgent string) (string, string, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	session := Session{
+		SessionID:  sessionID,
+		UserID:     user.ID.Hex(),
+		UserEmail:  user.Email,
+		TokenHash:  hashToken(secret),
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL),
+	}
+
+	if _, err := sessionsCollection.InsertOne(context.Background(), session); err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := GenerateJWT(session.UserID, session.UserEmail, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, sessionID + "." + secret, nil
+}
+
+// finds the session a refresh token belongs to and checks the secret is the current one.
+// presenting an already rotated token means it leaked, so the whole session is revoked
+func findSessionForRefresh(refreshToken string) (*Session, error) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var session Session
+	err := sessionsCollection.FindOne(context.Background(), bson.M{"sessionId": sessionID}).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if session.Revoked || time.Now().After(session.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if hashToken(secret) != session.TokenHash {
+		log.Printf("Refresh token reuse detected for session %s, revoking it", sessionID)
+		if err := revokeSessions(bson.M{"sessionId": sessionID}); err != nil {
+			log.Printf("revoke session %s error: %v", sessionID, err)
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return &session, nil
+}
+
+// marks matching sessions revoked and tells live connections to close
+func revokeSessions(filter bson.M) error {
+	ctx := context.Background()
+
+	cursor, err := sessionsCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var sessions []Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return err
+	}
+
+	if _, err := sessionsCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}}); err != nil {
+		return err
+	}
+
+	if sessionsRevokedHook != nil && len(sessions) > 0 {
+		sessionIDs := make([]string, len(sessions))
+		for i, session := range sessions {
+			sessionIDs[i] = session.SessionID
+		}
+		sessionsRevokedHook(sessionIDs)
+	}
+	return nil
+}
+
+// IsSessionActive reports whether an access token's session is still valid
+func IsSessionActive(sessionID string) bool {
+	_, ok := activeSession(sessionID)
+	return ok
+}
+
+// loads a session that is neither revoked nor expired
+func activeSession(sessionID string) (*Session, bool) {
+	if sessionsCollection == nil || sessionID == "" {
+		return nil, false
+	}
+
+	var session Session
+	err := sessionsCollection.FindOne(context.Background(), bson.M{"sessionId": sessionID}).Decode(&session)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("load session %s error: %v", sessionID, err)
+		}
+		return nil, false
+	}
+
+	if session.Revoked || !time.Now().Before(session.ExpiresAt) {
+		return nil, false
+	}
+	return &session, true
+}
+
+// the user id of a session, looked up by email and stored for sessions
+// started before sessions kept it
+func sessionUserID(session *Session) (string, error) {
+	if session.UserID != "" {
+		return session.UserID, nil
+	}
+
+	var user User
+	err := usersCollection.FindOne(context.Background(), bson.M{"email": session.UserEmail}).Decode(&user)
+	if err != nil {
+		return "", err
+	}
+
+	session.UserID = user.ID.Hex()
+	_, err = sessionsCollection.UpdateOne(context.Background(),
+		bson.M{"sessionId": session.SessionID},
+		bson.M{"$set": bson.M{"userId": session.UserID}},
+	)
+	if err != nil {
+		log.Printf("store user id of session %s error: %v", session.SessionID, err)
+	}
+	return session.UserID, nil
+}
+
+// exchanges a refresh token for a new access token and a rotated refresh token
+func Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := findSessionForRefresh(req.RefreshToken)
+	if err != nil {
+		if err == ErrInvalidRefreshToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	userID, err := sessionUserID(session)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	// only swap if nobody rotated it in the meantime
+	result, err := sessionsCollection.UpdateOne(context.Background(),
+		bson.M{"sessionId": session.SessionID, "tokenHash": session.TokenHash},
+		bson.M{"$set": bson.M{"tokenHash": hashToken(secret), "lastUsedAt": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+	if result.ModifiedCount == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	accessToken, err := GenerateJWT(userID, session.UserEmail, session.SessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":        accessToken,
+		"refreshToken": session.SessionID + "." + secret,
+		"expiresIn":    int(accessTokenTTL.Seconds()),
+	})
+}
+
+// revokes the session of a refresh token, or every session of its user with "all"
+func Logout(c *gin.Context) {
+	var req LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := findSessionForRefresh(req.RefreshToken)
+	if err != nil {
+		if err == ErrInvalidRefreshToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	filter := bson.M{"sessionId": session.SessionID}
+	if req.All {
+		userID, err := sessionUserID(session)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+		filter = bson.M{"userId": userID, "revoked": false}
+	}
+
+	if err := revokeSessions(filter); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Logged out successfully",
+	})
+}
+
+func randomToken(size int) (string, error) {
+	bytes := make([]byte, size)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
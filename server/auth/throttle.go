@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// failures older than this no longer count
+const failureWindow = time.Hour
+
+// a throttle counts failed logins for one account or one IP address
+type throttle struct {
+	prefix    string
+	threshold int           // failures allowed before the first lockout
+	baseDelay time.Duration // first lockout, doubled for every failure after that
+	maxDelay  time.Duration
+}
+
+var (
+	// an IP gets more room since offices and NATs share one
+	accountThrottle = throttle{prefix: "account:", threshold: 5, baseDelay: 30 * time.Second, maxDelay: time.Hour}
+	ipThrottle      = throttle{prefix: "ip:", threshold: 20, baseDelay: 30 * time.Second, maxDelay: time.Hour}
+	// share link passwords, per link and IP so one holder guessing can't lock out the others
+	linkThrottle = throttle{prefix: "link:", threshold: 10, baseDelay: 30 * time.Second, maxDelay: time.Hour}
+
+	loginAttemptsCollection *mongo.Collection
+	authAuditCollection     *mongo.Collection
+)
+
+// LoginAttempts is the shared failure counter for one throttle key
+type LoginAttempts struct {
+	Key           string    `bson:"key"`
+	Failures      int       `bson:"failures"`
+	LastFailureAt time.Time `bson:"lastFailureAt"`
+	LockedUntil   time.Time `bson:"lockedUntil"`
+	ExpiresAt     time.Time `bson:"expiresAt"` // when neither the window nor the lock counts any more
+}
+
+// AuditRecord is a security event kept for review
+type AuditRecord struct {
+	Event       string    `json:"event" bson:"event"`
+	Key         string    `json:"key" bson:"key"`
+	Email       string    `json:"email,omitempty" bson:"email,omitempty"`
+	IP          string    `json:"ip" bson:"ip"`
+	Failures    int       `json:"failures" bson:"failures"`
+	LockedUntil time.Time `json:"lockedUntil" bson:"lockedUntil"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+func (t throttle) key(value string) string {
+	return t.prefix + strings.ToLower(value)
+}
+
+// lockout length after a number of failures, zero below the threshold
+func (t throttle) delay(failures int) time.Duration {
+	if failures < t.threshold {
+		return 0
+	}
+	exponent := failures - t.threshold
+	if exponent > 30 {
+		return t.maxDelay
+	}
+	delay := t.baseDelay * time.Duration(math.Pow(2, float64(exponent)))
+	if delay > t.maxDelay || delay <= 0 {
+		return t.maxDelay
+	}
+	return delay
+}
+
+// how long until any of the keys may try again, zero when none are locked
+func lockedFor(keys ...string) (time.Duration, error) {
+	cursor, err := loginAttemptsCollection.Find(context.Background(), bson.M{
+		"key":         bson.M{"$in": keys},
+		"lockedUntil": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var attempts []LoginAttempts
+	if err := cursor.All(context.Background(), &attempts); err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, attempt := range attempts {
+		if remaining := time.Until(attempt.LockedUntil); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// counts a failure atomically, so every server instance sees the same number,
+// and locks the key once it passes the threshold
+func (t throttle) recordFailure(value, email, ip string) {
+	ctx := context.Background()
+	key := t.key(value)
+	now := time.Now()
+
+	// the counter restarts when the last failure fell out of the window
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"key": key,
+		"failures": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{"$lastFailureAt", now.Add(-failureWindow)}},
+			bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}},
+			1,
+		}},
+		"lastFailureAt": now,
+		"expiresAt":     bson.M{"$max": bson.A{"$lockedUntil", now.Add(failureWindow)}},
+	}}}}
+
+	var attempts LoginAttempts
+	err := loginAttemptsCollection.FindOneAndUpdate(ctx, bson.M{"key": key}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempts)
+	if err != nil {
+		log.Printf("record login failure for %s error: %v", key, err)
+		return
+	}
+
+	delay := t.delay(attempts.Failures)
+	if delay == 0 {
+		return
+	}
+
+	lockedUntil := now.Add(delay)
+	_, err = loginAttemptsCollection.UpdateOne(ctx, bson.M{"key": key}, bson.M{"$max": bson.M{"lockedUntil": lockedUntil, "expiresAt": lockedUntil}})
+	if err != nil {
+		log.Printf("lock %s error: %v", key, err)
+		return
+	}
+
+	audit(AuditRecord{
+		Event:       "lockout",
+		Key:         key,
+		Email:       email,
+		IP:          ip,
+		Failures:    attempts.Failures,
+		LockedUntil: lockedUntil,
+	})
+}
+
+// forgets the failures of a key after a successful login
+func (t throttle) reset(value string) {
+	_, err := loginAttemptsCollection.DeleteOne(context.Background(), bson.M{"key": t.key(value)})
+	if err != nil {
+		log.Printf("reset login failures error: %v", err)
+	}
+}
+
+func audit(record AuditRecord) {
+	record.CreatedAt = time.Now()
+	if _, err := authAuditCollection.InsertOne(context.Background(), record); err != nil {
+		log.Printf("write audit record error: %v", err)
+	}
+	log.Printf("auth audit: %s %s failures=%d until=%s", record.Event, record.Key, record.Failures, record.LockedUntil.Format(time.RFC3339))
+}
+
+// answers 429 with Retry-After and returns true when the account or IP is locked
+func rejectIfLocked(c *gin.Context, email string) bool {
+	wait, err := lockedFor(accountThrottle.key(email), ipThrottle.key(c.ClientIP()))
+	if err != nil {
+		// fail closed, guessing must not get easier when the counter store is down
+		log.Printf("check login lockout error: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Login is temporarily unavailable"})
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      "Too many failed attempts, try again later",
+		"retryAfter": seconds,
+	})
+	return true
+}
+
+// ShareLinkLockout is how long a share link's password can't be tried from ip, see access.SetShareLinkThrottle
+func ShareLinkLockout(linkID string, ip string) (time.Duration, error) {
+	return lockedFor(linkThrottle.key(linkID+"|"+ip), ipThrottle.key(ip))
+}
+
+// RecordShareLinkFailure counts a wrong share link password against the link from
+// that IP and against the IP. the link itself is never locked for everyone
+func RecordShareLinkFailure(linkID string, ip string) {
+	linkThrottle.recordFailure(linkID+"|"+ip, "", ip)
+	ipThrottle.recordFailure(ip, "", ip)
+}
+
+// counts a failed login against both the account and the IP
+func recordLoginFailure(c *gin.Context, email string) {
+	ip := c.ClientIP()
+	accountThrottle.recordFailure(email, email, ip)
+	ipThrottle.recordFailure(ip, email, ip)
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// the RFC 6238 SHA1 seed "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B vectors, cut to six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, test := range tests {
+		got, err := totpCode(rfcSecret, test.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("totpCode at %d = %s, want %s", test.unix, got, test.want)
+		}
+	}
+
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("an invalid secret should be an error")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	code := func(step int64) string {
+		value, err := totpCode(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	tests := []struct {
+		name         string
+		code         string
+		lastUsedStep int64
+		wantStep     int64
+		wantOK       bool
+	}{
+		{"current step", code(current), 0, current, true},
+		{"previous step for clock drift", code(current - 1), 0, current - 1, true},
+		{"next step for clock drift", code(current + 1), 0, current + 1, true},
+		{"too old", code(current - 2), 0, 0, false},
+		{"too new", code(current + 2), 0, 0, false},
+		{"spaces are ignored", code(current)[:3] + " " + code(current)[3:], 0, current, true},
+		{"wrong length", code(current)[:5], 0, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+		{"replay of the last used step", code(current), current, 0, false},
+		{"older step after a newer one was used", code(current - 1), current, 0, false},
+		{"newer step after an older one was used", code(current + 1), current, current + 1, true},
+		{"current step after the previous one was used", code(current), current - 1, current, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, ok := verifyTOTP(rfcSecret, test.code, test.lastUsedStep, now)
+			if ok != test.wantOK || step != test.wantStep {
+				t.Errorf("verifyTOTP(%q, last %d) = %d, %v, want %d, %v", test.code, test.lastUsedStep, step, ok, test.wantStep, test.wantOK)
+			}
+		})
+	}
+
+	if _, ok := verifyTOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code(current), 0, now); !ok {
+		t.Error("a lowercase secret should still verify")
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes", len(codes), len(hashes))
+	}
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' || hashes[i] != hashRecoveryCode(code) {
+			t.Errorf("code %q does not match its hash", code)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  bool
+	}{
+		{"as issued", "abcde-12345", true},
+		{"without the dash", "abcde12345", true},
+		{"uppercase with spaces", " ABCDE 12345 ", true},
+		{"different code", "abcde-12346", false},
+	}
+	for _, test := range tests {
+		if got := hashRecoveryCode(test.input) == hashRecoveryCode("abcde-12345"); got != test.want {
+			t.Errorf("%s: match = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
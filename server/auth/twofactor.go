@@ -0,0 +1,346 @@
+package auth
+
+import (
+	"collabify-backend/tokens"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// time to enter the code after the password was accepted
+const challengeLifetime = 5 * time.Minute
+
+// TOTPSettings is a user's authenticator setup, never sent to clients
+type TOTPSettings struct {
+	Secret        string    `bson:"secret,omitempty"`
+	PendingSecret string    `bson:"pendingSecret,omitempty"` // enrolled but not confirmed yet
+	RecoveryCodes []string  `bson:"recoveryCodes,omitempty"` // sha256 hashes, removed when used
+	LastUsedStep  int64     `bson:"lastUsedStep"`            // codes at or before this step are replays
+	EnabledAt     time.Time `bson:"enabledAt,omitempty"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code" binding:"required"` // authenticator or recovery code
+}
+
+// TwoFactorChallenge is the Login response when a code is still needed
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	ChallengeToken    string `json:"challengeToken"`
+	ExpiresIn         int    `json:"expiresIn"`
+}
+
+// short lived token proving the password step passed, it can't be used as an access token
+func issueChallenge(userEmail string) (string, error) {
+	return tokens.Sign(tokens.KindTwoFactor, jwt.MapClaims{
+		"email": userEmail,
+		"exp":   time.Now().Add(challengeLifetime).Unix(),
+	})
+}
+
+func parseChallenge(challengeToken string) (string, bool) {
+	claims, err := tokens.Parse(tokens.KindTwoFactor, challengeToken)
+	if err != nil {
+		return "", false
+	}
+	userEmail, ok := claims["email"].(string)
+	return userEmail, ok && userEmail != ""
+}
+
+// accepts a current authenticator code once, or burns one recovery code
+func checkSecondFactor(user *User, code string) (bool, error) {
+	if user.TOTP == nil || user.TOTP.Secret == "" {
+		return false, nil
+	}
+	ctx := context.Background()
+
+	if step, ok := verifyTOTP(user.TOTP.Secret, code, user.TOTP.LastUsedStep, time.Now()); ok {
+		// the step guard makes a code single use even across server instances
+		result, err := usersCollection.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "totp.lastUsedStep": bson.M{"$lt": step}},
+			bson.M{"$set": bson.M{"totp.lastUsedStep": step}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return result.ModifiedCount == 1, nil
+	}
+
+	hash := hashRecoveryCode(code)
+	result, err := usersCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "totp.recoveryCodes": hash},
+		bson.M{"$pull": bson.M{"totp.recoveryCodes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// loads the signed in user's account, writing the error response when it can't
+func currentUser(c *gin.Context) (*User, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return nil, false
+	}
+
+	var user User
+	id, err := bson.ObjectIDFromHex(userID.(string))
+	if err == nil {
+		err = usersCollection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&user)
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// starts 2FA setup, the secret only takes effect after EnableTwoFactor
+func EnrollTwoFactor(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	_, err = usersCollection.UpdateOne(context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"totp": TOTPSettings{PendingSecret: secret}}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":     secret,
+		"otpauthUri": totpURI(secret, user.Email),
+	})
+}
+
+// confirms enrollment with a first code and hands out recovery codes, shown only this once
+func EnableTwoFactor(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTP == nil || user.TOTP.PendingSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start enrollment first"})
+		return
+	}
+
+	step, valid := verifyTOTP(user.TOTP.PendingSecret, req.Code, 0, time.Now())
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	_, err = usersCollection.UpdateOne(context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{
+			"twoFactorEnabled": true,
+			"totp": TOTPSettings{
+				Secret:        user.TOTP.PendingSecret,
+				RecoveryCodes: hashes,
+				LastUsedStep:  step,
+				EnabledAt:     time.Now(),
+			},
+		}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+// turns 2FA off, needs the password (when the account has one) and a code
+func DisableTwoFactor(c *gin.Context) {
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.TwoFactorEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if user.Password != "" && !CheckPasswordHash(req.Password, user.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	valid, err := checkSecondFactor(user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check code"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	_, err = usersCollection.UpdateOne(context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"twoFactorEnabled": false}, "$unset": bson.M{"totp": ""}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// replaces all recovery codes, the old ones stop working
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.TwoFactorEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	valid, err := checkSecondFactor(user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check code"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	_, err = usersCollection.UpdateOne(context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"totp.recoveryCodes": hashes}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recoveryCodes": codes,
+	})
+}
+
+// second login step, trades the challenge token and a code for the real tokens
+func VerifyTwoFactorLogin(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userEmail, ok := parseChallenge(req.ChallengeToken)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign in expired, please try again"})
+		return
+	}
+
+	// wrong codes count like wrong passwords
+	if rejectIfLocked(c, userEmail) {
+		return
+	}
+
+	var user User
+	err := usersCollection.FindOne(context.Background(), bson.M{"email": userEmail}).Decode(&user)
+	if err != nil || !user.TwoFactorEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign in expired, please try again"})
+		return
+	}
+
+	valid, err := checkSecondFactor(&user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check code"})
+		return
+	}
+	if !valid {
+		recordLoginFailure(c, userEmail)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	accountThrottle.reset(userEmail)
+	user.Password = ""
+
+	token, refreshToken, err := createSession(&user, c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, AuthResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		User:         user,
+	})
+}
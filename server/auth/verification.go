@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"collabify-backend/mail"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// what a one-time token is for
+const (
+	PurposeVerifyEmail   = "verify-email"
+	PurposeResetPassword = "reset-password"
+	PurposeChangeEmail   = "change-email"
+
+	verifyEmailTokenTTL   = 48 * time.Hour
+	resetPasswordTokenTTL = time.Hour
+	changeEmailTokenTTL   = 24 * time.Hour
+)
+
+var (
+	oneTimeTokensCollection *mongo.Collection
+	mailer                  mail.Sender = mail.DisabledSender{}
+	// frontend base url used in emailed links
+	appURL = "http://localhost:3000"
+
+	ErrInvalidOneTimeToken = errors.New("invalid or expired token")
+)
+
+// OneTimeToken is an emailed token, only its hash is stored
+type OneTimeToken struct {
+	ID        interface{} `bson:"_id,omitempty"`
+	Purpose   string      `bson:"purpose"`
+	TokenHash string      `bson:"tokenHash"`
+	UserID    string      `bson:"userId,omitempty"` // set on change-email tokens
+	UserEmail string      `bson:"userEmail"`
+	NewEmail  string      `bson:"newEmail,omitempty"` // address being confirmed by a change-email token
+	ExpiresAt time.Time   `bson:"expiresAt"`
+	UsedAt    *time.Time  `bson:"usedAt"`
+	CreatedAt time.Time   `bson:"createdAt"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type EmailRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// reads MAIL_DRIVER and SMTP_* through mail.FromEnv and APP_URL, called from init
+func loadMailConfig() {
+	var err error
+	mailer, err = mail.FromEnv()
+	if err != nil {
+		log.Printf("WARNING: %v, verification, password reset and email change links can't be sent. set SMTP_HOST, or MAIL_DRIVER=log for local development", err)
+	}
+	if _, ok := mailer.(mail.LogSender); ok {
+		log.Printf("WARNING: MAIL_DRIVER=log writes sign-in links to the server log, never use it in production")
+	}
+	if value := os.Getenv("APP_URL"); value != "" {
+		appURL = value
+	}
+}
+
+// SetMailer replaces the mail sender, tests use a mail.MemorySender
+func SetMailer(sender mail.Sender) {
+	mailer = sender
+}
+
+// stores a new token for purpose and returns the raw value to email
+func issueOneTimeToken(purpose, userEmail string, ttl time.Duration) (string, error) {
+	return storeOneTimeToken(OneTimeToken{Purpose: purpose, UserEmail: userEmail}, ttl)
+}
+
+// fills in the hash and timestamps of token, stores it and returns the raw value
+func storeOneTimeToken(token OneTimeToken, ttl time.Duration) (string, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token.TokenHash = hashToken(raw)
+	token.ExpiresAt = now.Add(ttl)
+	token.CreatedAt = now
+	if _, err := oneTimeTokensCollection.InsertOne(context.Background(), token); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// marks a token used and returns it, a token works only once
+func consumeOneTimeToken(purpose, raw string) (*OneTimeToken, error) {
+	now := time.Now()
+	var token OneTimeToken
+
+	err := oneTimeTokensCollection.FindOneAndUpdate(context.Background(),
+		bson.M{
+			"purpose":   purpose,
+			"tokenHash": hashToken(raw),
+			"usedAt":    nil,
+			"expiresAt": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"usedAt": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidOneTimeToken
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// retires every unused token of a purpose for a user
+func invalidateOneTimeTokens(purpose, userEmail string) error {
+	_, err := oneTimeTokensCollection.UpdateMany(context.Background(),
+		bson.M{"purpose": purpose, "userEmail": userEmail, "usedAt": nil},
+		bson.M{"$set": bson.M{"usedAt": time.Now()}},
+	)
+	return err
+}
+
+func appLink(path, token string) string {
+	return appURL + path + "?" + url.Values{"token": {token}}.Encode()
+}
+
+// emails a verification link, errors are logged since the caller already succeeded
+func sendVerificationEmail(userEmail string) {
+	token, err := issueOneTimeToken(PurposeVerifyEmail, userEmail, verifyEmailTokenTTL)
+	if err != nil {
+		log.Printf("issue verification token for %s error: %v", userEmail, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = mailer.Send(ctx, mail.Message{
+		To:      userEmail,
+		Subject: "Verify your Collabify email",
+		Body: "Confirm this is your email address by opening the link below:\n\n" +
+			appLink("/verify-email", token) + "\n\nThe link expires in 48 hours.",
+	})
+	if err != nil {
+		log.Printf("send verification email to %s error: %v", userEmail, err)
+	}
+}
+
+// confirms the email address a verification token was sent to
+func VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := consumeOneTimeToken(PurposeVerifyEmail, req.Token)
+	if err != nil {
+		if err == ErrInvalidOneTimeToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	var user User
+	err = usersCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"email": token.UserEmail},
+		bson.M{"$set": bson.M{"emailVerified": true}},
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+	emailVerified(&user)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email verified successfully",
+	})
+}
+
+// sends a new verification link, the response never reveals whether the account exists
+func ResendVerification(c *gin.Context) {
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	err := usersCollection.FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&user)
+	if err == nil && !user.EmailVerified {
+		if err := invalidateOneTimeTokens(PurposeVerifyEmail, user.Email); err != nil {
+			log.Printf("invalidate verification tokens for %s error: %v", user.Email, err)
+		}
+		sendVerificationEmail(user.Email)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If the account exists and is unverified, a verification email has been sent",
+	})
+}
+
+// emails a password reset link, the response never reveals whether the account exists
+func RequestPasswordReset(c *gin.Context) {
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	err := usersCollection.FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&user)
+	if err == nil {
+		// only the newest link works
+		if err := invalidateOneTimeTokens(PurposeResetPassword, user.Email); err != nil {
+			log.Printf("invalidate reset tokens for %s error: %v", user.Email, err)
+		}
+
+		token, err := issueOneTimeToken(PurposeResetPassword, user.Email, resetPasswordTokenTTL)
+		if err != nil {
+			log.Printf("issue reset token for %s error: %v", user.Email, err)
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err = mailer.Send(ctx, mail.Message{
+				To:      user.Email,
+				Subject: "Reset your Collabify password",
+				Body: "Someone asked to reset the password of your Collabify account. " +
+					"If it was you, open the link below to choose a new one:\n\n" +
+					appLink("/reset-password", token) + "\n\nThe link expires in 1 hour. " +
+					"If you did not ask for this you can ignore this email.",
+			})
+			cancel()
+			if err != nil {
+				log.Printf("send reset email to %s error: %v", user.Email, err)
+			}
+		}
+	} else if err != mongo.ErrNoDocuments {
+		log.Printf("password reset lookup error: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If the account exists, a password reset email has been sent",
+	})
+}
+
+// sets a new password with a reset token and signs out every session
+func ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := consumeOneTimeToken(PurposeResetPassword, req.Token)
+	if err != nil {
+		if err == ErrInvalidOneTimeToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	hashedPassword, err := HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	// the reset link arrived by email, so the address is verified too
+	var user User
+	err = usersCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"email": token.UserEmail},
+		bson.M{"$set": bson.M{"password": hashedPassword, "emailVerified": true}},
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	// a reset usually follows a compromise, every session and personal access token goes
+	if err := revokeSessions(bson.M{"userId": user.ID.Hex(), "revoked": false}); err != nil {
+		log.Printf("revoke sessions after reset for %s error: %v", token.UserEmail, err)
+	}
+	if err := revokeAPITokens(user.ID.Hex()); err != nil {
+		log.Printf("revoke api tokens after reset for %s error: %v", token.UserEmail, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password reset successfully, please sign in again",
+	})
+}
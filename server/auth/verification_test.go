@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// points the auth collections at a throwaway database, the test is skipped
+// unless TEST_DATABASE_URL names a MongoDB server to use
+func testDatabase(t *testing.T) {
+	t.Helper()
+	uri := os.Getenv("TEST_DATABASE_URL")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := client.Database(fmt.Sprintf("collabify_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		database.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	usersCollection = database.Collection("users")
+	sessionsCollection = database.Collection("sessions")
+	apiTokensCollection = database.Collection("api_tokens")
+	oneTimeTokensCollection = database.Collection("one_time_tokens")
+}
+
+// creates a user with one login session and one personal access token
+func testAccount(t *testing.T, email string) *User {
+	t.Helper()
+	ctx := context.Background()
+
+	user := &User{Email: email, Name: "Test", EmailVerified: true}
+	result, err := usersCollection.InsertOne(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.ID = result.InsertedID.(bson.ObjectID)
+
+	if _, _, err := createSession(user, "test"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = apiTokensCollection.InsertOne(ctx, APIToken{
+		TokenID:   "token1",
+		UserID:    user.ID.Hex(),
+		UserEmail: email,
+		TokenHash: hashToken("raw"),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// counts the user's sessions and personal access tokens that still work
+func activeCredentials(t *testing.T, user *User) (int64, int64) {
+	t.Helper()
+	ctx := context.Background()
+	filter := bson.M{"userId": user.ID.Hex(), "revoked": false}
+
+	sessions, err := sessionsCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiTokens, err := apiTokensCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessions, apiTokens
+}
+
+func postJSON(t *testing.T, handler gin.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return recorder
+}
+
+func TestResetPasswordRevokesCredentials(t *testing.T) {
+	testDatabase(t)
+	user := testAccount(t, "reset@example.com")
+
+	token, err := issueOneTimeToken(PurposeResetPassword, user.Email, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := postJSON(t, ResetPassword, ResetPasswordRequest{Token: token, Password: "a new password"})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	if sessions, apiTokens := activeCredentials(t, user); sessions != 0 || apiTokens != 0 {
+		t.Errorf("after reset %d sessions and %d personal access tokens still work", sessions, apiTokens)
+	}
+}
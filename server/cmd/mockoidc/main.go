@@ -0,0 +1,192 @@
+// mockoidc is a tiny OpenID Connect provider for trying SSO locally.
+// it signs everyone in without asking, as -email or the login_hint param.
+//
+//	go run ./cmd/mockoidc -addr :9000
+//
+// then start the server with OIDC_ISSUER=http://localhost:9000, OIDC_CLIENT_ID=collabify
+// and OIDC_REDIRECT_URL=http://localhost:8080/api/auth/oidc/callback
+package main
+
+import (
+	"collabify-backend/tokens"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// one issued authorization code
+type grant struct {
+	ClientID      string
+	RedirectURI   string
+	Nonce         string
+	CodeChallenge string
+	Email         string
+	ExpiresAt     time.Time
+}
+
+var (
+	issuer  string
+	email   string
+	name    string
+	signKey tokens.Key
+
+	grantsMutex sync.Mutex
+	grants      = make(map[string]grant)
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	flag.StringVar(&issuer, "issuer", "http://localhost:9000", "issuer url, must match OIDC_ISSUER")
+	flag.StringVar(&email, "email", "dev@example.com", "email of the signed in user")
+	flag.StringVar(&name, "name", "Dev User", "name of the signed in user")
+	flag.Parse()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	signKey = tokens.RSAKey("mock-1", private)
+
+	http.HandleFunc("/.well-known/openid-configuration", discovery)
+	http.HandleFunc("/jwks", jwks)
+	http.HandleFunc("/authorize", authorize)
+	http.HandleFunc("/token", token)
+
+	log.Printf("mock OIDC provider for %s on %s", issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func jwks(w http.ResponseWriter, r *http.Request) {
+	jwk, _ := signKey.JWK()
+	writeJSON(w, http.StatusOK, tokens.JWKS{Keys: []tokens.JWK{jwk}})
+}
+
+func authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "expected response_type=code with an S256 code challenge", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURI.Host == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	user := email
+	if hint := query.Get("login_hint"); hint != "" {
+		user = hint
+	}
+
+	code := randomCode()
+	grantsMutex.Lock()
+	grants[code] = grant{
+		ClientID:      query.Get("client_id"),
+		RedirectURI:   redirectURI.String(),
+		Nonce:         query.Get("nonce"),
+		CodeChallenge: query.Get("code_challenge"),
+		Email:         user,
+		ExpiresAt:     time.Now().Add(time.Minute),
+	}
+	grantsMutex.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	grantsMutex.Lock()
+	issued, exists := grants[code]
+	delete(grants, code)
+	grantsMutex.Unlock()
+
+	clientID := r.PostForm.Get("client_id")
+	if basicID, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(basicID)
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !exists || time.Now().After(issued.ExpiresAt):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unknown or expired code"})
+		return
+	case clientID != issued.ClientID || r.PostForm.Get("redirect_uri") != issued.RedirectURI:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "client or redirect_uri mismatch"})
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != issued.CodeChallenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            issuer,
+		"sub":            "mock|" + strings.ToLower(issued.Email),
+		"aud":            issued.ClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          issued.Nonce,
+		"email":          issued.Email,
+		"email_verified": true,
+		"name":           name,
+	})
+	idToken.Header["kid"] = signKey.ID
+	signed, err := idToken.SignedString(signKey.Private)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomCode(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func randomCode() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
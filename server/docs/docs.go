@@ -1,28 +1,53 @@
 package docs
 
 import (
+	"collabify-backend/access"
+	"collabify-backend/history"
+	"collabify-backend/listing"
+	"collabify-backend/search"
+	"collabify-backend/socket"
 	"context"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type Document struct {
 	ID          interface{} `json:"id" bson:"_id,omitempty"`
 	DocID       string      `json:"docId" bson:"docId"`
 	Content     string      `json:"content" bson:"content"`
-	CreatedBy   string      `json:"createdBy" bson:"createdBy"`
+	OwnerID     string      `json:"ownerId" bson:"ownerId"`
+	WorkspaceID string      `json:"workspaceId,omitempty" bson:"workspaceId,omitempty"`
+	FolderID    string      `json:"folderId,omitempty" bson:"folderId,omitempty"` // set through the folders package
+	Title       string      `json:"title" bson:"title"`
+	Description string      `json:"description" bson:"description,omitempty"`
+	SearchText  string      `json:"-" bson:"searchText"` // plain text of Content, see search.Text
+	Tags        []string    `json:"tags" bson:"tags,omitempty"`
+	CreatedBy   string      `json:"createdBy" bson:"createdBy"` // owner's email, for display
 	CreatedAt   time.Time   `json:"createdAt" bson:"createdAt"`
 	UpdatedAt   time.Time   `json:"updatedAt" bson:"updatedAt"`
+	Revision    int         `json:"revision" bson:"revision"` // last live session revision written by autosave
+	DeletedAt   *time.Time  `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"` // set while in the trash
+	DeletedBy   string      `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"` // email of who moved it there
+	Collaborators []access.Collaborator `json:"collaborators" bson:"collaborators,omitempty"`
+	listing.Personal `bson:",inline"` // stars, pins and opens, the caller's filled per request
+	Role        access.Role `json:"role,omitempty" bson:"-"` // caller's role, filled per request
 }
 
 // SaveDocumentRequest represents the request body for saving a document
 type SaveDocumentRequest struct {
-	DocID   string `json:"docId" binding:"required"`
-	Content string `json:"content" binding:"required"`
+	DocID       string   `json:"docId" binding:"required"`
+	Content     string   `json:"content" binding:"required"`
+	WorkspaceID string   `json:"workspaceId"` // only used when creating
+	Title       *string  `json:"title"`       // left out keeps the current title
+	Description *string  `json:"description"` // left out keeps the current description
+	Tags        []string `json:"tags"`        // left out keeps the current tags
 }
 
 var docsCollection *mongo.Collection
@@ -32,9 +57,26 @@ func SetDocsCollection(collection *mongo.Collection) {
 	docsCollection = collection
 }
 
+// finds a document the user owns or has been shared, along with their role
+func findAccessibleDocument(docID string, user access.Principal) (Document, access.Role, error) {
+	var doc Document
+	filter := access.Filter(user)
+	filter["docId"] = docID
+
+	err := docsCollection.FindOne(context.Background(), filter).Decode(&doc)
+	if err != nil {
+		return doc, "", err
+	}
+
+	role := access.RoleFor(doc.OwnerID, doc.WorkspaceID, doc.Collaborators, user)
+	doc.Role = role
+	doc.Personal.For(user.ID)
+	return doc, role, nil
+}
+
 // saves a new document or updates existing one
 func SaveDocument(c *gin.Context) {
-	userEmail, exists := c.Get("user_email")
+	user, exists := access.CurrentUser(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
@@ -46,23 +88,53 @@ func SaveDocument(c *gin.Context) {
 		return
 	}
 
-	// check if document already exists for this user and docId
-	var existingDoc Document
-	filter := bson.M{
-		"docId":     req.DocID,
-		"createdBy": userEmail,
+	// metadata sent along with the content
+	metadata := bson.M{}
+	if req.Title != nil {
+		title, err := listing.NormalizeTitle(*req.Title)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		metadata["title"] = title
+	}
+	if req.Description != nil {
+		description, err := listing.NormalizeDescription(*req.Description)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		metadata["description"] = description
+	}
+	if req.Tags != nil {
+		tags, err := listing.NormalizeTags(req.Tags)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		metadata["tags"] = tags
 	}
 
-	err := docsCollection.FindOne(context.Background(), filter).Decode(&existingDoc)
+	// check if document already exists and is owned by or shared with this user
+	existingDoc, role, err := findAccessibleDocument(req.DocID, user)
 	
 	if err == nil {
+		if !role.CanWrite() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to edit this document"})
+			return
+		}
+
 		// doc exists, update it
-		update := bson.M{
-			"$set": bson.M{
-				"content":   req.Content,
-				"updatedAt": time.Now(),
-			},
+		filter := bson.M{"_id": existingDoc.ID}
+		set := bson.M{
+			"content":    req.Content,
+			"searchText": search.Text(access.ResourceDocument, req.Content),
+			"updatedAt":  time.Now(),
+		}
+		for field, value := range metadata {
+			set[field] = value
 		}
+		update := bson.M{"$set": set}
 
 		_, err := docsCollection.UpdateOne(context.Background(), filter, update)
 		if err != nil {
@@ -70,8 +142,20 @@ func SaveDocument(c *gin.Context) {
 			return
 		}
 
+		recordDocumentRevision(req.DocID, req.Content, user.ID, history.SourceSave)
+		search.Refresh(access.ResourceDocument, req.DocID)
+
 		// Return updated document
 		existingDoc.Content = req.Content
+		if title, ok := metadata["title"].(string); ok {
+			existingDoc.Title = title
+		}
+		if description, ok := metadata["description"].(string); ok {
+			existingDoc.Description = description
+		}
+		if tags, ok := metadata["tags"].([]string); ok {
+			existingDoc.Tags = tags
+		}
 		existingDoc.UpdatedAt = time.Now()
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Document updated successfully",
@@ -80,13 +164,53 @@ func SaveDocument(c *gin.Context) {
 		return
 	}
 
+	if err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	// someone else's document that was not shared with this user
+	count, err := docsCollection.CountDocuments(context.Background(), bson.M{"docId": req.DocID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+	if count > 0 {
+		trashed := access.TrashFilter(user)
+		trashed["docId"] = req.DocID
+		if count, err := docsCollection.CountDocuments(context.Background(), trashed); err == nil && count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "This document is in the trash, restore it first"})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have access to this document"})
+		return
+	}
+
+	if req.WorkspaceID != "" && !user.Workspaces[req.WorkspaceID].CanWrite() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't add documents to this workspace"})
+		return
+	}
+
 	// doc doesn't exist, create new one
 	doc := Document{
-		DocID:     req.DocID,
-		Content:   req.Content,
-		CreatedBy: userEmail.(string),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		DocID:       req.DocID,
+		Content:     req.Content,
+		OwnerID:     user.ID,
+		WorkspaceID: req.WorkspaceID,
+		CreatedBy:   user.Email,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		Role:        access.RoleOwner,
+	}
+	doc.SearchText = search.Text(access.ResourceDocument, doc.Content)
+	if title, ok := metadata["title"].(string); ok {
+		doc.Title = title
+	}
+	if description, ok := metadata["description"].(string); ok {
+		doc.Description = description
+	}
+	if tags, ok := metadata["tags"].([]string); ok {
+		doc.Tags = tags
 	}
 
 	result, err := docsCollection.InsertOne(context.Background(), doc)
@@ -96,6 +220,9 @@ func SaveDocument(c *gin.Context) {
 	}
 
 	doc.ID = result.InsertedID
+	recordDocumentRevision(doc.DocID, doc.Content, user.ID, history.SourceSave)
+	search.Refresh(access.ResourceDocument, doc.DocID)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Document saved successfully",
 		"document": doc,
@@ -104,7 +231,7 @@ func SaveDocument(c *gin.Context) {
 
 // retrieves a specific document
 func GetDocument(c *gin.Context) {
-	userEmail, exists := c.Get("user_email")
+	user, exists := access.CurrentUser(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
@@ -116,13 +243,59 @@ func GetDocument(c *gin.Context) {
 		return
 	}
 
-	var doc Document
-	filter := bson.M{
-		"docId":     docID,
-		"createdBy": userEmail,
+	doc, _, err := findAccessibleDocument(docID, user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
 	}
 
-	err := docsCollection.FindOne(context.Background(), filter).Decode(&doc)
+	// opening a document is what lists sorted by lastOpenedAt go by
+	now := time.Now()
+	if err := listing.MarkOpened(context.Background(), docsCollection, bson.M{"_id": doc.ID}, user.ID); err != nil {
+		log.Printf("mark document %s opened error: %v", docID, err)
+	} else {
+		doc.LastOpenedAt = &now
+	}
+
+	c.JSON(http.StatusOK, doc)
+}
+
+// updates a document's title, description and tags, or the current user's star and
+// pin, without touching its content. see listing.MetadataRequest
+func UpdateDocumentMetadata(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	docID := c.Param("docId")
+	if docID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Document ID is required"})
+		return
+	}
+
+	var req listing.MetadataRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	update, err := req.Update(user.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(update) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	doc, role, err := findAccessibleDocument(docID, user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
@@ -131,43 +304,116 @@ func GetDocument(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
 		return
 	}
+	if req.Shared() && !role.CanWrite() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to edit this document"})
+		return
+	}
 
-	c.JSON(http.StatusOK, doc)
+	var updated Document
+	err = docsCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": doc.ID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
+		return
+	}
+	if req.Shared() {
+		search.Refresh(access.ResourceDocument, docID)
+	}
+
+	updated.Role = role
+	updated.Personal.For(user.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Document updated successfully",
+		"document": updated,
+	})
 }
 
-// retrieves all documents for the current user
+// retrieves a page of the documents owned by or shared with the current user,
+// workspace documents are listed per workspace. see listing.ParseQuery for the options
 func GetUserDocuments(c *gin.Context) {
-	userEmail, exists := c.Get("user_email")
+	user, exists := access.CurrentUser(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
 	}
 
-	filter := bson.M{"createdBy": userEmail}
-	cursor, err := docsCollection.Find(context.Background(), filter)
+	query, err := listing.ParseQuery(c, user.ID)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := access.DirectFilter(user)
+	documents, next, total, err := listing.Find[Document](context.Background(), docsCollection, filter, query)
+	if err != nil {
+		if err == listing.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve documents"})
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	var documents []Document
-	if err = cursor.All(context.Background(), &documents); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode documents"})
+	for i := range documents {
+		documents[i].Role = access.RoleFor(documents[i].OwnerID, documents[i].WorkspaceID, documents[i].Collaborators, user)
+		documents[i].Personal.For(user.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"documents":  documents,
+		"nextCursor": next,
+		"total":      total,
+	})
+}
+
+// retrieves a page of the documents in a workspace the current user is a member of
+func GetWorkspaceDocuments(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
 	}
 
-	if documents == nil {
-		documents = []Document{}
+	workspaceID := c.Param("workspaceId")
+	if user.Workspaces[workspaceID] == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return
+	}
+
+	query, err := listing.ParseQuery(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := bson.M{"workspaceId": workspaceID, "deletedAt": bson.M{"$exists": false}}
+	documents, next, total, err := listing.Find[Document](context.Background(), docsCollection, filter, query)
+	if err != nil {
+		if err == listing.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve documents"})
+		return
+	}
+
+	for i := range documents {
+		documents[i].Role = access.RoleFor(documents[i].OwnerID, documents[i].WorkspaceID, documents[i].Collaborators, user)
+		documents[i].Personal.For(user.ID)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"documents": documents,
+		"documents":  documents,
+		"nextCursor": next,
+		"total":      total,
 	})
 }
 
 func DeleteDocument(c *gin.Context) {
-	userEmail, exists := c.Get("user_email")
+	user, exists := access.CurrentUser(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
@@ -179,23 +425,989 @@ func DeleteDocument(c *gin.Context) {
 		return
 	}
 
-	filter := bson.M{
-		"docId":     docID,
-		"createdBy": userEmail,
+	doc, role, err := findAccessibleDocument(docID, user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can delete this document"})
+		return
 	}
 
-	result, err := docsCollection.DeleteOne(context.Background(), filter)
+	result, err := docsCollection.UpdateOne(context.Background(),
+		bson.M{"_id": doc.ID, "deletedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deletedAt": time.Now(), "deletedBy": user.Email}},
+	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
 		return
 	}
 
-	if result.DeletedCount == 0 {
+	if result.ModifiedCount == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
 		return
 	}
+	search.Forget(access.ResourceDocument, []string{doc.DocID})
+	socket.CloseSession(doc.DocID, "This document was moved to the trash")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Document moved to the trash",
+	})
+}
+
+// finds a document in the trash the user can restore or delete for good
+func findTrashedDocument(docID string, user access.Principal) (Document, error) {
+	var doc Document
+	filter := access.TrashFilter(user)
+	filter["docId"] = docID
+
+	err := docsCollection.FindOne(context.Background(), filter).Decode(&doc)
+	if err != nil {
+		return doc, err
+	}
+	doc.Role = access.RoleOwner
+	return doc, nil
+}
+
+// retrieves a page of the documents in the trash the current user can restore, see
+// listing.ParseQuery for the options. they are deleted for good after the retention period
+func GetTrashedDocuments(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	query, err := listing.ParseQuery(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	documents, next, total, err := listing.Find[Document](context.Background(), docsCollection, access.TrashFilter(user), query)
+	if err != nil {
+		if err == listing.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve documents"})
+		return
+	}
+
+	for i := range documents {
+		documents[i].Role = access.RoleOwner
+		documents[i].Personal.For(user.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"documents":  documents,
+		"nextCursor": next,
+		"total":      total,
+	})
+}
+
+// takes a document out of the trash
+func RestoreDocument(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	doc, err := findTrashedDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found in the trash"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	_, err = docsCollection.UpdateOne(context.Background(),
+		bson.M{"_id": doc.ID},
+		bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore document"})
+		return
+	}
+	search.Refresh(access.ResourceDocument, doc.DocID)
+
+	doc.DeletedAt = nil
+	doc.DeletedBy = ""
+	doc.Personal.For(user.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Document restored successfully",
+		"document": doc,
+	})
+}
+
+// deletes a document in the trash for good, along with its history
+func DeleteTrashedDocument(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	doc, err := findTrashedDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found in the trash"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	if _, err := purgeDocuments(context.Background(), bson.M{"_id": doc.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Document deleted permanently",
+	})
+}
+
+// deletes every document in the trash the current user can restore for good
+func EmptyTrashedDocuments(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	deleted, err := purgeDocuments(context.Background(), access.TrashFilter(user))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to empty the trash"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Document deleted successfully",
+		"message": "Trash emptied successfully",
+		"deleted": deleted,
+	})
+}
+
+// deletes the documents matching filter for good, with their history, and returns how many
+func purgeDocuments(ctx context.Context, filter bson.M) (int, error) {
+	cursor, err := docsCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"docId": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var purged []Document
+	if err := cursor.All(ctx, &purged); err != nil {
+		return 0, err
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	ids := make(bson.A, len(purged))
+	docIDs := make([]string, len(purged))
+	for i, doc := range purged {
+		ids[i] = doc.ID
+		docIDs[i] = doc.DocID
+	}
+
+	if _, err := docsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return 0, err
+	}
+	search.Forget(access.ResourceDocument, docIDs)
+	if err := history.Delete(ctx, access.ResourceDocument, docIDs); err != nil {
+		log.Printf("delete history of %d documents error: %v", len(docIDs), err)
+	}
+	return len(purged), nil
+}
+
+// lists the owner and collaborators of a document
+func GetCollaborators(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	doc, _, err := findAccessibleDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	collaborators := doc.Collaborators
+	if collaborators == nil {
+		collaborators = []access.Collaborator{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"owner":         doc.CreatedBy,
+		"ownerId":       doc.OwnerID,
+		"collaborators": collaborators,
+	})
+}
+
+// grants a user a role on a document, owner only
+func ShareDocument(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req access.ShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !req.Role.Grantable() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be editor, commenter or viewer"})
+		return
+	}
+
+	doc, role, err := findAccessibleDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can share this document"})
+		return
+	}
+
+	collaborator := access.Collaborator{
+		Email:   req.Email,
+		Role:    req.Role,
+		AddedBy: user.Email,
+		AddedAt: time.Now(),
+	}
+
+	// people with a verified account are granted by id, others get an invite
+	// matched by email once they verify it
+	invitee, err := access.LookupUser(context.Background(), req.Email)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+	if err == nil && invitee.EmailVerified {
+		collaborator.UserID = invitee.ID
+		collaborator.Email = invitee.Email
+	}
+
+	if collaborator.UserID == doc.OwnerID || req.Email == doc.CreatedBy {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Owner already has full access"})
+		return
+	}
+
+	if err := access.Grant(context.Background(), docsCollection, bson.M{"_id": doc.ID}, collaborator); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share document"})
+		return
+	}
+
+	// apply the new role to anyone already in the live session, a workspace may give them more
+	if collaborator.UserID != "" {
+		if doc.WorkspaceID == "" {
+			socket.UpdateUserRole(doc.DocID, collaborator.UserID, collaborator.Role)
+		} else if err := WorkspaceMemberChanged(doc.WorkspaceID, collaborator.UserID); err != nil {
+			log.Printf("refresh live role of %s error: %v", collaborator.UserID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Document shared successfully",
+		"collaborator": collaborator,
+	})
+}
+
+// removes a collaborator, the owner can remove anyone and collaborators can remove themselves
+func RevokeDocumentAccess(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	email := c.Param("email")
+	doc, role, err := findAccessibleDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	if !role.CanManage() && email != user.Email {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can remove collaborators"})
+		return
+	}
+
+	removed, err := access.Revoke(context.Background(), docsCollection, bson.M{"_id": doc.ID}, email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access"})
+		return
+	}
+
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collaborator not found"})
+		return
+	}
+
+	// kick them out of the live session too, workspace members keep their workspace role
+	if collaborator, found := access.FindCollaborator(doc.Collaborators, email); found && collaborator.UserID != "" {
+		if doc.WorkspaceID == "" {
+			socket.RevokeUserAccess(doc.DocID, collaborator.UserID)
+		} else if err := WorkspaceMemberChanged(doc.WorkspaceID, collaborator.UserID); err != nil {
+			log.Printf("refresh live role of %s error: %v", collaborator.UserID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Access revoked successfully",
+	})
+}
+
+// mints a share link for a document, owner only
+func CreateDocumentLink(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req access.CreateShareLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	doc, role, err := findAccessibleDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can create share links"})
+		return
+	}
+
+	link, token, err := access.CreateShareLink(context.Background(), access.ResourceDocument, doc.DocID, access.Principal{ID: doc.OwnerID, Email: doc.CreatedBy}, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Share link created successfully",
+		"link":    link,
+		"token":   token,
+	})
+}
+
+// lists share links of a document, owner only
+func GetDocumentLinks(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	doc, role, err := findAccessibleDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can view share links"})
+		return
+	}
+
+	links, err := access.ListShareLinks(context.Background(), access.ResourceDocument, doc.DocID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve share links"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"links": links,
+	})
+}
+
+// revokes a share link and drops anyone connected through it, owner only
+func RevokeDocumentLink(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	doc, role, err := findAccessibleDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can revoke share links"})
+		return
+	}
+
+	linkID := c.Param("linkId")
+	revoked, err := access.RevokeShareLink(context.Background(), access.ResourceDocument, doc.DocID, linkID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+		return
+	}
+
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+
+	socket.RevokeShareLinkAccess(doc.DocID, linkID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Share link revoked successfully",
+	})
+}
+
+// finds the document a share link points at
+func findSharedDocument(c *gin.Context) (Document, *access.ShareLink, bool) {
+	var doc Document
+
+	value, exists := c.Get("share_link")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Share token required"})
+		return doc, nil, false
+	}
+	link := value.(*access.ShareLink)
+
+	if link.ResourceType != access.ResourceDocument || link.ResourceID != c.Param("docId") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Share link is not valid for this document"})
+		return doc, nil, false
+	}
+
+	filter := bson.M{
+		"docId":     link.ResourceID,
+		"deletedAt": bson.M{"$exists": false},
+	}
+	for key, value := range link.OwnerFilter() {
+		filter[key] = value
+	}
+
+	err := docsCollection.FindOne(context.Background(), filter).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return doc, nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return doc, nil, false
+	}
+
+	// link holders see the content, not who else it is shared with
+	doc.Role = link.Role
+	doc.Collaborators = nil
+	return doc, link, true
+}
+
+// retrieves a document through a share link
+func GetSharedDocument(c *gin.Context) {
+	doc, _, ok := findSharedDocument(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, doc)
+}
+
+// updates a document through a share link that grants editing
+func SaveSharedDocument(c *gin.Context) {
+	var req struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	doc, link, ok := findSharedDocument(c)
+	if !ok {
+		return
+	}
+
+	if !link.Role.CanWrite() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This link does not allow editing"})
+		return
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"content":    req.Content,
+			"searchText": search.Text(access.ResourceDocument, req.Content),
+			"updatedAt":  time.Now(),
+		},
+	}
+
+	_, err := docsCollection.UpdateOne(context.Background(), bson.M{"_id": doc.ID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
+		return
+	}
+
+	recordDocumentRevision(doc.DocID, req.Content, history.ShareLinkAuthor(link.LinkID), history.SourceShareLink)
+	search.Refresh(access.ResourceDocument, doc.DocID)
+
+	doc.Content = req.Content
+	doc.UpdatedAt = time.Now()
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Document updated successfully",
+		"document": doc,
+	})
+}
+
+// stores a history revision, failures are logged so the save itself still succeeds
+func recordDocumentRevision(docID string, content string, author string, source string) {
+	if _, err := history.Record(context.Background(), access.ResourceDocument, docID, content, author, source); err != nil {
+		log.Printf("record revision for document %s error: %v", docID, err)
+	}
+}
+
+// parses a revision number from a path or query value
+func parseRevisionNumber(value string) (int, bool) {
+	number, err := strconv.Atoi(value)
+	return number, err == nil && number > 0
+}
+
+// lists the saved revisions of a document, newest first
+func ListDocumentRevisions(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	doc, _, err := findAccessibleDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	revisions, err := history.List(context.Background(), access.ResourceDocument, doc.DocID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revisions"})
+		return
+	}
+	if err := history.NameAuthors(context.Background(), revisions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revisions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revisions": revisions,
+	})
+}
+
+// retrieves one revision of a document with its content
+func GetDocumentRevision(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	number, ok := parseRevisionNumber(c.Param("revision"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision number"})
+		return
+	}
+
+	doc, _, err := findAccessibleDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	revision, err := history.Get(context.Background(), access.ResourceDocument, doc.DocID, number)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+		return
+	}
+	revisions := []history.Revision{*revision}
+	if err := history.NameAuthors(context.Background(), revisions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+		return
+	}
+
+	c.JSON(http.StatusOK, revisions[0])
+}
+
+// revisions walked for author attribution before falling back to crediting the target
+const maxAttributionSteps = 200
+
+// compares two revisions of a document, ?from=1&to=2 or to=current for the live
+// content, granularity=word (default) or line. returns attributed spans, unified
+// hunks and the unified diff text
+func DiffDocumentRevisions(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	granularity := c.DefaultQuery("granularity", history.GranularityWord)
+	if granularity != history.GranularityWord && granularity != history.GranularityLine {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "granularity must be word or line"})
+		return
+	}
+
+	fromNumber, fromOk := parseRevisionNumber(c.Query("from"))
+	toCurrent := c.Query("to") == "current"
+	toNumber, toOk := parseRevisionNumber(c.Query("to"))
+	if !fromOk || (!toOk && !toCurrent) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to revision numbers are required"})
+		return
+	}
+
+	doc, _, err := findAccessibleDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	ctx := context.Background()
+
+	if toCurrent {
+		latest, err := history.Latest(ctx, access.ResourceDocument, doc.DocID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+			return
+		}
+		toNumber = fromNumber
+		if latest != nil {
+			toNumber = max(latest.Number, fromNumber)
+		}
+	}
+
+	if toNumber < fromNumber {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return
+	}
+
+	from, err := history.Get(ctx, access.ResourceDocument, doc.DocID, fromNumber)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+		return
+	}
+
+	between, truncated, err := history.Between(ctx, access.ResourceDocument, doc.DocID, fromNumber, toNumber, maxAttributionSteps)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revisions"})
+		return
+	}
+
+	steps := make([]history.Step, 0, len(between)+1)
+	authors := []string{from.Author}
+	for _, revision := range between {
+		steps = append(steps, history.Step{Content: revision.Content, Author: revision.Author, Revision: revision.Number})
+		authors = append(authors, revision.Author)
+	}
+
+	// spans carry author ids, this maps them to names
+	names, err := history.AuthorNames(ctx, authors)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revisions"})
+		return
+	}
+
+	toLabel := "revision " + strconv.Itoa(toNumber)
+	target := from.Content
+	if len(between) > 0 {
+		target = between[len(between)-1].Content
+	}
+	if toCurrent {
+		toLabel = "current"
+		// content saved outside the history (none yet, or edited since) counts as unsaved
+		if doc.Content != target {
+			steps = append(steps, history.Step{Content: doc.Content})
+			target = doc.Content
+		}
+	}
+
+	hunks := history.UnifiedHunks(from.Content, target, history.DefaultContextLines)
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":        from.Number,
+		"to":          toLabel,
+		"granularity": granularity,
+		"truncated":   truncated, // too many revisions in between, changes credited to the target
+		"spans":       history.Attribute(history.Step{Content: from.Content, Author: from.Author, Revision: from.Number}, steps, granularity),
+		"hunks":       hunks,
+		"authors":     names,
+		"unified":     history.FormatUnified("revision "+strconv.Itoa(from.Number), toLabel, hunks),
+	})
+}
+
+// makes an old revision the current content, recorded as a new revision
+func RestoreDocumentRevision(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	number, ok := parseRevisionNumber(c.Param("revision"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision number"})
+		return
+	}
+
+	doc, role, err := findAccessibleDocument(c.Param("docId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document"})
+		return
+	}
+
+	if !role.CanWrite() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to edit this document"})
+		return
+	}
+
+	revision, err := history.Get(context.Background(), access.ResourceDocument, doc.DocID, number)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+		return
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"content":    revision.Content,
+			"searchText": search.Text(access.ResourceDocument, revision.Content),
+			"updatedAt":  time.Now(),
+		},
+	}
+
+	_, err = docsCollection.UpdateOne(context.Background(), bson.M{"_id": doc.ID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore document"})
+		return
+	}
+
+	recordDocumentRevision(doc.DocID, revision.Content, user.ID, history.SourceRestore)
+	search.Refresh(access.ResourceDocument, doc.DocID)
+
+	// everyone in the live session switches to the restored content
+	socket.ResetSessionContent(doc.DocID, socket.SessionDocument, revision.Content)
+
+	doc.Content = revision.Content
+	doc.UpdatedAt = time.Now()
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Document restored to revision " + strconv.Itoa(number),
+		"document": doc,
+	})
+}
+
+// ClaimInvites binds the email invites to documents for a newly verified address to the user
+func ClaimInvites(user access.Principal) error {
+	return access.ClaimInvites(context.Background(), docsCollection, user)
+}
+
+// RenameUser refreshes the owner and collaborator emails shown on documents after an email change
+func RenameUser(user access.Principal, oldEmail string) error {
+	return access.ReplaceUser(context.Background(), docsCollection, user, oldEmail)
+}
+
+// RemoveUser cleans up after a deleted account. owned documents go to transferTo,
+// or are deleted with their history when it is empty, and the user leaves every ACL
+func RemoveUser(user access.Principal, transferTo access.Principal) error {
+	ctx := context.Background()
+
+	cursor, err := docsCollection.Find(ctx, bson.M{"ownerId": user.ID})
+	if err != nil {
+		return err
+	}
+	var owned []Document
+	if err := cursor.All(ctx, &owned); err != nil {
+		return err
+	}
+	docIDs := make([]string, len(owned))
+	for i, doc := range owned {
+		docIDs[i] = doc.DocID
+	}
+
+	if transferTo.ID != "" {
+		if err := access.TransferOwnership(ctx, docsCollection, user, transferTo); err != nil {
+			return err
+		}
+		if err := access.TransferShareLinks(ctx, access.ResourceDocument, docIDs, transferTo); err != nil {
+			return err
+		}
+		for _, docID := range docIDs {
+			socket.UpdateUserRole(docID, transferTo.ID, access.RoleOwner)
+		}
+	} else {
+		if _, err := docsCollection.DeleteMany(ctx, bson.M{"ownerId": user.ID}); err != nil {
+			return err
+		}
+		search.Forget(access.ResourceDocument, docIDs)
+		for _, docID := range docIDs {
+			socket.CloseSession(docID, "This document was deleted")
+		}
+		if err := history.Delete(ctx, access.ResourceDocument, docIDs); err != nil {
+			log.Printf("delete history of %d documents error: %v", len(docIDs), err)
+		}
+	}
+
+	if err := listing.ForgetUser(ctx, docsCollection, user.ID); err != nil {
+		return err
+	}
+	return access.RemoveCollaborator(ctx, docsCollection, user)
+}
+
+// documents in a workspace outside the trash, without their content
+func findWorkspaceDocuments(ctx context.Context, workspaceID string) ([]Document, error) {
+	cursor, err := docsCollection.Find(ctx,
+		bson.M{"workspaceId": workspaceID, "deletedAt": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"content": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var documents []Document
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
+// gives the live sessions of userIDs the role they now have on each document
+func refreshLiveRoles(ctx context.Context, documents []Document, userIDs []string) error {
+	for _, userID := range userIDs {
+		user, err := access.LoadPrincipal(ctx, userID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		for _, doc := range documents {
+			role := access.RoleFor(doc.OwnerID, doc.WorkspaceID, doc.Collaborators, user)
+			if role == "" {
+				socket.RevokeUserAccess(doc.DocID, userID)
+			} else {
+				socket.UpdateUserRole(doc.DocID, userID, role)
+			}
+		}
+	}
+	return nil
+}
+
+// WorkspaceMemberChanged updates the live sessions of a user whose workspace role changed or who left it
+func WorkspaceMemberChanged(workspaceID string, userID string) error {
+	ctx := context.Background()
+	documents, err := findWorkspaceDocuments(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	return refreshLiveRoles(ctx, documents, []string{userID})
+}
+
+// WorkspaceDeleted moves the documents of a deleted workspace out of it, they stay with
+// their owners and former members keep only what was shared with them directly
+func WorkspaceDeleted(workspaceID string, memberIDs []string) error {
+	ctx := context.Background()
+	documents, err := findWorkspaceDocuments(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+
+	_, err = docsCollection.UpdateMany(ctx,
+		bson.M{"workspaceId": workspaceID},
+		bson.M{"$unset": bson.M{"workspaceId": ""}},
+	)
+	if err != nil {
+		return err
+	}
+
+	for i := range documents {
+		documents[i].WorkspaceID = ""
+	}
+	return refreshLiveRoles(ctx, documents, memberIDs)
+}
+
+// PurgeTrash deletes the documents that were moved to the trash before cutoff for good
+func PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	return purgeDocuments(ctx, bson.M{"deletedAt": bson.M{"$lt": cutoff}})
+}
+
+// EnsureIndexes creates the indexes documents lookups and lists rely on
+func EnsureIndexes(ctx context.Context) error {
+	indexes := append(listing.Indexes("docId"), mongo.IndexModel{
+		Keys: bson.D{{Key: "deletedAt", Value: 1}},
+		// only trashed records, for the purge
+		Options: options.Index().SetPartialFilterExpression(bson.M{"deletedAt": bson.M{"$exists": true}}),
 	})
+	_, err := docsCollection.Indexes().CreateMany(ctx, indexes)
+	return err
 }
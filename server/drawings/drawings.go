@@ -1,28 +1,53 @@
 package drawings
 
 import (
+	"collabify-backend/access"
+	"collabify-backend/history"
+	"collabify-backend/listing"
+	"collabify-backend/search"
+	"collabify-backend/socket"
 	"context"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type Drawing struct {
 	ID          interface{} `json:"id" bson:"_id,omitempty"`
 	DrawingID   string      `json:"drawingId" bson:"drawingId"`
 	Content     string      `json:"content" bson:"content"`
-	CreatedBy   string      `json:"createdBy" bson:"createdBy"`
+	OwnerID     string      `json:"ownerId" bson:"ownerId"`
+	WorkspaceID string      `json:"workspaceId,omitempty" bson:"workspaceId,omitempty"`
+	FolderID    string      `json:"folderId,omitempty" bson:"folderId,omitempty"` // set through the folders package
+	Title       string      `json:"title" bson:"title"`
+	Description string      `json:"description" bson:"description,omitempty"`
+	SearchText  string      `json:"-" bson:"searchText"` // plain text of Content, see search.Text
+	Tags        []string    `json:"tags" bson:"tags,omitempty"`
+	CreatedBy   string      `json:"createdBy" bson:"createdBy"` // owner's email, for display
 	CreatedAt   time.Time   `json:"createdAt" bson:"createdAt"`
 	UpdatedAt   time.Time   `json:"updatedAt" bson:"updatedAt"`
+	Revision    int         `json:"revision" bson:"revision"` // last live session revision written by autosave
+	DeletedAt   *time.Time  `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"` // set while in the trash
+	DeletedBy   string      `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"` // email of who moved it there
+	Collaborators []access.Collaborator `json:"collaborators" bson:"collaborators,omitempty"`
+	listing.Personal `bson:",inline"` // stars, pins and opens, the caller's filled per request
+	Role        access.Role `json:"role,omitempty" bson:"-"` // caller's role, filled per request
 }
 
 // represents the request body for saving a drawing
 type SaveDrawingRequest struct {
-	DrawingID string `json:"drawingId" binding:"required"`
-	Content   string `json:"content" binding:"required"`
+	DrawingID   string   `json:"drawingId" binding:"required"`
+	Content     string   `json:"content" binding:"required"`
+	WorkspaceID string   `json:"workspaceId"` // only used when creating
+	Title       *string  `json:"title"`       // left out keeps the current title
+	Description *string  `json:"description"` // left out keeps the current description
+	Tags        []string `json:"tags"`        // left out keeps the current tags
 }
 
 var drawingsCollection *mongo.Collection
@@ -32,9 +57,26 @@ func SetDrawingsCollection(collection *mongo.Collection) {
 	drawingsCollection = collection
 }
 
+// finds a drawing the user owns or has been shared, along with their role
+func findAccessibleDrawing(drawingID string, user access.Principal) (Drawing, access.Role, error) {
+	var drawing Drawing
+	filter := access.Filter(user)
+	filter["drawingId"] = drawingID
+
+	err := drawingsCollection.FindOne(context.Background(), filter).Decode(&drawing)
+	if err != nil {
+		return drawing, "", err
+	}
+
+	role := access.RoleFor(drawing.OwnerID, drawing.WorkspaceID, drawing.Collaborators, user)
+	drawing.Role = role
+	drawing.Personal.For(user.ID)
+	return drawing, role, nil
+}
+
 // saves a new drawing or updates existing one
 func SaveDrawing(c *gin.Context) {
-	userEmail, exists := c.Get("user_email")
+	user, exists := access.CurrentUser(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
@@ -46,23 +88,84 @@ func SaveDrawing(c *gin.Context) {
 		return
 	}
 
-	// check if drawing already exists for this user and drawingId
-	var existingDrawing Drawing
-	filter := bson.M{
-		"drawingId": req.DrawingID,
-		"createdBy": userEmail,
+	// metadata sent along with the content
+	metadata := bson.M{}
+	if req.Title != nil {
+		title, err := listing.NormalizeTitle(*req.Title)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		metadata["title"] = title
+	}
+	if req.Description != nil {
+		description, err := listing.NormalizeDescription(*req.Description)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		metadata["description"] = description
+	}
+	if req.Tags != nil {
+		tags, err := listing.NormalizeTags(req.Tags)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		metadata["tags"] = tags
+	}
+
+	// check if drawing already exists and is owned by or shared with this user
+	existingDrawing, role, err := findAccessibleDrawing(req.DrawingID, user)
+	if err == nil && !role.CanWrite() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to edit this drawing"})
+		return
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	if err == mongo.ErrNoDocuments {
+		// someone else's drawing that was not shared with this user
+		count, err := drawingsCollection.CountDocuments(context.Background(), bson.M{"drawingId": req.DrawingID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+			return
+		}
+		if count > 0 {
+			trashed := access.TrashFilter(user)
+			trashed["drawingId"] = req.DrawingID
+			if count, err := drawingsCollection.CountDocuments(context.Background(), trashed); err == nil && count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "This drawing is in the trash, restore it first"})
+				return
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have access to this drawing"})
+			return
+		}
+		if req.WorkspaceID != "" && !user.Workspaces[req.WorkspaceID].CanWrite() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can't add drawings to this workspace"})
+			return
+		}
+	}
+
+	// persist what collaborators are seeing, not just this client's copy
+	if merged, ok := socket.MergeSessionScene(req.DrawingID, req.Content); ok {
+		req.Content = merged
 	}
 
-	err := drawingsCollection.FindOne(context.Background(), filter).Decode(&existingDrawing)
-	
 	if err == nil {
 		// exists, update it
-		update := bson.M{
-			"$set": bson.M{
-				"content":   req.Content,
-				"updatedAt": time.Now(),
-			},
+		filter := bson.M{"_id": existingDrawing.ID}
+		set := bson.M{
+			"content":    req.Content,
+			"searchText": search.Text(access.ResourceDrawing, req.Content),
+			"updatedAt":  time.Now(),
+		}
+		for field, value := range metadata {
+			set[field] = value
 		}
+		update := bson.M{"$set": set}
 
 		_, err := drawingsCollection.UpdateOne(context.Background(), filter, update)
 		if err != nil {
@@ -70,8 +173,20 @@ func SaveDrawing(c *gin.Context) {
 			return
 		}
 
+		recordDrawingRevision(req.DrawingID, req.Content, user.ID, history.SourceSave)
+		search.Refresh(access.ResourceDrawing, req.DrawingID)
+
 		// updated drawing
 		existingDrawing.Content = req.Content
+		if title, ok := metadata["title"].(string); ok {
+			existingDrawing.Title = title
+		}
+		if description, ok := metadata["description"].(string); ok {
+			existingDrawing.Description = description
+		}
+		if tags, ok := metadata["tags"].([]string); ok {
+			existingDrawing.Tags = tags
+		}
 		existingDrawing.UpdatedAt = time.Now()
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Drawing updated successfully",
@@ -82,11 +197,24 @@ func SaveDrawing(c *gin.Context) {
 
 	// doesn't exist, create new one
 	drawing := Drawing{
-		DrawingID: req.DrawingID,
-		Content:   req.Content,
-		CreatedBy: userEmail.(string),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		DrawingID:   req.DrawingID,
+		Content:     req.Content,
+		OwnerID:     user.ID,
+		WorkspaceID: req.WorkspaceID,
+		CreatedBy:   user.Email,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		Role:        access.RoleOwner,
+	}
+	drawing.SearchText = search.Text(access.ResourceDrawing, drawing.Content)
+	if title, ok := metadata["title"].(string); ok {
+		drawing.Title = title
+	}
+	if description, ok := metadata["description"].(string); ok {
+		drawing.Description = description
+	}
+	if tags, ok := metadata["tags"].([]string); ok {
+		drawing.Tags = tags
 	}
 
 	result, err := drawingsCollection.InsertOne(context.Background(), drawing)
@@ -96,6 +224,9 @@ func SaveDrawing(c *gin.Context) {
 	}
 
 	drawing.ID = result.InsertedID
+	recordDrawingRevision(drawing.DrawingID, drawing.Content, user.ID, history.SourceSave)
+	search.Refresh(access.ResourceDrawing, drawing.DrawingID)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Drawing saved successfully",
 		"drawing": drawing,
@@ -104,7 +235,7 @@ func SaveDrawing(c *gin.Context) {
 
 // retrieves a specific drawing
 func GetDrawing(c *gin.Context) {
-	userEmail, exists := c.Get("user_email")
+	user, exists := access.CurrentUser(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
@@ -116,13 +247,59 @@ func GetDrawing(c *gin.Context) {
 		return
 	}
 
-	var drawing Drawing
-	filter := bson.M{
-		"drawingId": drawingID,
-		"createdBy": userEmail,
+	drawing, _, err := findAccessibleDrawing(drawingID, user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
 	}
 
-	err := drawingsCollection.FindOne(context.Background(), filter).Decode(&drawing)
+	// opening a drawing is what lists sorted by lastOpenedAt go by
+	now := time.Now()
+	if err := listing.MarkOpened(context.Background(), drawingsCollection, bson.M{"_id": drawing.ID}, user.ID); err != nil {
+		log.Printf("mark drawing %s opened error: %v", drawingID, err)
+	} else {
+		drawing.LastOpenedAt = &now
+	}
+
+	c.JSON(http.StatusOK, drawing)
+}
+
+// updates a drawing's title, description and tags, or the current user's star and
+// pin, without touching its content. see listing.MetadataRequest
+func UpdateDrawingMetadata(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	drawingID := c.Param("drawingId")
+	if drawingID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Drawing ID is required"})
+		return
+	}
+
+	var req listing.MetadataRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	update, err := req.Update(user.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(update) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	drawing, role, err := findAccessibleDrawing(drawingID, user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
@@ -131,43 +308,116 @@ func GetDrawing(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
 		return
 	}
+	if req.Shared() && !role.CanWrite() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to edit this drawing"})
+		return
+	}
 
-	c.JSON(http.StatusOK, drawing)
+	var updated Drawing
+	err = drawingsCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": drawing.ID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update drawing"})
+		return
+	}
+	if req.Shared() {
+		search.Refresh(access.ResourceDrawing, drawingID)
+	}
+
+	updated.Role = role
+	updated.Personal.For(user.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Drawing updated successfully",
+		"drawing": updated,
+	})
 }
 
-// retrieves all drawings for the current user
+// retrieves a page of the drawings owned by or shared with the current user,
+// workspace drawings are listed per workspace. see listing.ParseQuery for the options
 func GetUserDrawings(c *gin.Context) {
-	userEmail, exists := c.Get("user_email")
+	user, exists := access.CurrentUser(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
 	}
 
-	filter := bson.M{"createdBy": userEmail}
-	cursor, err := drawingsCollection.Find(context.Background(), filter)
+	query, err := listing.ParseQuery(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := access.DirectFilter(user)
+	drawings, next, total, err := listing.Find[Drawing](context.Background(), drawingsCollection, filter, query)
 	if err != nil {
+		if err == listing.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawings"})
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	var drawings []Drawing
-	if err = cursor.All(context.Background(), &drawings); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode drawings"})
+	for i := range drawings {
+		drawings[i].Role = access.RoleFor(drawings[i].OwnerID, drawings[i].WorkspaceID, drawings[i].Collaborators, user)
+		drawings[i].Personal.For(user.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"drawings":   drawings,
+		"nextCursor": next,
+		"total":      total,
+	})
+}
+
+// retrieves a page of the drawings in a workspace the current user is a member of
+func GetWorkspaceDrawings(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	workspaceID := c.Param("workspaceId")
+	if user.Workspaces[workspaceID] == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return
+	}
+
+	query, err := listing.ParseQuery(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := bson.M{"workspaceId": workspaceID, "deletedAt": bson.M{"$exists": false}}
+	drawings, next, total, err := listing.Find[Drawing](context.Background(), drawingsCollection, filter, query)
+	if err != nil {
+		if err == listing.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawings"})
 		return
 	}
 
-	if drawings == nil {
-		drawings = []Drawing{}
+	for i := range drawings {
+		drawings[i].Role = access.RoleFor(drawings[i].OwnerID, drawings[i].WorkspaceID, drawings[i].Collaborators, user)
+		drawings[i].Personal.For(user.ID)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"drawings": drawings,
+		"drawings":   drawings,
+		"nextCursor": next,
+		"total":      total,
 	})
 }
 
 func DeleteDrawing(c *gin.Context) {
-	userEmail, exists := c.Get("user_email")
+	user, exists := access.CurrentUser(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
@@ -179,23 +429,928 @@ func DeleteDrawing(c *gin.Context) {
 		return
 	}
 
-	filter := bson.M{
-		"drawingId": drawingID,
-		"createdBy": userEmail,
+	drawing, role, err := findAccessibleDrawing(drawingID, user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can delete this drawing"})
+		return
 	}
 
-	result, err := drawingsCollection.DeleteOne(context.Background(), filter)
+	result, err := drawingsCollection.UpdateOne(context.Background(),
+		bson.M{"_id": drawing.ID, "deletedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deletedAt": time.Now(), "deletedBy": user.Email}},
+	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete drawing"})
 		return
 	}
 
-	if result.DeletedCount == 0 {
+	if result.ModifiedCount == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
 		return
 	}
+	search.Forget(access.ResourceDrawing, []string{drawing.DrawingID})
+	socket.CloseSession(drawing.DrawingID, "This drawing was moved to the trash")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Drawing moved to the trash",
+	})
+}
+
+// finds a drawing in the trash the user can restore or delete for good
+func findTrashedDrawing(drawingID string, user access.Principal) (Drawing, error) {
+	var drawing Drawing
+	filter := access.TrashFilter(user)
+	filter["drawingId"] = drawingID
+
+	err := drawingsCollection.FindOne(context.Background(), filter).Decode(&drawing)
+	if err != nil {
+		return drawing, err
+	}
+	drawing.Role = access.RoleOwner
+	return drawing, nil
+}
+
+// retrieves a page of the drawings in the trash the current user can restore, see
+// listing.ParseQuery for the options. they are deleted for good after the retention period
+func GetTrashedDrawings(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	query, err := listing.ParseQuery(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	drawings, next, total, err := listing.Find[Drawing](context.Background(), drawingsCollection, access.TrashFilter(user), query)
+	if err != nil {
+		if err == listing.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawings"})
+		return
+	}
+
+	for i := range drawings {
+		drawings[i].Role = access.RoleOwner
+		drawings[i].Personal.For(user.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"drawings":  drawings,
+		"nextCursor": next,
+		"total":      total,
+	})
+}
+
+// takes a drawing out of the trash
+func RestoreDrawing(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	drawing, err := findTrashedDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found in the trash"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	_, err = drawingsCollection.UpdateOne(context.Background(),
+		bson.M{"_id": drawing.ID},
+		bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore drawing"})
+		return
+	}
+	search.Refresh(access.ResourceDrawing, drawing.DrawingID)
+
+	drawing.DeletedAt = nil
+	drawing.DeletedBy = ""
+	drawing.Personal.For(user.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Drawing restored successfully",
+		"drawing": drawing,
+	})
+}
+
+// deletes a drawing in the trash for good, along with its history
+func DeleteTrashedDrawing(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	drawing, err := findTrashedDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found in the trash"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	if _, err := purgeDrawings(context.Background(), bson.M{"_id": drawing.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete drawing"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Drawing deleted permanently",
+	})
+}
+
+// deletes every drawing in the trash the current user can restore for good
+func EmptyTrashedDrawings(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	deleted, err := purgeDrawings(context.Background(), access.TrashFilter(user))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to empty the trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Trash emptied successfully",
+		"deleted": deleted,
+	})
+}
+
+// deletes the drawings matching filter for good, with their history, and returns how many
+func purgeDrawings(ctx context.Context, filter bson.M) (int, error) {
+	cursor, err := drawingsCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"drawingId": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var purged []Drawing
+	if err := cursor.All(ctx, &purged); err != nil {
+		return 0, err
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	ids := make(bson.A, len(purged))
+	drawingIDs := make([]string, len(purged))
+	for i, drawing := range purged {
+		ids[i] = drawing.ID
+		drawingIDs[i] = drawing.DrawingID
+	}
+
+	if _, err := drawingsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return 0, err
+	}
+	search.Forget(access.ResourceDrawing, drawingIDs)
+	if err := history.Delete(ctx, access.ResourceDrawing, drawingIDs); err != nil {
+		log.Printf("delete history of %d drawings error: %v", len(drawingIDs), err)
+	}
+	return len(purged), nil
+}
+
+// lists the owner and collaborators of a drawing
+func GetCollaborators(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	drawing, _, err := findAccessibleDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	collaborators := drawing.Collaborators
+	if collaborators == nil {
+		collaborators = []access.Collaborator{}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Drawing deleted successfully",
+		"owner":         drawing.CreatedBy,
+		"ownerId":       drawing.OwnerID,
+		"collaborators": collaborators,
+	})
+}
+
+// grants a user a role on a drawing, owner only
+func ShareDrawing(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req access.ShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !req.Role.Grantable() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be editor, commenter or viewer"})
+		return
+	}
+
+	drawing, role, err := findAccessibleDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can share this drawing"})
+		return
+	}
+
+	collaborator := access.Collaborator{
+		Email:   req.Email,
+		Role:    req.Role,
+		AddedBy: user.Email,
+		AddedAt: time.Now(),
+	}
+
+	// people with a verified account are granted by id, others get an invite
+	// matched by email once they verify it
+	invitee, err := access.LookupUser(context.Background(), req.Email)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+	if err == nil && invitee.EmailVerified {
+		collaborator.UserID = invitee.ID
+		collaborator.Email = invitee.Email
+	}
+
+	if collaborator.UserID == drawing.OwnerID || req.Email == drawing.CreatedBy {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Owner already has full access"})
+		return
+	}
+
+	if err := access.Grant(context.Background(), drawingsCollection, bson.M{"_id": drawing.ID}, collaborator); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share drawing"})
+		return
+	}
+
+	// apply the new role to anyone already in the live session, a workspace may give them more
+	if collaborator.UserID != "" {
+		if drawing.WorkspaceID == "" {
+			socket.UpdateUserRole(drawing.DrawingID, collaborator.UserID, collaborator.Role)
+		} else if err := WorkspaceMemberChanged(drawing.WorkspaceID, collaborator.UserID); err != nil {
+			log.Printf("refresh live role of %s error: %v", collaborator.UserID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Drawing shared successfully",
+		"collaborator": collaborator,
+	})
+}
+
+// removes a collaborator, the owner can remove anyone and collaborators can remove themselves
+func RevokeDrawingAccess(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	email := c.Param("email")
+	drawing, role, err := findAccessibleDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	if !role.CanManage() && email != user.Email {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can remove collaborators"})
+		return
+	}
+
+	removed, err := access.Revoke(context.Background(), drawingsCollection, bson.M{"_id": drawing.ID}, email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access"})
+		return
+	}
+
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collaborator not found"})
+		return
+	}
+
+	// kick them out of the live session too, workspace members keep their workspace role
+	if collaborator, found := access.FindCollaborator(drawing.Collaborators, email); found && collaborator.UserID != "" {
+		if drawing.WorkspaceID == "" {
+			socket.RevokeUserAccess(drawing.DrawingID, collaborator.UserID)
+		} else if err := WorkspaceMemberChanged(drawing.WorkspaceID, collaborator.UserID); err != nil {
+			log.Printf("refresh live role of %s error: %v", collaborator.UserID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Access revoked successfully",
+	})
+}
+
+// mints a share link for a drawing, owner only
+func CreateDrawingLink(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req access.CreateShareLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	drawing, role, err := findAccessibleDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can create share links"})
+		return
+	}
+
+	link, token, err := access.CreateShareLink(context.Background(), access.ResourceDrawing, drawing.DrawingID, access.Principal{ID: drawing.OwnerID, Email: drawing.CreatedBy}, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Share link created successfully",
+		"link":    link,
+		"token":   token,
+	})
+}
+
+// lists share links of a drawing, owner only
+func GetDrawingLinks(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	drawing, role, err := findAccessibleDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can view share links"})
+		return
+	}
+
+	links, err := access.ListShareLinks(context.Background(), access.ResourceDrawing, drawing.DrawingID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve share links"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"links": links,
+	})
+}
+
+// revokes a share link and drops anyone connected through it, owner only
+func RevokeDrawingLink(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	drawing, role, err := findAccessibleDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can revoke share links"})
+		return
+	}
+
+	linkID := c.Param("linkId")
+	revoked, err := access.RevokeShareLink(context.Background(), access.ResourceDrawing, drawing.DrawingID, linkID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+		return
+	}
+
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+
+	socket.RevokeShareLinkAccess(drawing.DrawingID, linkID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Share link revoked successfully",
+	})
+}
+
+// finds the drawing a share link points at
+func findSharedDrawing(c *gin.Context) (Drawing, *access.ShareLink, bool) {
+	var drawing Drawing
+
+	value, exists := c.Get("share_link")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Share token required"})
+		return drawing, nil, false
+	}
+	link := value.(*access.ShareLink)
+
+	if link.ResourceType != access.ResourceDrawing || link.ResourceID != c.Param("drawingId") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Share link is not valid for this drawing"})
+		return drawing, nil, false
+	}
+
+	filter := bson.M{
+		"drawingId":     link.ResourceID,
+		"deletedAt": bson.M{"$exists": false},
+	}
+	for key, value := range link.OwnerFilter() {
+		filter[key] = value
+	}
+
+	err := drawingsCollection.FindOne(context.Background(), filter).Decode(&drawing)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return drawing, nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return drawing, nil, false
+	}
+
+	// link holders see the content, not who else it is shared with
+	drawing.Role = link.Role
+	drawing.Collaborators = nil
+	return drawing, link, true
+}
+
+// retrieves a drawing through a share link
+func GetSharedDrawing(c *gin.Context) {
+	drawing, _, ok := findSharedDrawing(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, drawing)
+}
+
+// updates a drawing through a share link that grants editing
+func SaveSharedDrawing(c *gin.Context) {
+	var req struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	drawing, link, ok := findSharedDrawing(c)
+	if !ok {
+		return
+	}
+
+	if !link.Role.CanWrite() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This link does not allow editing"})
+		return
+	}
+
+	// persist what collaborators are seeing, not just this client's copy
+	if merged, ok := socket.MergeSessionScene(drawing.DrawingID, req.Content); ok {
+		req.Content = merged
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"content":    req.Content,
+			"searchText": search.Text(access.ResourceDrawing, req.Content),
+			"updatedAt":  time.Now(),
+		},
+	}
+
+	_, err := drawingsCollection.UpdateOne(context.Background(), bson.M{"_id": drawing.ID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update drawing"})
+		return
+	}
+
+	recordDrawingRevision(drawing.DrawingID, req.Content, history.ShareLinkAuthor(link.LinkID), history.SourceShareLink)
+	search.Refresh(access.ResourceDrawing, drawing.DrawingID)
+
+	drawing.Content = req.Content
+	drawing.UpdatedAt = time.Now()
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Drawing updated successfully",
+		"drawing": drawing,
+	})
+}
+
+// stores a history revision, failures are logged so the save itself still succeeds
+func recordDrawingRevision(drawingID string, content string, author string, source string) {
+	if _, err := history.Record(context.Background(), access.ResourceDrawing, drawingID, content, author, source); err != nil {
+		log.Printf("record revision for drawing %s error: %v", drawingID, err)
+	}
+}
+
+// parses a revision number from a path or query value
+func parseRevisionNumber(value string) (int, bool) {
+	number, err := strconv.Atoi(value)
+	return number, err == nil && number > 0
+}
+
+// lists the saved revisions of a drawing, newest first
+func ListDrawingRevisions(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	drawing, _, err := findAccessibleDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	revisions, err := history.List(context.Background(), access.ResourceDrawing, drawing.DrawingID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revisions"})
+		return
+	}
+	if err := history.NameAuthors(context.Background(), revisions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revisions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revisions": revisions,
+	})
+}
+
+// retrieves one revision of a drawing with its content
+func GetDrawingRevision(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	number, ok := parseRevisionNumber(c.Param("revision"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision number"})
+		return
+	}
+
+	drawing, _, err := findAccessibleDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	revision, err := history.Get(context.Background(), access.ResourceDrawing, drawing.DrawingID, number)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+		return
+	}
+	revisions := []history.Revision{*revision}
+	if err := history.NameAuthors(context.Background(), revisions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+		return
+	}
+
+	c.JSON(http.StatusOK, revisions[0])
+}
+
+// compares two revisions of a drawing, ?from=1&to=2
+func DiffDrawingRevisions(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	fromNumber, fromOk := parseRevisionNumber(c.Query("from"))
+	toNumber, toOk := parseRevisionNumber(c.Query("to"))
+	if !fromOk || !toOk {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to revision numbers are required"})
+		return
+	}
+
+	drawing, _, err := findAccessibleDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	revisions := make([]*history.Revision, 2)
+	for i, number := range []int{fromNumber, toNumber} {
+		revisions[i], err = history.Get(context.Background(), access.ResourceDrawing, drawing.DrawingID, number)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+			return
+		}
+	}
+	from, to := revisions[0], revisions[1]
+
+	diff, err := history.DiffScenes(from.Content, to.Content)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Revision content is not a valid drawing"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from.Number,
+		"to":       to.Number,
+		"elements": diff,
+	})
+}
+
+// makes an old revision the current content, recorded as a new revision
+func RestoreDrawingRevision(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	number, ok := parseRevisionNumber(c.Param("revision"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision number"})
+		return
+	}
+
+	drawing, role, err := findAccessibleDrawing(c.Param("drawingId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve drawing"})
+		return
+	}
+
+	if !role.CanWrite() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to edit this drawing"})
+		return
+	}
+
+	revision, err := history.Get(context.Background(), access.ResourceDrawing, drawing.DrawingID, number)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+		return
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"content":    revision.Content,
+			"searchText": search.Text(access.ResourceDrawing, revision.Content),
+			"updatedAt":  time.Now(),
+		},
+	}
+
+	_, err = drawingsCollection.UpdateOne(context.Background(), bson.M{"_id": drawing.ID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore drawing"})
+		return
+	}
+
+	recordDrawingRevision(drawing.DrawingID, revision.Content, user.ID, history.SourceRestore)
+	search.Refresh(access.ResourceDrawing, drawing.DrawingID)
+
+	// everyone in the live session switches to the restored content
+	socket.ResetSessionContent(drawing.DrawingID, socket.SessionDrawing, revision.Content)
+
+	drawing.Content = revision.Content
+	drawing.UpdatedAt = time.Now()
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Drawing restored to revision " + strconv.Itoa(number),
+		"drawing": drawing,
+	})
+}
+
+// ClaimInvites binds the email invites to drawings for a newly verified address to the user
+func ClaimInvites(user access.Principal) error {
+	return access.ClaimInvites(context.Background(), drawingsCollection, user)
+}
+
+// RenameUser refreshes the owner and collaborator emails shown on drawings after an email change
+func RenameUser(user access.Principal, oldEmail string) error {
+	return access.ReplaceUser(context.Background(), drawingsCollection, user, oldEmail)
+}
+
+// RemoveUser cleans up after a deleted account. owned drawings go to transferTo,
+// or are deleted with their history when it is empty, and the user leaves every ACL
+func RemoveUser(user access.Principal, transferTo access.Principal) error {
+	ctx := context.Background()
+
+	cursor, err := drawingsCollection.Find(ctx, bson.M{"ownerId": user.ID})
+	if err != nil {
+		return err
+	}
+	var owned []Drawing
+	if err := cursor.All(ctx, &owned); err != nil {
+		return err
+	}
+	drawingIDs := make([]string, len(owned))
+	for i, drawing := range owned {
+		drawingIDs[i] = drawing.DrawingID
+	}
+
+	if transferTo.ID != "" {
+		if err := access.TransferOwnership(ctx, drawingsCollection, user, transferTo); err != nil {
+			return err
+		}
+		if err := access.TransferShareLinks(ctx, access.ResourceDrawing, drawingIDs, transferTo); err != nil {
+			return err
+		}
+		for _, drawingID := range drawingIDs {
+			socket.UpdateUserRole(drawingID, transferTo.ID, access.RoleOwner)
+		}
+	} else {
+		if _, err := drawingsCollection.DeleteMany(ctx, bson.M{"ownerId": user.ID}); err != nil {
+			return err
+		}
+		search.Forget(access.ResourceDrawing, drawingIDs)
+		for _, drawingID := range drawingIDs {
+			socket.CloseSession(drawingID, "This drawing was deleted")
+		}
+		if err := history.Delete(ctx, access.ResourceDrawing, drawingIDs); err != nil {
+			log.Printf("delete history of %d drawings error: %v", len(drawingIDs), err)
+		}
+	}
+
+	if err := listing.ForgetUser(ctx, drawingsCollection, user.ID); err != nil {
+		return err
+	}
+	return access.RemoveCollaborator(ctx, drawingsCollection, user)
+}
+
+// drawings in a workspace outside the trash, without their content
+func findWorkspaceDrawings(ctx context.Context, workspaceID string) ([]Drawing, error) {
+	cursor, err := drawingsCollection.Find(ctx,
+		bson.M{"workspaceId": workspaceID, "deletedAt": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"content": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var drawings []Drawing
+	if err := cursor.All(ctx, &drawings); err != nil {
+		return nil, err
+	}
+	return drawings, nil
+}
+
+// gives the live sessions of userIDs the role they now have on each drawing
+func refreshLiveRoles(ctx context.Context, drawings []Drawing, userIDs []string) error {
+	for _, userID := range userIDs {
+		user, err := access.LoadPrincipal(ctx, userID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		for _, drawing := range drawings {
+			role := access.RoleFor(drawing.OwnerID, drawing.WorkspaceID, drawing.Collaborators, user)
+			if role == "" {
+				socket.RevokeUserAccess(drawing.DrawingID, userID)
+			} else {
+				socket.UpdateUserRole(drawing.DrawingID, userID, role)
+			}
+		}
+	}
+	return nil
+}
+
+// WorkspaceMemberChanged updates the live sessions of a user whose workspace role changed or who left it
+func WorkspaceMemberChanged(workspaceID string, userID string) error {
+	ctx := context.Background()
+	drawings, err := findWorkspaceDrawings(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	return refreshLiveRoles(ctx, drawings, []string{userID})
+}
+
+// WorkspaceDeleted moves the drawings of a deleted workspace out of it, they stay with
+// their owners and former members keep only what was shared with them directly
+func WorkspaceDeleted(workspaceID string, memberIDs []string) error {
+	ctx := context.Background()
+	drawings, err := findWorkspaceDrawings(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+
+	_, err = drawingsCollection.UpdateMany(ctx,
+		bson.M{"workspaceId": workspaceID},
+		bson.M{"$unset": bson.M{"workspaceId": ""}},
+	)
+	if err != nil {
+		return err
+	}
+
+	for i := range drawings {
+		drawings[i].WorkspaceID = ""
+	}
+	return refreshLiveRoles(ctx, drawings, memberIDs)
+}
+
+// PurgeTrash deletes the drawings that were moved to the trash before cutoff for good
+func PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	return purgeDrawings(ctx, bson.M{"deletedAt": bson.M{"$lt": cutoff}})
+}
+
+// EnsureIndexes creates the indexes drawings lookups and lists rely on
+func EnsureIndexes(ctx context.Context) error {
+	indexes := append(listing.Indexes("drawingId"), mongo.IndexModel{
+		Keys: bson.D{{Key: "deletedAt", Value: 1}},
+		// only trashed records, for the purge
+		Options: options.Index().SetPartialFilterExpression(bson.M{"deletedAt": bson.M{"$exists": true}}),
 	})
+	_, err := drawingsCollection.Indexes().CreateMany(ctx, indexes)
+	return err
 }
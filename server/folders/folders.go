@@ -0,0 +1,681 @@
+package folders
+
+import (
+	"collabify-backend/access"
+	"collabify-backend/listing"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// folders can't nest deeper than this, it also bounds breadcrumb lookups
+const maxDepth = 20
+
+var (
+	errFolderCycle = errors.New("a folder can't be moved into itself")
+	errFolderDepth = errors.New("folders are nested too deep")
+)
+
+// Folder groups documents and drawings. personal folders belong to their
+// owner, workspace folders to everyone in the workspace
+type Folder struct {
+	ID          interface{} `json:"-" bson:"_id,omitempty"`
+	FolderID    string      `json:"id" bson:"folderId"`
+	Name        string      `json:"name" bson:"name"`
+	ParentID    string      `json:"parentId" bson:"parentId"` // "" at the top level
+	WorkspaceID string      `json:"workspaceId,omitempty" bson:"workspaceId,omitempty"`
+	OwnerID     string      `json:"ownerId" bson:"ownerId"` // who made it, for workspace folders
+	CreatedAt   time.Time   `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time   `json:"updatedAt" bson:"updatedAt"`
+	Role        access.Role `json:"role,omitempty" bson:"-"` // caller's role, filled per request
+}
+
+// Breadcrumb is one step on the path from the top level to a folder
+type Breadcrumb struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Item is a document or drawing listed in a folder, without its content
+type Item struct {
+	Type             string                `json:"type" bson:"-"` // access.ResourceDocument or access.ResourceDrawing
+	ID               string                `json:"id" bson:"-"`
+	DocID            string                `json:"-" bson:"docId,omitempty"`
+	DrawingID        string                `json:"-" bson:"drawingId,omitempty"`
+	OwnerID          string                `json:"ownerId" bson:"ownerId"`
+	WorkspaceID      string                `json:"workspaceId,omitempty" bson:"workspaceId,omitempty"`
+	FolderID         string                `json:"folderId,omitempty" bson:"folderId,omitempty"`
+	Title            string                `json:"title" bson:"title"`
+	Description      string                `json:"description" bson:"description,omitempty"`
+	Tags             []string              `json:"tags" bson:"tags,omitempty"`
+	CreatedBy        string                `json:"createdBy" bson:"createdBy"`
+	CreatedAt        time.Time             `json:"createdAt" bson:"createdAt"`
+	UpdatedAt        time.Time             `json:"updatedAt" bson:"updatedAt"`
+	Collaborators    []access.Collaborator `json:"-" bson:"collaborators,omitempty"`
+	Role             access.Role           `json:"role,omitempty" bson:"-"`
+	listing.Personal `bson:",inline"`
+}
+
+type CreateFolderRequest struct {
+	Name        string `json:"name" binding:"required"`
+	ParentID    string `json:"parentId"`
+	WorkspaceID string `json:"workspaceId"` // only for top level folders, subfolders follow their parent
+}
+
+// fields left out of the request are not changed, "" parentId moves to the top level
+type UpdateFolderRequest struct {
+	Name     *string `json:"name"`
+	ParentID *string `json:"parentId"`
+}
+
+type MoveItemRequest struct {
+	FolderID string `json:"folderId"` // "" moves to the top level
+}
+
+var (
+	foldersCollection  *mongo.Collection
+	docsCollection     *mongo.Collection
+	drawingsCollection *mongo.Collection
+)
+
+// SetFoldersCollection sets the folders collection
+func SetFoldersCollection(collection *mongo.Collection) {
+	foldersCollection = collection
+}
+
+// SetDocsCollection sets the documents collection folders list and move
+func SetDocsCollection(collection *mongo.Collection) {
+	docsCollection = collection
+}
+
+// SetDrawingsCollection sets the drawings collection folders list and move
+func SetDrawingsCollection(collection *mongo.Collection) {
+	drawingsCollection = collection
+}
+
+// EnsureIndexes creates the indexes folder lookups rely on
+func EnsureIndexes(ctx context.Context) error {
+	_, err := foldersCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "folderId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "parentId", Value: 1}, {Key: "name", Value: 1}}},
+		{Keys: bson.D{{Key: "ownerId", Value: 1}, {Key: "name", Value: 1}}},
+		{Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "name", Value: 1}}},
+	})
+	return err
+}
+
+type itemSource struct {
+	resourceType string
+	collection   *mongo.Collection
+	idField      string
+}
+
+func itemSources() []itemSource {
+	return []itemSource{
+		{access.ResourceDocument, docsCollection, "docId"},
+		{access.ResourceDrawing, drawingsCollection, "drawingId"},
+	}
+}
+
+// the role user has on a folder, "" when they can't see it
+func folderRole(folder Folder, user access.Principal) access.Role {
+	if folder.WorkspaceID != "" {
+		return user.Workspaces[folder.WorkspaceID]
+	}
+	if folder.OwnerID == user.ID {
+		return access.RoleOwner
+	}
+	return ""
+}
+
+// finds a folder the user can see, along with their role
+func findFolder(ctx context.Context, folderID string, user access.Principal) (Folder, access.Role, error) {
+	var folder Folder
+	err := foldersCollection.FindOne(ctx, bson.M{"folderId": folderID}).Decode(&folder)
+	if err != nil {
+		return folder, "", err
+	}
+
+	role := folderRole(folder, user)
+	if role == "" {
+		return folder, "", mongo.ErrNoDocuments
+	}
+	folder.Role = role
+	return folder, role, nil
+}
+
+// the folders above folder, top level first
+func breadcrumbs(ctx context.Context, folder Folder) ([]Breadcrumb, error) {
+	path := []Breadcrumb{}
+	parentID := folder.ParentID
+	for parentID != "" && len(path) < maxDepth {
+		var parent Folder
+		err := foldersCollection.FindOne(ctx, bson.M{"folderId": parentID}).Decode(&parent)
+		if err == mongo.ErrNoDocuments {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		path = append([]Breadcrumb{{ID: parent.FolderID, Name: parent.Name}}, path...)
+		parentID = parent.ParentID
+	}
+	return path, nil
+}
+
+// checks folderID can go under parent without a loop or getting too deep
+func checkPlacement(ctx context.Context, folderID string, parent Folder) error {
+	path, err := breadcrumbs(ctx, parent)
+	if err != nil {
+		return err
+	}
+	if len(path)+1 >= maxDepth {
+		return errFolderDepth
+	}
+	if parent.FolderID == folderID {
+		return errFolderCycle
+	}
+	for _, crumb := range path {
+		if crumb.ID == folderID {
+			return errFolderCycle
+		}
+	}
+	return nil
+}
+
+func validName(name string) bool {
+	return name != "" && len(name) <= 100
+}
+
+func newFolderID() (string, error) {
+	bytes := make([]byte, 12)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// creates a folder at the top level or inside another one
+func CreateFolder(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req CreateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if !validName(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be 1 to 100 characters"})
+		return
+	}
+
+	ctx := context.Background()
+	folder := Folder{
+		Name:        req.Name,
+		WorkspaceID: req.WorkspaceID,
+		OwnerID:     user.ID,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if req.ParentID != "" {
+		parent, role, err := findFolder(ctx, req.ParentID, user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Parent folder not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve folder"})
+			return
+		}
+		if !role.CanWrite() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can't add folders here"})
+			return
+		}
+		if err := checkPlacement(ctx, "", parent); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		folder.ParentID = parent.FolderID
+		folder.WorkspaceID = parent.WorkspaceID
+	}
+
+	role := folderRole(folder, user)
+	if !role.CanWrite() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't add folders to this workspace"})
+		return
+	}
+	folder.Role = role
+
+	folderID, err := newFolderID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folder"})
+		return
+	}
+	folder.FolderID = folderID
+
+	if _, err := foldersCollection.InsertOne(ctx, folder); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folder"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Folder created successfully",
+		"folder":  folder,
+	})
+}
+
+// lists every personal folder, or every folder of ?workspaceId=, so clients can build the tree
+func GetFolders(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	filter := bson.M{"ownerId": user.ID, "workspaceId": bson.M{"$exists": false}}
+	if workspaceID := c.Query("workspaceId"); workspaceID != "" {
+		if user.Workspaces[workspaceID] == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+			return
+		}
+		filter = bson.M{"workspaceId": workspaceID}
+	}
+
+	cursor, err := foldersCollection.Find(context.Background(), filter,
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve folders"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var folders []Folder
+	if err = cursor.All(context.Background(), &folders); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode folders"})
+		return
+	}
+
+	if folders == nil {
+		folders = []Folder{}
+	}
+
+	for i := range folders {
+		folders[i].Role = folderRole(folders[i], user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"folders": folders,
+	})
+}
+
+// retrieves a folder with its breadcrumbs, subfolders and the documents and
+// drawings in it the caller can open
+func GetFolder(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	ctx := context.Background()
+	folder, _, err := findFolder(ctx, c.Param("folderId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve folder"})
+		return
+	}
+
+	path, err := breadcrumbs(ctx, folder)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve folder"})
+		return
+	}
+
+	cursor, err := foldersCollection.Find(ctx,
+		bson.M{"parentId": folder.FolderID},
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve folders"})
+		return
+	}
+	var subfolders []Folder
+	if err := cursor.All(ctx, &subfolders); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode folders"})
+		return
+	}
+	if subfolders == nil {
+		subfolders = []Folder{}
+	}
+	for i := range subfolders {
+		subfolders[i].Role = folder.Role
+	}
+
+	items := []Item{}
+	for _, source := range itemSources() {
+		filter := access.Filter(user)
+		filter["folderId"] = folder.FolderID
+
+		cursor, err := source.collection.Find(ctx, filter,
+			options.Find().
+				SetProjection(bson.M{"content": 0}).
+				SetSort(bson.D{{Key: "updatedAt", Value: -1}}),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve folder contents"})
+			return
+		}
+		var found []Item
+		if err := cursor.All(ctx, &found); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode folder contents"})
+			return
+		}
+		for _, item := range found {
+			item.Type = source.resourceType
+			item.ID = item.DocID + item.DrawingID
+			item.Role = access.RoleFor(item.OwnerID, item.WorkspaceID, item.Collaborators, user)
+			item.Personal.For(user.ID)
+			items = append(items, item)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"folder":      folder,
+		"breadcrumbs": path,
+		"folders":     subfolders,
+		"items":       items,
+	})
+}
+
+// renames a folder or moves it under another one in the same space
+func UpdateFolder(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req UpdateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	folder, role, err := findFolder(ctx, c.Param("folderId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve folder"})
+		return
+	}
+
+	if !role.CanWrite() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to change this folder"})
+		return
+	}
+
+	update := bson.M{"updatedAt": time.Now()}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if !validName(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be 1 to 100 characters"})
+			return
+		}
+		update["name"] = name
+		folder.Name = name
+	}
+
+	if req.ParentID != nil && *req.ParentID != folder.ParentID {
+		if *req.ParentID != "" {
+			parent, parentRole, err := findFolder(ctx, *req.ParentID, user)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					c.JSON(http.StatusNotFound, gin.H{"error": "Parent folder not found"})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve folder"})
+				return
+			}
+			if !parentRole.CanWrite() || parent.WorkspaceID != folder.WorkspaceID {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You can't move this folder there"})
+				return
+			}
+			if err := checkPlacement(ctx, folder.FolderID, parent); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		update["parentId"] = *req.ParentID
+		folder.ParentID = *req.ParentID
+	}
+
+	if _, err := foldersCollection.UpdateOne(ctx, bson.M{"_id": folder.ID}, bson.M{"$set": update}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update folder"})
+		return
+	}
+	folder.UpdatedAt = update["updatedAt"].(time.Time)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Folder updated successfully",
+		"folder":  folder,
+	})
+}
+
+// deletes a folder, what was in it moves up to its parent
+func DeleteFolder(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	ctx := context.Background()
+	folder, role, err := findFolder(ctx, c.Param("folderId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve folder"})
+		return
+	}
+
+	if !role.CanWrite() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to delete this folder"})
+		return
+	}
+
+	_, err = foldersCollection.UpdateMany(ctx,
+		bson.M{"parentId": folder.FolderID},
+		bson.M{"$set": bson.M{"parentId": folder.ParentID}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+		return
+	}
+	if err := moveItems(ctx, []string{folder.FolderID}, folder.ParentID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+		return
+	}
+
+	if _, err := foldersCollection.DeleteOne(ctx, bson.M{"_id": folder.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Folder deleted successfully",
+	})
+}
+
+// moves every document and drawing in folderIDs to folderID, "" for the top level
+func moveItems(ctx context.Context, folderIDs []string, folderID string) error {
+	update := bson.M{"$set": bson.M{"folderId": folderID}}
+	if folderID == "" {
+		update = bson.M{"$unset": bson.M{"folderId": ""}}
+	}
+
+	for _, source := range itemSources() {
+		_, err := source.collection.UpdateMany(ctx, bson.M{"folderId": bson.M{"$in": folderIDs}}, update)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoveDocument files a document into a folder
+func MoveDocument(c *gin.Context) {
+	moveItem(c, itemSources()[0], c.Param("docId"), "Document")
+}
+
+// MoveDrawing files a drawing into a folder
+func MoveDrawing(c *gin.Context) {
+	moveItem(c, itemSources()[1], c.Param("drawingId"), "Drawing")
+}
+
+// an item's folder is the same for everyone who can see it, so personal items
+// are filed by their owner and workspace items by workspace editors, and only
+// into folders of the same space
+func moveItem(c *gin.Context, source itemSource, itemID string, noun string) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req MoveItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	filter := access.Filter(user)
+	filter[source.idField] = itemID
+
+	var item Item
+	err := source.collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"content": 0})).Decode(&item)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": noun + " not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve " + strings.ToLower(noun)})
+		return
+	}
+
+	role := access.RoleFor(item.OwnerID, item.WorkspaceID, item.Collaborators, user)
+	allowed := role.CanWrite() && (item.WorkspaceID != "" || item.OwnerID == user.ID)
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to move this " + strings.ToLower(noun)})
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"folderId": ""}}
+	if req.FolderID != "" {
+		folder, folderRole, err := findFolder(ctx, req.FolderID, user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve folder"})
+			return
+		}
+		if !folderRole.CanWrite() || folder.WorkspaceID != item.WorkspaceID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can't move this " + strings.ToLower(noun) + " there"})
+			return
+		}
+		update = bson.M{"$set": bson.M{"folderId": folder.FolderID}}
+	}
+
+	if _, err := source.collection.UpdateOne(ctx, bson.M{source.idField: itemID}, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move " + strings.ToLower(noun)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  noun + " moved successfully",
+		"folderId": req.FolderID,
+	})
+}
+
+// WorkspaceDeleted removes the folders of a deleted workspace, its documents
+// and drawings end up at their owners' top level
+func WorkspaceDeleted(workspaceID string, memberIDs []string) error {
+	ctx := context.Background()
+	folderIDs, err := folderIDsWhere(ctx, bson.M{"workspaceId": workspaceID})
+	if err != nil || len(folderIDs) == 0 {
+		return err
+	}
+
+	if err := moveItems(ctx, folderIDs, ""); err != nil {
+		return err
+	}
+	_, err = foldersCollection.DeleteMany(ctx, bson.M{"workspaceId": workspaceID})
+	return err
+}
+
+// RemoveUser hands a deleted account's personal folders to transferTo, who
+// also got their documents and drawings, or deletes them
+func RemoveUser(user access.Principal, transferTo access.Principal) error {
+	ctx := context.Background()
+	personal := bson.M{"ownerId": user.ID, "workspaceId": bson.M{"$exists": false}}
+
+	if transferTo.ID != "" {
+		_, err := foldersCollection.UpdateMany(ctx, personal, bson.M{"$set": bson.M{"ownerId": transferTo.ID}})
+		return err
+	}
+
+	folderIDs, err := folderIDsWhere(ctx, personal)
+	if err != nil || len(folderIDs) == 0 {
+		return err
+	}
+	// anything still filed there goes to the top level
+	if err := moveItems(ctx, folderIDs, ""); err != nil {
+		return err
+	}
+	_, err = foldersCollection.DeleteMany(ctx, personal)
+	return err
+}
+
+func folderIDsWhere(ctx context.Context, filter bson.M) ([]string, error) {
+	cursor, err := foldersCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"folderId": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var folders []Folder
+	if err := cursor.All(ctx, &folders); err != nil {
+		return nil, err
+	}
+
+	folderIDs := make([]string, len(folders))
+	for i, folder := range folders {
+		folderIDs[i] = folder.FolderID
+	}
+	return folderIDs, nil
+}
@@ -0,0 +1,108 @@
+package history
+
+const (
+	GranularityWord = "word"
+	GranularityLine = "line"
+)
+
+// Span is a run of text in a diff, inserted and deleted spans carry who made the change
+type Span struct {
+	Type     string `json:"type"` // ChangeEqual, ChangeInsert or ChangeDelete
+	Text     string `json:"text"`
+	Author   string `json:"author,omitempty"`
+	Revision int    `json:"revision,omitempty"` // revision that made the change, 0 for unsaved content
+}
+
+// Step is one version of the text along the way from the base to the target
+type Step struct {
+	Content  string
+	Author   string
+	Revision int
+}
+
+type attributedToken struct {
+	text     string
+	id       int // ids below len(base) are base tokens
+	author   string
+	revision int
+}
+
+// Attribute diffs base against the last step and credits each inserted or
+// deleted span to the step that introduced it, walking every step in order
+func Attribute(base Step, steps []Step, granularity string) []Span {
+	split := splitWords
+	if granularity == GranularityLine {
+		split = splitLines
+	}
+
+	baseTokens := split(base.Content)
+	current := make([]attributedToken, len(baseTokens))
+	for i, text := range baseTokens {
+		current[i] = attributedToken{text: text, id: i}
+	}
+
+	deletedBy := make(map[int]Step)
+	nextID := len(baseTokens)
+
+	for _, step := range steps {
+		texts := make([]string, len(current))
+		for i, token := range current {
+			texts[i] = token.text
+		}
+		target := split(step.Content)
+
+		next := make([]attributedToken, 0, len(target))
+		for _, edit := range diffEdits(texts, target) {
+			switch edit.Type {
+			case ChangeEqual:
+				next = append(next, current[edit.From])
+			case ChangeDelete:
+				if id := current[edit.From].id; id < len(baseTokens) {
+					deletedBy[id] = step
+				}
+			case ChangeInsert:
+				next = append(next, attributedToken{text: target[edit.To], id: nextID, author: step.Author, revision: step.Revision})
+				nextID++
+			}
+		}
+		current = next
+	}
+
+	var spans []Span
+	add := func(span Span) {
+		if n := len(spans); n > 0 {
+			last := &spans[n-1]
+			if last.Type == span.Type && last.Author == span.Author && last.Revision == span.Revision {
+				last.Text += span.Text
+				return
+			}
+		}
+		spans = append(spans, span)
+	}
+	addDeleted := func(id int) {
+		step := deletedBy[id]
+		add(Span{Type: ChangeDelete, Text: baseTokens[id], Author: step.Author, Revision: step.Revision})
+	}
+
+	// surviving base tokens keep their order, so deletions slot in before the next survivor
+	pending := 0
+	for _, token := range current {
+		if token.id >= len(baseTokens) {
+			add(Span{Type: ChangeInsert, Text: token.text, Author: token.author, Revision: token.revision})
+			continue
+		}
+		for ; pending < token.id; pending++ {
+			addDeleted(pending)
+		}
+		add(Span{Type: ChangeEqual, Text: token.text})
+		pending = token.id + 1
+	}
+	for ; pending < len(baseTokens); pending++ {
+		addDeleted(pending)
+	}
+
+	if spans == nil {
+		spans = []Span{}
+	}
+	return spans
+}
@@ -0,0 +1,108 @@
+package history
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"collabify-backend/access"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// authors of edits made without an account
+const (
+	guestAuthorPrefix     = "guest-"
+	shareLinkAuthorPrefix = "link:"
+)
+
+// ShareLinkAuthor is the author of revisions saved through a share link
+func ShareLinkAuthor(linkID string) string {
+	return shareLinkAuthorPrefix + linkID
+}
+
+// AuthorNames maps revision authors to what is shown for them. accounts are
+// stored by user id and shown by their current name, so renames and email
+// changes carry over to old revisions
+func AuthorNames(ctx context.Context, authors []string) (map[string]string, error) {
+	names, err := access.DisplayNames(ctx, authors)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, author := range authors {
+		if _, ok := names[author]; ok || author == "" {
+			continue
+		}
+		_, idErr := bson.ObjectIDFromHex(author)
+		switch {
+		case strings.HasPrefix(author, shareLinkAuthorPrefix):
+			names[author] = "Share link"
+		case strings.HasPrefix(author, guestAuthorPrefix):
+			names[author] = "Guest"
+		case idErr == nil:
+			names[author] = "Deleted user"
+		default:
+			// an email recorded before authors were user ids, with no account to move it to
+			names[author] = author
+		}
+	}
+	return names, nil
+}
+
+// NameAuthors fills in AuthorName on each revision
+func NameAuthors(ctx context.Context, revisions []Revision) error {
+	authors := make([]string, 0, len(revisions))
+	for _, revision := range revisions {
+		authors = append(authors, revision.Author)
+	}
+
+	names, err := AuthorNames(ctx, authors)
+	if err != nil {
+		return err
+	}
+	for i := range revisions {
+		revisions[i].AuthorName = names[revisions[i].Author]
+	}
+	return nil
+}
+
+// MigrateAuthors replaces authors recorded as emails with the user ids of
+// their accounts. run at startup, revisions already keyed by id are left alone
+func MigrateAuthors(ctx context.Context) error {
+	if revisionsCollection == nil {
+		return nil
+	}
+
+	var emails []string
+	err := revisionsCollection.Distinct(ctx, "author", bson.M{"author": bson.M{"$regex": "@"}}).Decode(&emails)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	var migrated int64
+	for _, email := range emails {
+		user, err := access.LookupUser(ctx, email)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		result, err := revisionsCollection.UpdateMany(ctx,
+			bson.M{"author": email},
+			bson.M{"$set": bson.M{"author": user.ID}},
+		)
+		if err != nil {
+			return err
+		}
+		migrated += result.ModifiedCount
+	}
+
+	if migrated > 0 {
+		log.Printf("Migrated %d revisions to author user ids", migrated)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package history
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+const (
+	ChangeEqual  = "equal"
+	ChangeInsert = "insert"
+	ChangeDelete = "delete"
+
+	// above this many lines*lines we skip the LCS table and report a full replace
+	maxDiffCells = 4_000_000
+)
+
+// Change is one run of equal, inserted or deleted text
+type Change struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// SceneDiff lists drawing elements by id that differ between two revisions
+type SceneDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// DiffLines compares two texts line by line
+func DiffLines(from, to string) []Change {
+	return diffTokens(splitLines(from), splitLines(to))
+}
+
+// DiffWords compares two texts word by word
+func DiffWords(from, to string) []Change {
+	return diffTokens(splitWords(from), splitWords(to))
+}
+
+// keeps the trailing newline on every line so joining changes gives the text back
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// splits into runs of letters/digits, runs of whitespace and single other characters
+func splitWords(text string) []string {
+	var tokens []string
+	start := 0
+	kind := func(r rune) int {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			return 1
+		case unicode.IsSpace(r):
+			return 2
+		}
+		return 3
+	}
+
+	prev := 0
+	for i, r := range text {
+		k := kind(r)
+		if i > start && (k != prev || k == 3) {
+			tokens = append(tokens, text[start:i])
+			start = i
+		}
+		prev = k
+	}
+	if start < len(text) {
+		tokens = append(tokens, text[start:])
+	}
+	return tokens
+}
+
+// one token level step of a diff, From/To index into the compared token lists
+type tokenEdit struct {
+	Type string
+	From int
+	To   int
+}
+
+// merges token edits into runs of the same kind
+func diffTokens(a, b []string) []Change {
+	var changes []Change
+	for _, edit := range diffEdits(a, b) {
+		text := ""
+		if edit.Type == ChangeInsert {
+			text = b[edit.To]
+		} else {
+			text = a[edit.From]
+		}
+
+		if n := len(changes); n > 0 && changes[n-1].Type == edit.Type {
+			changes[n-1].Text += text
+			continue
+		}
+		changes = append(changes, Change{Type: edit.Type, Text: text})
+	}
+
+	if changes == nil {
+		changes = []Change{}
+	}
+	return changes
+}
+
+// longest common subsequence diff over tokens
+func diffEdits(a, b []string) []tokenEdit {
+	var edits []tokenEdit
+
+	// common prefix and suffix keep the table small for typical edits
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		edits = append(edits, tokenEdit{Type: ChangeEqual, From: prefix, To: prefix})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	endA, endB := len(a)-suffix, len(b)-suffix
+	i, j := prefix, prefix
+
+	if (endA-prefix)*(endB-prefix) <= maxDiffCells {
+		// lcs[x][y] is the LCS length of a[prefix+x:endA] and b[prefix+y:endB]
+		rows, cols := endA-prefix, endB-prefix
+		lcs := make([][]int, rows+1)
+		for x := range lcs {
+			lcs[x] = make([]int, cols+1)
+		}
+		for x := rows - 1; x >= 0; x-- {
+			for y := cols - 1; y >= 0; y-- {
+				if a[prefix+x] == b[prefix+y] {
+					lcs[x][y] = lcs[x+1][y+1] + 1
+				} else {
+					lcs[x][y] = max(lcs[x+1][y], lcs[x][y+1])
+				}
+			}
+		}
+
+		for i < endA && j < endB {
+			x, y := i-prefix, j-prefix
+			switch {
+			case a[i] == b[j]:
+				edits = append(edits, tokenEdit{Type: ChangeEqual, From: i, To: j})
+				i++
+				j++
+			case lcs[x+1][y] >= lcs[x][y+1]:
+				edits = append(edits, tokenEdit{Type: ChangeDelete, From: i, To: j})
+				i++
+			default:
+				edits = append(edits, tokenEdit{Type: ChangeInsert, From: i, To: j})
+				j++
+			}
+		}
+	}
+
+	// whatever is left is a plain replace
+	for ; i < endA; i++ {
+		edits = append(edits, tokenEdit{Type: ChangeDelete, From: i, To: j})
+	}
+	for ; j < endB; j++ {
+		edits = append(edits, tokenEdit{Type: ChangeInsert, From: i, To: j})
+	}
+
+	for k := 0; k < suffix; k++ {
+		edits = append(edits, tokenEdit{Type: ChangeEqual, From: endA + k, To: endB + k})
+	}
+
+	return edits
+}
+
+type sceneElementVersion struct {
+	ID        string `json:"id"`
+	Version   int    `json:"version"`
+	IsDeleted bool   `json:"isDeleted"`
+}
+
+// DiffScenes compares two serialized drawings by element id
+func DiffScenes(from, to string) (SceneDiff, error) {
+	diff := SceneDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+
+	before, err := sceneVersions(from)
+	if err != nil {
+		return diff, err
+	}
+	after, err := sceneVersions(to)
+	if err != nil {
+		return diff, err
+	}
+
+	for _, element := range after.order {
+		old, existed := before.elements[element]
+		now := after.elements[element]
+		switch {
+		case now.IsDeleted && existed && !old.IsDeleted:
+			diff.Removed = append(diff.Removed, element)
+		case now.IsDeleted:
+			// deleted on both sides, nothing to show
+		case !existed || old.IsDeleted:
+			diff.Added = append(diff.Added, element)
+		case old.Version != now.Version:
+			diff.Changed = append(diff.Changed, element)
+		}
+	}
+
+	for _, element := range before.order {
+		if _, exists := after.elements[element]; !exists && !before.elements[element].IsDeleted {
+			diff.Removed = append(diff.Removed, element)
+		}
+	}
+
+	return diff, nil
+}
+
+type sceneIndex struct {
+	order    []string
+	elements map[string]sceneElementVersion
+}
+
+func sceneVersions(content string) (sceneIndex, error) {
+	index := sceneIndex{elements: make(map[string]sceneElementVersion)}
+	if content == "" {
+		return index, nil
+	}
+
+	var scene struct {
+		Elements []sceneElementVersion `json:"elements"`
+	}
+	if err := json.Unmarshal([]byte(content), &scene); err != nil {
+		return index, err
+	}
+
+	for _, element := range scene.Elements {
+		if _, seen := index.elements[element.ID]; !seen {
+			index.order = append(index.order, element.ID)
+		}
+		index.elements[element.ID] = element
+	}
+	return index, nil
+}
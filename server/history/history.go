@@ -0,0 +1,202 @@
+package history
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// where a revision came from
+const (
+	SourceSave      = "save"
+	SourceAutosave  = "autosave"
+	SourceRestore   = "restore"
+	SourceShareLink = "share-link"
+)
+
+// Revision is an immutable copy of a document or drawing at one point in time
+type Revision struct {
+	ID           interface{} `json:"id" bson:"_id,omitempty"`
+	ResourceType string      `json:"resourceType" bson:"resourceType"` // "document" or "drawing"
+	ResourceID   string      `json:"resourceId" bson:"resourceId"`
+	Number       int         `json:"number" bson:"number"` // 1, 2, 3... per resource
+	Content      string      `json:"content,omitempty" bson:"content"`
+	Author       string      `json:"author" bson:"author"`          // user id, guest id or share link
+	AuthorName   string      `json:"authorName,omitempty" bson:"-"` // filled in by NameAuthors when read
+	Source       string      `json:"source" bson:"source"`
+	Size         int         `json:"size" bson:"size"` // content length in bytes
+	CreatedAt    time.Time   `json:"createdAt" bson:"createdAt"`
+}
+
+// saves racing for the same number retry this many times
+const maxRecordAttempts = 5
+
+var revisionsCollection *mongo.Collection
+
+// SetRevisionsCollection sets the revisions collection
+func SetRevisionsCollection(collection *mongo.Collection) {
+	revisionsCollection = collection
+}
+
+// Record stores content as the next revision of a resource. nothing is stored
+// when it matches the latest revision, so repeated saves don't pile up.
+// concurrent saves can't share a number, the unique index makes the loser
+// retry with the next one
+func Record(ctx context.Context, resourceType, resourceID, content, author, source string) (*Revision, error) {
+	if revisionsCollection == nil {
+		return nil, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		latest, err := Latest(ctx, resourceType, resourceID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+
+		number := 1
+		if latest != nil {
+			if latest.Content == content {
+				return latest, nil
+			}
+			number = latest.Number + 1
+		}
+
+		revision := Revision{
+			ResourceType: resourceType,
+			ResourceID:   resourceID,
+			Number:       number,
+			Content:      content,
+			Author:       author,
+			Source:       source,
+			Size:         len(content),
+			CreatedAt:    time.Now(),
+		}
+
+		result, err := revisionsCollection.InsertOne(ctx, revision)
+		if mongo.IsDuplicateKeyError(err) && attempt < maxRecordAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		revision.ID = result.InsertedID
+
+		return &revision, nil
+	}
+}
+
+// EnsureIndexes creates the index revision lookups use, unique so two saves
+// can never get the same number
+func EnsureIndexes(ctx context.Context) error {
+	_, err := revisionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "resourceType", Value: 1}, {Key: "resourceId", Value: 1}, {Key: "number", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// Latest returns the newest revision of a resource
+func Latest(ctx context.Context, resourceType, resourceID string) (*Revision, error) {
+	var revision Revision
+	opts := options.FindOne().SetSort(bson.D{{Key: "number", Value: -1}})
+
+	err := revisionsCollection.FindOne(ctx, bson.M{
+		"resourceType": resourceType,
+		"resourceId":   resourceID,
+	}, opts).Decode(&revision)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// List returns every revision of a resource, newest first, without content
+func List(ctx context.Context, resourceType, resourceID string) ([]Revision, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "number", Value: -1}}).
+		SetProjection(bson.M{"content": 0})
+
+	cursor, err := revisionsCollection.Find(ctx, bson.M{
+		"resourceType": resourceType,
+		"resourceId":   resourceID,
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var revisions []Revision
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+	if revisions == nil {
+		revisions = []Revision{}
+	}
+	return revisions, nil
+}
+
+// Get returns one revision with its content
+func Get(ctx context.Context, resourceType, resourceID string, number int) (*Revision, error) {
+	var revision Revision
+	err := revisionsCollection.FindOne(ctx, bson.M{
+		"resourceType": resourceType,
+		"resourceId":   resourceID,
+		"number":       number,
+	}).Decode(&revision)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// Between returns revisions after number `after` up to and including `upTo`,
+// oldest first. when there are more than limit, only the last one is returned
+// and truncated is true
+func Between(ctx context.Context, resourceType, resourceID string, after, upTo int, limit int) ([]Revision, bool, error) {
+	filter := bson.M{
+		"resourceType": resourceType,
+		"resourceId":   resourceID,
+		"number":       bson.M{"$gt": after, "$lte": upTo},
+	}
+
+	count, err := revisionsCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if int(count) > limit {
+		last, err := Get(ctx, resourceType, resourceID, upTo)
+		if err != nil {
+			return nil, false, err
+		}
+		return []Revision{*last}, true, nil
+	}
+
+	cursor, err := revisionsCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "number", Value: 1}}))
+	if err != nil {
+		return nil, false, err
+	}
+	defer cursor.Close(ctx)
+
+	var revisions []Revision
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, false, err
+	}
+	return revisions, false, nil
+}
+
+// Delete removes every revision of the given resources, used when they are deleted for good
+func Delete(ctx context.Context, resourceType string, resourceIDs []string) error {
+	if revisionsCollection == nil || len(resourceIDs) == 0 {
+		return nil
+	}
+
+	_, err := revisionsCollection.DeleteMany(ctx, bson.M{
+		"resourceType": resourceType,
+		"resourceId":   bson.M{"$in": resourceIDs},
+	})
+	return err
+}
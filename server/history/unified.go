@@ -0,0 +1,117 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lines of unchanged text shown around each hunk
+const DefaultContextLines = 3
+
+// HunkLine is one line of a unified diff hunk
+type HunkLine struct {
+	Type string `json:"type"` // ChangeEqual, ChangeInsert or ChangeDelete
+	Text string `json:"text"`
+}
+
+// Hunk is a group of nearby line changes with surrounding context
+type Hunk struct {
+	FromStart int        `json:"fromStart"`
+	FromLines int        `json:"fromLines"`
+	ToStart   int        `json:"toStart"`
+	ToLines   int        `json:"toLines"`
+	Lines     []HunkLine `json:"lines"`
+}
+
+// UnifiedHunks groups a line diff into hunks the way diff -u does
+func UnifiedHunks(from, to string, context int) []Hunk {
+	a, b := splitLines(from), splitLines(to)
+	edits := diffEdits(a, b)
+	hunks := []Hunk{}
+
+	// lines of each side consumed before edit k
+	fromBefore := make([]int, len(edits)+1)
+	toBefore := make([]int, len(edits)+1)
+	for k, edit := range edits {
+		fromBefore[k+1], toBefore[k+1] = fromBefore[k], toBefore[k]
+		if edit.Type != ChangeInsert {
+			fromBefore[k+1]++
+		}
+		if edit.Type != ChangeDelete {
+			toBefore[k+1]++
+		}
+	}
+
+	for k := 0; k < len(edits); k++ {
+		if edits[k].Type == ChangeEqual {
+			continue
+		}
+
+		// extend while the next change is close enough to share context
+		last := k
+		for next := k + 1; next < len(edits) && next <= last+2*context+1; next++ {
+			if edits[next].Type != ChangeEqual {
+				last = next
+			}
+		}
+
+		start, end := max(0, k-context), min(len(edits), last+context+1)
+		hunk := Hunk{
+			FromStart: fromBefore[start] + 1,
+			FromLines: fromBefore[end] - fromBefore[start],
+			ToStart:   toBefore[start] + 1,
+			ToLines:   toBefore[end] - toBefore[start],
+		}
+		// an empty side points at the line before, like diff -u
+		if hunk.FromLines == 0 {
+			hunk.FromStart--
+		}
+		if hunk.ToLines == 0 {
+			hunk.ToStart--
+		}
+
+		for _, edit := range edits[start:end] {
+			line := HunkLine{Type: edit.Type}
+			if edit.Type == ChangeInsert {
+				line.Text = b[edit.To]
+			} else {
+				line.Text = a[edit.From]
+			}
+			hunk.Lines = append(hunk.Lines, line)
+		}
+
+		hunks = append(hunks, hunk)
+		k = last
+	}
+
+	return hunks
+}
+
+// FormatUnified renders hunks as unified diff text
+func FormatUnified(fromLabel, toLabel string, hunks []Hunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromLabel, toLabel)
+
+	for _, hunk := range hunks {
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", hunk.FromStart, hunk.FromLines, hunk.ToStart, hunk.ToLines)
+		for _, line := range hunk.Lines {
+			prefix := " "
+			switch line.Type {
+			case ChangeInsert:
+				prefix = "+"
+			case ChangeDelete:
+				prefix = "-"
+			}
+			out.WriteString(prefix + line.Text)
+			if !strings.HasSuffix(line.Text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+
+	return out.String()
+}
@@ -0,0 +1,471 @@
+package listing
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 100
+
+	maxTitleLength       = 200
+	maxDescriptionLength = 2000
+	maxTags              = 20
+	maxTagLength         = 32
+
+	// folderId value that lists items outside any folder
+	topLevelFolder = "root"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// fields lists can be sorted by, dates newest first and titles A to Z unless
+// order says otherwise. lastOpenedAt only lists what the caller opened
+var sortFields = map[string]bool{
+	"updatedAt":    true,
+	"createdAt":    true,
+	"title":        true,
+	"lastOpenedAt": true,
+}
+
+// Query is one page request for a list endpoint
+type Query struct {
+	Limit     int
+	SortField string
+	Ascending bool
+	Filter    bson.M // date range, tag, folder, title, starred and pinned filters
+	userID    string // whose stars, pins and opens count
+	after     *position
+}
+
+// where the previous page stopped, sent to clients as an opaque cursor
+type position struct {
+	SortField string `json:"s"`
+	Value     string `json:"v"`
+	ID        string `json:"id"`
+}
+
+// ParseQuery reads limit, cursor, sort, order, createdAfter, createdBefore,
+// updatedAfter, updatedBefore, tag, folderId, title, starred and pinned from
+// the query string for userID. dates are RFC 3339, folderId=root lists items
+// outside any folder and title matches part of the title in any case
+func ParseQuery(c *gin.Context, userID string) (Query, error) {
+	query := Query{
+		Limit:     DefaultLimit,
+		SortField: c.DefaultQuery("sort", "updatedAt"),
+		Filter:    bson.M{},
+		userID:    userID,
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		query.Limit = limit
+	}
+
+	if !sortFields[query.SortField] {
+		return query, errors.New("sort must be updatedAt, createdAt, title or lastOpenedAt")
+	}
+	switch c.Query("order") {
+	case "":
+		query.Ascending = query.SortField == "title"
+	case "asc":
+		query.Ascending = true
+	case "desc":
+		query.Ascending = false
+	default:
+		return query, errors.New("order must be asc or desc")
+	}
+
+	for _, field := range []string{"createdAt", "updatedAt"} {
+		prefix := strings.TrimSuffix(field, "At")
+		dateRange := bson.M{}
+		for param, operator := range map[string]string{prefix + "After": "$gte", prefix + "Before": "$lt"} {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			date, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an RFC 3339 date", param)
+			}
+			dateRange[operator] = date
+		}
+		if len(dateRange) > 0 {
+			query.Filter[field] = dateRange
+		}
+	}
+
+	if tag := c.Query("tag"); tag != "" {
+		query.Filter["tags"] = strings.ToLower(strings.TrimSpace(tag))
+	}
+
+	switch folderID := c.Query("folderId"); folderID {
+	case "":
+	case topLevelFolder:
+		query.Filter["folderId"] = bson.M{"$exists": false}
+	default:
+		query.Filter["folderId"] = folderID
+	}
+
+	if title := strings.TrimSpace(c.Query("title")); title != "" {
+		query.Filter["title"] = bson.M{"$regex": regexp.QuoteMeta(title), "$options": "i"}
+	}
+
+	for param, field := range map[string]string{"starred": "starredBy", "pinned": "pinnedBy"} {
+		switch c.Query(param) {
+		case "":
+		case "true":
+			query.Filter[field] = userID
+		case "false":
+			query.Filter[field] = bson.M{"$ne": userID}
+		default:
+			return query, fmt.Errorf("%s must be true or false", param)
+		}
+	}
+
+	if query.SortField == "lastOpenedAt" {
+		query.Filter[query.sortKey()] = bson.M{"$exists": true}
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		after, err := decodeCursor(value)
+		if err != nil || after.SortField != query.SortField {
+			return query, ErrInvalidCursor
+		}
+		query.after = after
+	}
+
+	return query, nil
+}
+
+// the field sorted on, per user fields are keyed by user id
+func (query Query) sortKey() string {
+	if query.SortField == "lastOpenedAt" {
+		return "lastOpenedAt." + query.userID
+	}
+	return query.SortField
+}
+
+// Find returns one page of the records matching filter, the cursor for the
+// next page ("" on the last one) and how many records match in total
+func Find[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, query Query) ([]T, string, int64, error) {
+	conditions := bson.A{filter}
+	if len(query.Filter) > 0 {
+		conditions = append(conditions, query.Filter)
+	}
+
+	total, err := collection.CountDocuments(ctx, bson.M{"$and": conditions})
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	if query.after != nil {
+		after, err := query.after.filter(query)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		conditions = append(conditions, after)
+	}
+
+	direction := -1
+	if query.Ascending {
+		direction = 1
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"$and": conditions},
+		options.Find().
+			SetSort(bson.D{{Key: query.sortKey(), Value: direction}, {Key: "_id", Value: direction}}).
+			SetLimit(int64(query.Limit+1)),
+	)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var raws []bson.Raw
+	if err := cursor.All(ctx, &raws); err != nil {
+		return nil, "", 0, err
+	}
+
+	// the extra record only tells whether there is another page
+	more := len(raws) > query.Limit
+	if more {
+		raws = raws[:query.Limit]
+	}
+
+	results := make([]T, len(raws))
+	for i, raw := range raws {
+		if err := bson.Unmarshal(raw, &results[i]); err != nil {
+			return nil, "", 0, err
+		}
+	}
+
+	next := ""
+	if more {
+		next, err = encodeCursor(raws[len(raws)-1], query)
+		if err != nil {
+			return nil, "", 0, err
+		}
+	}
+	return results, next, total, nil
+}
+
+func encodeCursor(raw bson.Raw, query Query) (string, error) {
+	id, ok := raw.Lookup("_id").ObjectIDOK()
+	if !ok {
+		return "", ErrInvalidCursor
+	}
+
+	after := position{SortField: query.SortField, ID: id.Hex()}
+	value := raw.Lookup(strings.Split(query.sortKey(), ".")...)
+	if query.SortField == "title" {
+		after.Value, _ = value.StringValueOK()
+	} else {
+		date, ok := value.DateTimeOK()
+		if !ok {
+			return "", ErrInvalidCursor
+		}
+		after.Value = strconv.FormatInt(date, 10)
+	}
+
+	data, err := json.Marshal(after)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(value string) (*position, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var after position
+	if err := json.Unmarshal(data, &after); err != nil {
+		return nil, err
+	}
+	return &after, nil
+}
+
+// matches records that sort after the position, ties broken by _id
+func (after *position) filter(query Query) (bson.M, error) {
+	id, err := bson.ObjectIDFromHex(after.ID)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var value interface{} = after.Value
+	if query.SortField != "title" {
+		millis, err := strconv.ParseInt(after.Value, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		value = bson.NewDateTimeFromTime(time.UnixMilli(millis))
+	}
+
+	operator := "$lt"
+	if query.Ascending {
+		operator = "$gt"
+	}
+	sortKey := query.sortKey()
+	return bson.M{"$or": bson.A{
+		bson.M{sortKey: bson.M{operator: value}},
+		bson.M{sortKey: value, "_id": bson.M{operator: id}},
+	}}, nil
+}
+
+// NormalizeTitle trims a title and checks its length
+func NormalizeTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if len(title) > maxTitleLength {
+		return "", fmt.Errorf("title can be at most %d characters", maxTitleLength)
+	}
+	return title, nil
+}
+
+// NormalizeDescription trims a description and checks its length
+func NormalizeDescription(description string) (string, error) {
+	description = strings.TrimSpace(description)
+	if len(description) > maxDescriptionLength {
+		return "", fmt.Errorf("description can be at most %d characters", maxDescriptionLength)
+	}
+	return description, nil
+}
+
+// MetadataRequest is the metadata of a document or drawing that can change
+// without rewriting its content. fields left out are not changed, starred and
+// pinned only apply to the caller
+type MetadataRequest struct {
+	Title       *string  `json:"title"`
+	Description *string  `json:"description"`
+	Tags        []string `json:"tags"`
+	Starred     *bool    `json:"starred"`
+	Pinned      *bool    `json:"pinned"`
+}
+
+// Shared reports whether the request changes metadata everyone sees, which needs edit access
+func (req MetadataRequest) Shared() bool {
+	return req.Title != nil || req.Description != nil || req.Tags != nil
+}
+
+// Update validates the request and returns the update to apply for userID,
+// changes to shared metadata bump updatedAt and stars and pins don't
+func (req MetadataRequest) Update(userID string) (bson.M, error) {
+	set := bson.M{}
+	if req.Title != nil {
+		title, err := NormalizeTitle(*req.Title)
+		if err != nil {
+			return nil, err
+		}
+		set["title"] = title
+	}
+	if req.Description != nil {
+		description, err := NormalizeDescription(*req.Description)
+		if err != nil {
+			return nil, err
+		}
+		set["description"] = description
+	}
+	if req.Tags != nil {
+		tags, err := NormalizeTags(req.Tags)
+		if err != nil {
+			return nil, err
+		}
+		set["tags"] = tags
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		set["updatedAt"] = time.Now()
+		update["$set"] = set
+	}
+	addToSet, pull := bson.M{}, bson.M{}
+	for field, value := range map[string]*bool{"starredBy": req.Starred, "pinnedBy": req.Pinned} {
+		if value == nil {
+			continue
+		}
+		if *value {
+			addToSet[field] = userID
+		} else {
+			pull[field] = userID
+		}
+	}
+	if len(addToSet) > 0 {
+		update["$addToSet"] = addToSet
+	}
+	if len(pull) > 0 {
+		update["$pull"] = pull
+	}
+	return update, nil
+}
+
+// Personal holds the stars, pins and opens of every user on a document or
+// drawing, embedded inline. only the caller's own are sent to clients
+type Personal struct {
+	StarredBy    []string             `json:"-" bson:"starredBy,omitempty"`
+	PinnedBy     []string             `json:"-" bson:"pinnedBy,omitempty"`
+	OpenedAt     map[string]time.Time `json:"-" bson:"lastOpenedAt,omitempty"`
+	Starred      bool                 `json:"starred" bson:"-"`
+	Pinned       bool                 `json:"pinned" bson:"-"`
+	LastOpenedAt *time.Time           `json:"lastOpenedAt,omitempty" bson:"-"`
+}
+
+// For fills in Starred, Pinned and LastOpenedAt for userID
+func (personal *Personal) For(userID string) {
+	personal.Starred = slices.Contains(personal.StarredBy, userID)
+	personal.Pinned = slices.Contains(personal.PinnedBy, userID)
+	personal.LastOpenedAt = nil
+	if openedAt, ok := personal.OpenedAt[userID]; ok {
+		personal.LastOpenedAt = &openedAt
+	}
+}
+
+// MarkOpened records that userID opened the record matching filter, it
+// doesn't count as an update
+func MarkOpened(ctx context.Context, collection *mongo.Collection, filter bson.M, userID string) error {
+	_, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"lastOpenedAt." + userID: time.Now()}})
+	return err
+}
+
+// ForgetUser drops a deleted user's stars, pins and opens
+func ForgetUser(ctx context.Context, collection *mongo.Collection, userID string) error {
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"starredBy": userID},
+			bson.M{"pinnedBy": userID},
+			bson.M{"lastOpenedAt." + userID: bson.M{"$exists": true}},
+		}},
+		bson.M{
+			"$pull":  bson.M{"starredBy": userID, "pinnedBy": userID},
+			"$unset": bson.M{"lastOpenedAt." + userID: ""},
+		},
+	)
+	return err
+}
+
+// NormalizeTags lowercases, trims and dedupes tags, so tag filters match regardless of case
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags can be at most %d characters", maxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	return normalized, nil
+}
+
+// Indexes are the indexes list queries on documents or drawings rely on,
+// idField is docId or drawingId
+func Indexes(idField string) []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: idField, Value: 1}}},
+		// one per branch of access.Filter, each with the sort fields
+		{Keys: bson.D{{Key: "ownerId", Value: 1}, {Key: "updatedAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "ownerId", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "ownerId", Value: 1}, {Key: "title", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "collaborators.userId", Value: 1}, {Key: "updatedAt", Value: -1}}},
+		{Keys: bson.D{{Key: "collaborators.email", Value: 1}}},
+		{Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "updatedAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "folderId", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "starredBy", Value: 1}}},
+		{Keys: bson.D{{Key: "pinnedBy", Value: 1}}},
+	}
+}
+
+// BackfillTitles gives records saved before titles existed an empty one, so
+// sorting and paging by title treat them like any other untitled record
+func BackfillTitles(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"title": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"title": ""}},
+	)
+	return err
+}
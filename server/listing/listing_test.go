@@ -0,0 +1,235 @@
+package listing
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func parse(t *testing.T, rawQuery string) (Query, error) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/?"+rawQuery, nil)
+	return ParseQuery(c, "user1")
+}
+
+func record(t *testing.T, doc bson.M) bson.Raw {
+	t.Helper()
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := bson.NewObjectID()
+	updated := time.Date(2025, 3, 4, 5, 6, 7, 8_000_000, time.UTC)
+	opened := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	raw := record(t, bson.M{
+		"_id":          id,
+		"title":        "Plans",
+		"updatedAt":    updated,
+		"createdAt":    updated.Add(-time.Hour),
+		"lastOpenedAt": bson.M{"user1": opened, "user2": updated},
+	})
+
+	tests := []struct {
+		name      string
+		rawQuery  string
+		wantKey   string
+		wantValue interface{}
+		operator  string
+	}{
+		{"updatedAt, newest first", "sort=updatedAt", "updatedAt", bson.NewDateTimeFromTime(updated), "$lt"},
+		{"createdAt ascending", "sort=createdAt&order=asc", "createdAt", bson.NewDateTimeFromTime(updated.Add(-time.Hour)), "$gt"},
+		{"title, A to Z", "sort=title", "title", "Plans", "$gt"},
+		{"title descending", "sort=title&order=desc", "title", "Plans", "$lt"},
+		{"lastOpenedAt uses the caller's open", "sort=lastOpenedAt", "lastOpenedAt.user1", bson.NewDateTimeFromTime(opened), "$lt"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := parse(t, test.rawQuery)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cursor, err := encodeCursor(raw, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			query, err = parse(t, test.rawQuery+"&cursor="+cursor)
+			if err != nil {
+				t.Fatalf("cursor %q was rejected: %v", cursor, err)
+			}
+			filter, err := query.after.filter(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := bson.M{"$or": bson.A{
+				bson.M{test.wantKey: bson.M{test.operator: test.wantValue}},
+				bson.M{test.wantKey: test.wantValue, "_id": bson.M{test.operator: id}},
+			}}
+			if !reflect.DeepEqual(filter, want) {
+				t.Errorf("filter = %v, want %v", filter, want)
+			}
+		})
+	}
+}
+
+func TestCursorErrors(t *testing.T) {
+	id := bson.NewObjectID()
+	query, err := parse(t, "sort=updatedAt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := encodeCursor(record(t, bson.M{"_id": id, "updatedAt": time.Now()}), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := encodeCursor(record(t, bson.M{"_id": "not an object id", "updatedAt": time.Now()}), query); err != ErrInvalidCursor {
+		t.Errorf("string _id: err = %v, want ErrInvalidCursor", err)
+	}
+	if _, err := encodeCursor(record(t, bson.M{"_id": id}), query); err != ErrInvalidCursor {
+		t.Errorf("missing sort field: err = %v, want ErrInvalidCursor", err)
+	}
+
+	tests := []struct {
+		name     string
+		rawQuery string
+	}{
+		{"cursor from another sort", "sort=createdAt&cursor=" + cursor},
+		{"not base64", "cursor=%25%25%25"},
+		{"not json", "cursor=bm9wZQ"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parse(t, test.rawQuery); err != ErrInvalidCursor {
+				t.Errorf("err = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+
+	for name, after := range map[string]*position{
+		"bad id":   {SortField: "updatedAt", Value: "1", ID: "nope"},
+		"bad date": {SortField: "updatedAt", Value: "yesterday", ID: id.Hex()},
+	} {
+		if _, err := after.filter(query); err != ErrInvalidCursor {
+			t.Errorf("%s: err = %v, want ErrInvalidCursor", name, err)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name          string
+		rawQuery      string
+		wantErr       bool
+		wantSort      string
+		wantAscending bool
+		wantFilter    bson.M
+	}{
+		{"defaults", "", false, "updatedAt", false, bson.M{}},
+		{"titles default to A to Z", "sort=title", false, "title", true, bson.M{}},
+		{"explicit order", "sort=createdAt&order=asc", false, "createdAt", true, bson.M{}},
+		{"date range", "updatedAfter=2025-01-01T00:00:00Z&updatedBefore=2025-02-01T00:00:00Z", false, "updatedAt", false, bson.M{
+			"updatedAt": bson.M{"$gte": time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), "$lt": time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		}},
+		{"tag is lowercased", "tag=%20Work%20", false, "updatedAt", false, bson.M{"tags": "work"}},
+		{"top level folder", "folderId=root", false, "updatedAt", false, bson.M{"folderId": bson.M{"$exists": false}}},
+		{"title is quoted", "title=a.b", false, "updatedAt", false, bson.M{"title": bson.M{"$regex": `a\.b`, "$options": "i"}}},
+		{"starred and not pinned", "starred=true&pinned=false", false, "updatedAt", false, bson.M{"starredBy": "user1", "pinnedBy": bson.M{"$ne": "user1"}}},
+		{"last opened only lists opened records", "sort=lastOpenedAt", false, "lastOpenedAt", false, bson.M{"lastOpenedAt.user1": bson.M{"$exists": true}}},
+		{"limit too high", "limit=101", true, "", false, nil},
+		{"limit not a number", "limit=ten", true, "", false, nil},
+		{"unknown sort", "sort=ownerId", true, "", false, nil},
+		{"unknown order", "order=up", true, "", false, nil},
+		{"bad date", "createdAfter=yesterday", true, "", false, nil},
+		{"bad starred", "starred=yes", true, "", false, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := parse(t, test.rawQuery)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query.SortField != test.wantSort || query.Ascending != test.wantAscending {
+				t.Errorf("sort = %s ascending %v, want %s ascending %v", query.SortField, query.Ascending, test.wantSort, test.wantAscending)
+			}
+			if !reflect.DeepEqual(query.Filter, test.wantFilter) {
+				t.Errorf("filter = %v, want %v", query.Filter, test.wantFilter)
+			}
+		})
+	}
+}
+
+func TestMetadataUpdate(t *testing.T) {
+	title, long := "  Notes ", string(make([]byte, maxDescriptionLength+1))
+	yes, no := true, false
+
+	tests := []struct {
+		name       string
+		req        MetadataRequest
+		wantShared bool
+		wantSet    bson.M
+		wantOther  bson.M
+		wantErr    bool
+	}{
+		{"title and tags", MetadataRequest{Title: &title, Tags: []string{"B", "b", " a "}}, true, bson.M{"title": "Notes", "tags": []string{"b", "a"}}, bson.M{}, false},
+		{"star only", MetadataRequest{Starred: &yes}, false, nil, bson.M{"$addToSet": bson.M{"starredBy": "user1"}}, false},
+		{"unpin and star", MetadataRequest{Starred: &yes, Pinned: &no}, false, nil, bson.M{"$addToSet": bson.M{"starredBy": "user1"}, "$pull": bson.M{"pinnedBy": "user1"}}, false},
+		{"empty tags clear them", MetadataRequest{Tags: []string{}}, true, bson.M{"tags": []string{}}, bson.M{}, false},
+		{"description too long", MetadataRequest{Description: &long}, true, nil, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.req.Shared() != test.wantShared {
+				t.Errorf("Shared() = %v, want %v", test.req.Shared(), test.wantShared)
+			}
+			update, err := test.req.Update("user1")
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			set, _ := update["$set"].(bson.M)
+			if test.wantSet != nil {
+				// shared changes bump updatedAt, stars and pins don't
+				if _, ok := set["updatedAt"].(time.Time); !ok {
+					t.Errorf("$set = %v, missing updatedAt", set)
+				}
+				delete(set, "updatedAt")
+				if !reflect.DeepEqual(set, test.wantSet) {
+					t.Errorf("$set = %v, want %v", set, test.wantSet)
+				}
+			} else if set != nil {
+				t.Errorf("$set = %v, want none", set)
+			}
+
+			delete(update, "$set")
+			if !reflect.DeepEqual(update, test.wantOther) {
+				t.Errorf("update = %v, want %v", update, test.wantOther)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers emails, swap it for a MemorySender in tests
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// ErrNotConfigured is returned by DisabledSender, and by FromEnv when there is no way to send
+var ErrNotConfigured = errors.New("email is not configured")
+
+// FromEnv returns the sender MAIL_DRIVER asks for: "smtp" (the default when
+// SMTP_HOST is set) or "log" for local development. without either it returns
+// a DisabledSender and ErrNotConfigured. SMTP_PORT defaults to 587,
+// SMTP_USERNAME and SMTP_PASSWORD are optional and SMTP_FROM defaults to
+// no-reply@collabify.local
+func FromEnv() (Sender, error) {
+	host := os.Getenv("SMTP_HOST")
+
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "log":
+		return LogSender{}, nil
+	case "":
+		if host == "" {
+			return DisabledSender{}, ErrNotConfigured
+		}
+	case "smtp":
+		if host == "" {
+			return DisabledSender{}, errors.New("MAIL_DRIVER=smtp needs SMTP_HOST")
+		}
+	default:
+		return DisabledSender{}, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+
+	sender := &SMTPSender{
+		Host:     host,
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if sender.Port == "" {
+		sender.Port = "587"
+	}
+	if sender.From == "" {
+		sender.From = "no-reply@collabify.local"
+	}
+	return sender, nil
+}
+
+// SMTPSender sends through an SMTP server, using STARTTLS when the server offers it
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(ctx context.Context, message Message) error {
+	if strings.ContainsAny(message.To, "\r\n") || strings.ContainsAny(message.Subject, "\r\n") {
+		return fmt.Errorf("invalid header value")
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	body := strings.Join([]string{
+		"From: " + s.From,
+		"To: " + message.To,
+		"Subject: " + message.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		message.Body,
+	}, "\r\n")
+
+	// smtp.SendMail has no context, run it so a slow server can't block past the deadline
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, s.From, []string{message.To}, []byte(body))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DisabledSender refuses to send, so links never end up anywhere but an inbox
+type DisabledSender struct{}
+
+func (DisabledSender) Send(ctx context.Context, message Message) error {
+	return ErrNotConfigured
+}
+
+// LogSender writes emails to the server log, links and all. only for local
+// development, turned on with MAIL_DRIVER=log
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, message Message) error {
+	log.Printf("email to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// MemorySender keeps sent emails in memory, for tests
+type MemorySender struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+func (m *MemorySender) Send(ctx context.Context, message Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Messages returns a copy of everything sent so far
+func (m *MemorySender) Messages() []Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]Message(nil), m.messages...)
+}
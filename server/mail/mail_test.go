@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		driver  string
+		host    string
+		want    Sender
+		wantErr bool
+	}{
+		{"nothing configured", "", "", DisabledSender{}, true},
+		{"smtp host", "", "smtp.example.com", &SMTPSender{Host: "smtp.example.com", Port: "587", From: "no-reply@collabify.local"}, false},
+		{"explicit smtp", "smtp", "smtp.example.com", &SMTPSender{Host: "smtp.example.com", Port: "587", From: "no-reply@collabify.local"}, false},
+		{"smtp without a host", "smtp", "", DisabledSender{}, true},
+		{"log only when asked", "log", "", LogSender{}, false},
+		{"log wins over smtp", "log", "smtp.example.com", LogSender{}, false},
+		{"unknown driver", "carrier-pigeon", "smtp.example.com", DisabledSender{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("MAIL_DRIVER", test.driver)
+			t.Setenv("SMTP_HOST", test.host)
+			for _, name := range []string{"SMTP_PORT", "SMTP_USERNAME", "SMTP_PASSWORD", "SMTP_FROM"} {
+				t.Setenv(name, "")
+			}
+
+			sender, err := FromEnv()
+			if (err != nil) != test.wantErr {
+				t.Errorf("err = %v, want error %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(sender, test.want) {
+				t.Errorf("sender = %#v, want %#v", sender, test.want)
+			}
+		})
+	}
+
+	if err := (DisabledSender{}).Send(context.Background(), Message{To: "a@example.com"}); err != ErrNotConfigured {
+		t.Errorf("DisabledSender.Send err = %v, want ErrNotConfigured", err)
+	}
+}
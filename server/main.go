@@ -1,11 +1,21 @@
 package main
 
 import (
+	"collabify-backend/access"
 	"collabify-backend/auth"
 	"collabify-backend/docs"
 	"collabify-backend/drawings"
+	"collabify-backend/folders"
+	"collabify-backend/history"
+	"collabify-backend/listing"
+	"collabify-backend/search"
 	"collabify-backend/socket"
+	"collabify-backend/workspaces"
+	"context"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,8 +25,8 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "https://collabify-007.vercel.app")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Share-Token, X-Share-Password")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -36,6 +46,22 @@ func main() {
 	// get users collection and pass it to socket package
 	usersCollection := auth.GetUsersCollection()
 	socket.SetUsersCollection(usersCollection)
+	access.SetUsersCollection(usersCollection)
+
+	// close live sockets when a login session is revoked
+	auth.OnSessionsRevoked(socket.DisconnectAuthSessions)
+
+	// documents and drawings follow email changes and deleted accounts
+	auth.OnAccountChanges(auth.AccountHooks{EmailChanged: docs.RenameUser, EmailVerified: docs.ClaimInvites, AccountDeleted: docs.RemoveUser})
+	auth.OnAccountChanges(auth.AccountHooks{EmailChanged: drawings.RenameUser, EmailVerified: drawings.ClaimInvites, AccountDeleted: drawings.RemoveUser})
+	auth.OnAccountChanges(auth.AccountHooks{EmailChanged: workspaces.RenameUser, AccountDeleted: workspaces.RemoveUser})
+	auth.OnAccountChanges(auth.AccountHooks{AccountDeleted: folders.RemoveUser})
+
+	// workspace roles cascade to the documents and drawings inside
+	access.SetWorkspaceRoles(workspaces.RolesFor)
+	workspaces.OnContentChanges(workspaces.ContentHooks{MemberChanged: docs.WorkspaceMemberChanged, WorkspaceDeleted: docs.WorkspaceDeleted})
+	workspaces.OnContentChanges(workspaces.ContentHooks{MemberChanged: drawings.WorkspaceMemberChanged, WorkspaceDeleted: drawings.WorkspaceDeleted})
+	workspaces.OnContentChanges(workspaces.ContentHooks{WorkspaceDeleted: folders.WorkspaceDeleted})
 
 	// 	setup collections and setup docs/draws collection
 	client := usersCollection.Database().Client()
@@ -43,16 +69,85 @@ func main() {
 	drawingsCollection := client.Database("collabify").Collection("drawings")
 	docs.SetDocsCollection(docsCollection)
 	drawings.SetDrawingsCollection(drawingsCollection)
+	socket.SetDocsCollection(docsCollection)
+	socket.SetDrawingsCollection(drawingsCollection)
+	access.SetShareLinksCollection(client.Database("collabify").Collection("share_links"))
+	// wrong link passwords count like wrong login passwords
+	access.SetShareLinkThrottle(access.ShareLinkThrottle{LockedFor: auth.ShareLinkLockout, RecordFailure: auth.RecordShareLinkFailure})
+	history.SetRevisionsCollection(client.Database("collabify").Collection("revisions"))
+	workspaces.SetWorkspacesCollection(client.Database("collabify").Collection("workspaces"))
+	folders.SetFoldersCollection(client.Database("collabify").Collection("folders"))
+	folders.SetDocsCollection(docsCollection)
+	folders.SetDrawingsCollection(drawingsCollection)
+	search.SetDocsCollection(docsCollection)
+	search.SetDrawingsCollection(drawingsCollection)
+
+	// records saved while everything was keyed by email get user ids, safe to rerun
+	if err := auth.MigrateUserIDs(); err != nil {
+		log.Println("Failed to migrate sessions and tokens to user ids:", err)
+	}
+	if err := access.MigrateOwnerIDs(context.Background(), docsCollection); err != nil {
+		log.Println("Failed to migrate documents to user ids:", err)
+	}
+	if err := access.MigrateOwnerIDs(context.Background(), drawingsCollection); err != nil {
+		log.Println("Failed to migrate drawings to user ids:", err)
+	}
+	if err := history.MigrateAuthors(context.Background()); err != nil {
+		log.Println("Failed to migrate revision authors to user ids:", err)
+	}
+	if err := listing.BackfillTitles(context.Background(), docsCollection); err != nil {
+		log.Println("Failed to backfill document titles:", err)
+	}
+	if err := listing.BackfillTitles(context.Background(), drawingsCollection); err != nil {
+		log.Println("Failed to backfill drawing titles:", err)
+	}
+
+	// indexes the list and lookup queries need, creating existing ones is a no-op
+	indexes := map[string]func(context.Context) error{
+		"documents":  docs.EnsureIndexes,
+		"drawings":   drawings.EnsureIndexes,
+		"workspaces": workspaces.EnsureIndexes,
+		"folders":    folders.EnsureIndexes,
+		"auth":       auth.EnsureIndexes,
+		"revisions":  history.EnsureIndexes,
+	}
+	for name, ensure := range indexes {
+		if err := ensure(context.Background()); err != nil {
+			log.Printf("Failed to create %s indexes: %v", name, err)
+		}
+	}
+
+	// SEARCH_INDEX=memory keeps the search index in memory, for MongoDB without text search
+	if os.Getenv("SEARCH_INDEX") == "memory" {
+		search.UseIndex(search.NewMemoryIndex())
+	}
+	if err := search.Init(context.Background()); err != nil {
+		log.Println("Failed to prepare search:", err)
+	}
+
+	go purgeTrash()
 
 	r := gin.Default()
 
+	// client IPs feed the login throttle, so X-Forwarded-For is only believed
+	// from the proxies in TRUSTED_PROXIES (comma separated IPs or CIDRs)
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
+
 	r.Use(CORSMiddleware())
 
 	// r.LoadHTMLFiles("chat.html")
 
 	// WebSocket route 
 	r.GET("/ws", func(c *gin.Context) {
-		socket.HandleWBConnections(c.Writer, c.Request)
+		socket.HandleWBConnections(c.Writer, c.Request, c.ClientIP())
 	})
 
 	// Chat route
@@ -61,30 +156,155 @@ func main() {
 	// 		"title": "Chat Room"})
 	// })
 
+	// public keys for verifying tokens
+	r.GET("/.well-known/jwks.json", auth.JWKS)
+
 	// auth routes
 	authGroup := r.Group("/api/auth")
 	{
 		authGroup.POST("/register", auth.Register)
 		authGroup.POST("/login", auth.Login)
+		authGroup.POST("/refresh", auth.Refresh)
+		authGroup.POST("/logout", auth.Logout)
+		authGroup.GET("/oidc/login", auth.OIDCLogin)
+		authGroup.GET("/oidc/callback", auth.OIDCCallback)
+		authGroup.POST("/verify-email", auth.VerifyEmail)
+		authGroup.POST("/verify-email/resend", auth.ResendVerification)
+		authGroup.POST("/password-reset/request", auth.RequestPasswordReset)
+		authGroup.POST("/password-reset/confirm", auth.ResetPassword)
+		authGroup.POST("/2fa/verify", auth.VerifyTwoFactorLogin)
+		authGroup.POST("/email-change/confirm", auth.ConfirmEmailChange)
+	}
+
+	// share link routes, no account needed
+	sharedGroup := r.Group("/api/shared")
+	sharedGroup.Use(access.ShareLinkMiddleware())
+	{
+		sharedGroup.GET("/documents/:docId", docs.GetSharedDocument)
+		sharedGroup.PUT("/documents/:docId", docs.SaveSharedDocument)
+		sharedGroup.GET("/drawings/:drawingId", drawings.GetSharedDrawing)
+		sharedGroup.PUT("/drawings/:drawingId", drawings.SaveSharedDrawing)
 	}
 
 	api := r.Group("/api")
 	api.Use(auth.AuthMiddleware())
 	{
-		api.GET("/profile", auth.GetProfile)
+		api.GET("/profile", auth.RequireScope(auth.ScopeProfileRead), auth.GetProfile)
+		api.PATCH("/profile", auth.UpdateProfile)
+		api.DELETE("/profile", auth.DeleteAccount)
+		api.POST("/profile/password", auth.ChangePassword)
+		api.POST("/profile/email", auth.RequestEmailChange)
+
+		// personal access tokens
+		api.GET("/auth/tokens", auth.GetAPITokens)
+		api.POST("/auth/tokens", auth.CreateAPIToken)
+		api.DELETE("/auth/tokens/:tokenId", auth.RevokeAPIToken)
+
+		// two-factor setup
+		api.POST("/auth/2fa/enroll", auth.EnrollTwoFactor)
+		api.POST("/auth/2fa/enable", auth.EnableTwoFactor)
+		api.POST("/auth/2fa/disable", auth.DisableTwoFactor)
+		api.POST("/auth/2fa/recovery-codes", auth.RegenerateRecoveryCodes)
 		
 		// docs routes
-		api.POST("/documents", docs.SaveDocument)
-		api.GET("/documents", docs.GetUserDocuments)
-		api.GET("/documents/:docId", docs.GetDocument)
-		api.DELETE("/documents/:docId", docs.DeleteDocument)
+		api.POST("/documents", auth.RequireScope(auth.ScopeDocsWrite), docs.SaveDocument)
+		api.GET("/documents", auth.RequireScope(auth.ScopeDocsRead), docs.GetUserDocuments)
+		api.GET("/documents/:docId", auth.RequireScope(auth.ScopeDocsRead), docs.GetDocument)
+		api.PATCH("/documents/:docId", auth.RequireScope(auth.ScopeDocsWrite), docs.UpdateDocumentMetadata)
+		api.DELETE("/documents/:docId", auth.RequireScope(auth.ScopeDocsWrite), docs.DeleteDocument)
+		api.GET("/documents/:docId/collaborators", auth.RequireScope(auth.ScopeDocsRead), docs.GetCollaborators)
+		api.POST("/documents/:docId/collaborators", auth.RequireScope(auth.ScopeDocsWrite), docs.ShareDocument)
+		api.DELETE("/documents/:docId/collaborators/:email", auth.RequireScope(auth.ScopeDocsWrite), docs.RevokeDocumentAccess)
+		api.GET("/documents/:docId/links", auth.RequireScope(auth.ScopeDocsRead), docs.GetDocumentLinks)
+		api.POST("/documents/:docId/links", auth.RequireScope(auth.ScopeDocsWrite), docs.CreateDocumentLink)
+		api.DELETE("/documents/:docId/links/:linkId", auth.RequireScope(auth.ScopeDocsWrite), docs.RevokeDocumentLink)
+		api.GET("/documents/:docId/revisions", auth.RequireScope(auth.ScopeDocsRead), docs.ListDocumentRevisions)
+		api.GET("/documents/:docId/revisions/:revision", auth.RequireScope(auth.ScopeDocsRead), docs.GetDocumentRevision)
+		api.POST("/documents/:docId/revisions/:revision/restore", auth.RequireScope(auth.ScopeDocsWrite), docs.RestoreDocumentRevision)
+		api.GET("/documents/:docId/diff", auth.RequireScope(auth.ScopeDocsRead), docs.DiffDocumentRevisions)
 
 		// drawings routes
-		api.POST("/drawings", drawings.SaveDrawing)
-		api.GET("/drawings", drawings.GetUserDrawings)
-		api.GET("/drawings/:drawingId", drawings.GetDrawing)
-		api.DELETE("/drawings/:drawingId", drawings.DeleteDrawing)
+		api.POST("/drawings", auth.RequireScope(auth.ScopeDrawingsWrite), drawings.SaveDrawing)
+		api.GET("/drawings", auth.RequireScope(auth.ScopeDrawingsRead), drawings.GetUserDrawings)
+		api.GET("/drawings/:drawingId", auth.RequireScope(auth.ScopeDrawingsRead), drawings.GetDrawing)
+		api.PATCH("/drawings/:drawingId", auth.RequireScope(auth.ScopeDrawingsWrite), drawings.UpdateDrawingMetadata)
+		api.DELETE("/drawings/:drawingId", auth.RequireScope(auth.ScopeDrawingsWrite), drawings.DeleteDrawing)
+		api.GET("/drawings/:drawingId/collaborators", auth.RequireScope(auth.ScopeDrawingsRead), drawings.GetCollaborators)
+		api.POST("/drawings/:drawingId/collaborators", auth.RequireScope(auth.ScopeDrawingsWrite), drawings.ShareDrawing)
+		api.DELETE("/drawings/:drawingId/collaborators/:email", auth.RequireScope(auth.ScopeDrawingsWrite), drawings.RevokeDrawingAccess)
+		api.GET("/drawings/:drawingId/links", auth.RequireScope(auth.ScopeDrawingsRead), drawings.GetDrawingLinks)
+		api.POST("/drawings/:drawingId/links", auth.RequireScope(auth.ScopeDrawingsWrite), drawings.CreateDrawingLink)
+		api.DELETE("/drawings/:drawingId/links/:linkId", auth.RequireScope(auth.ScopeDrawingsWrite), drawings.RevokeDrawingLink)
+		api.GET("/drawings/:drawingId/revisions", auth.RequireScope(auth.ScopeDrawingsRead), drawings.ListDrawingRevisions)
+		api.GET("/drawings/:drawingId/revisions/:revision", auth.RequireScope(auth.ScopeDrawingsRead), drawings.GetDrawingRevision)
+		api.POST("/drawings/:drawingId/revisions/:revision/restore", auth.RequireScope(auth.ScopeDrawingsWrite), drawings.RestoreDrawingRevision)
+		api.GET("/drawings/:drawingId/diff", auth.RequireScope(auth.ScopeDrawingsRead), drawings.DiffDrawingRevisions)
+
+		// workspace routes
+		api.POST("/workspaces", workspaces.CreateWorkspace)
+		api.GET("/workspaces", workspaces.GetUserWorkspaces)
+		api.GET("/workspaces/:workspaceId", workspaces.GetWorkspace)
+		api.PATCH("/workspaces/:workspaceId", workspaces.UpdateWorkspace)
+		api.DELETE("/workspaces/:workspaceId", workspaces.DeleteWorkspace)
+		api.POST("/workspaces/:workspaceId/members", workspaces.AddMember)
+		api.DELETE("/workspaces/:workspaceId/members/:userId", workspaces.RemoveMember)
+		api.GET("/workspaces/:workspaceId/documents", auth.RequireScope(auth.ScopeDocsRead), docs.GetWorkspaceDocuments)
+		api.GET("/workspaces/:workspaceId/drawings", auth.RequireScope(auth.ScopeDrawingsRead), drawings.GetWorkspaceDrawings)
+
+		// folder routes, shared by documents and drawings
+		api.POST("/folders", folders.CreateFolder)
+		api.GET("/folders", folders.GetFolders)
+		api.GET("/folders/:folderId", folders.GetFolder)
+		api.PATCH("/folders/:folderId", folders.UpdateFolder)
+		api.DELETE("/folders/:folderId", folders.DeleteFolder)
+		api.PUT("/documents/:docId/folder", auth.RequireScope(auth.ScopeDocsWrite), folders.MoveDocument)
+		api.PUT("/drawings/:drawingId/folder", auth.RequireScope(auth.ScopeDrawingsWrite), folders.MoveDrawing)
+
+		// trash, items stay there until TRASH_RETENTION has passed
+		api.GET("/trash/documents", auth.RequireScope(auth.ScopeDocsRead), docs.GetTrashedDocuments)
+		api.DELETE("/trash/documents", auth.RequireScope(auth.ScopeDocsWrite), docs.EmptyTrashedDocuments)
+		api.POST("/trash/documents/:docId/restore", auth.RequireScope(auth.ScopeDocsWrite), docs.RestoreDocument)
+		api.DELETE("/trash/documents/:docId", auth.RequireScope(auth.ScopeDocsWrite), docs.DeleteTrashedDocument)
+		api.GET("/trash/drawings", auth.RequireScope(auth.ScopeDrawingsRead), drawings.GetTrashedDrawings)
+		api.DELETE("/trash/drawings", auth.RequireScope(auth.ScopeDrawingsWrite), drawings.EmptyTrashedDrawings)
+		api.POST("/trash/drawings/:drawingId/restore", auth.RequireScope(auth.ScopeDrawingsWrite), drawings.RestoreDrawing)
+		api.DELETE("/trash/drawings/:drawingId", auth.RequireScope(auth.ScopeDrawingsWrite), drawings.DeleteTrashedDrawing)
+
+		// search across documents and drawings
+		api.GET("/search", search.Search)
 	}
 
 	r.Run(":8080")
 }
+
+// deletes documents and drawings for good once they have been in the trash
+// longer than TRASH_RETENTION (a Go duration, 720h by default), checking hourly
+func purgeTrash() {
+	retention := 30 * 24 * time.Hour
+	if value := os.Getenv("TRASH_RETENTION"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			retention = d
+		} else {
+			log.Printf("ignoring invalid TRASH_RETENTION %q", value)
+		}
+	}
+
+	purges := map[string]func(context.Context, time.Time) (int, error){
+		"documents": docs.PurgeTrash,
+		"drawings":  drawings.PurgeTrash,
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		cutoff := time.Now().Add(-retention)
+		for name, purge := range purges {
+			count, err := purge(context.Background(), cutoff)
+			if err != nil {
+				log.Printf("Failed to purge trashed %s: %v", name, err)
+			} else if count > 0 {
+				log.Printf("Purged %d trashed %s", count, name)
+			}
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,228 @@
+package search
+
+import (
+	"collabify-backend/access"
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// candidates are checked for access this many at a time
+	accessBatch = 100
+	// and at most this many, so a query matching everything stays cheap
+	maxCandidates = 2000
+
+	// BM25 parameters
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type entryKey struct {
+	resourceType string
+	resourceID   string
+}
+
+type memoryEntry struct {
+	terms  map[string]int // term to weighted count, title words count titleWeight times
+	length int
+}
+
+// memoryIndex is an embedded inverted index for deployments without MongoDB
+// text search. it is loaded from searchText at startup and ranks with BM25
+type memoryIndex struct {
+	mutex       sync.RWMutex
+	entries     map[entryKey]*memoryEntry
+	postings    map[string]map[entryKey]bool // term to the entries that have it
+	totalLength int
+}
+
+// NewMemoryIndex keeps the search index in memory, every server instance
+// holds the whole index so it suits small and single instance deployments
+func NewMemoryIndex() Index {
+	return &memoryIndex{
+		entries:  map[entryKey]*memoryEntry{},
+		postings: map[string]map[entryKey]bool{},
+	}
+}
+
+func (index *memoryIndex) Prepare(ctx context.Context, sources []Source) error {
+	for _, source := range sources {
+		cursor, err := source.Collection.Find(ctx, bson.M{},
+			options.Find().SetProjection(bson.M{source.IDField: 1, "title": 1, "searchText": 1}),
+		)
+		if err != nil {
+			return err
+		}
+
+		var records []record
+		if err := cursor.All(ctx, &records); err != nil {
+			return err
+		}
+		for _, found := range records {
+			index.put(entryKey{source.ResourceType, found.DocID + found.DrawingID}, found.Title, found.SearchText)
+		}
+	}
+	return nil
+}
+
+func (index *memoryIndex) put(key entryKey, title string, text string) {
+	entry := &memoryEntry{terms: map[string]int{}}
+	for _, token := range tokenize([]rune(title)) {
+		entry.terms[token.term] += titleWeight
+		entry.length += titleWeight
+	}
+	for _, token := range tokenize([]rune(text)) {
+		entry.terms[token.term]++
+		entry.length++
+	}
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.remove(key)
+	index.entries[key] = entry
+	index.totalLength += entry.length
+	for term := range entry.terms {
+		if index.postings[term] == nil {
+			index.postings[term] = map[entryKey]bool{}
+		}
+		index.postings[term][key] = true
+	}
+}
+
+// caller must hold mutex
+func (index *memoryIndex) remove(key entryKey) {
+	entry, exists := index.entries[key]
+	if !exists {
+		return
+	}
+	for term := range entry.terms {
+		delete(index.postings[term], key)
+		if len(index.postings[term]) == 0 {
+			delete(index.postings, term)
+		}
+	}
+	index.totalLength -= entry.length
+	delete(index.entries, key)
+}
+
+type candidate struct {
+	resourceID string
+	score      float64
+}
+
+// ranks every entry of resourceType matching a term, words match terms they start with
+func (index *memoryIndex) rank(resourceType string, terms []string) []candidate {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	if len(index.entries) == 0 {
+		return nil
+	}
+	averageLength := float64(index.totalLength) / float64(len(index.entries))
+
+	scores := map[string]float64{}
+	for _, term := range terms {
+		for word, keys := range index.postings {
+			if !strings.HasPrefix(word, term) {
+				continue
+			}
+			idf := math.Log(1 + (float64(len(index.entries))-float64(len(keys))+0.5)/(float64(len(keys))+0.5))
+			for key := range keys {
+				if key.resourceType != resourceType {
+					continue
+				}
+				entry := index.entries[key]
+				frequency := float64(entry.terms[word])
+				norm := bm25K1 * (1 - bm25B + bm25B*float64(entry.length)/averageLength)
+				scores[key.resourceID] += idf * frequency * (bm25K1 + 1) / (frequency + norm)
+			}
+		}
+	}
+
+	candidates := make([]candidate, 0, len(scores))
+	for resourceID, score := range scores {
+		candidates = append(candidates, candidate{resourceID, score})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].resourceID < candidates[j].resourceID
+	})
+	if len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+	return candidates
+}
+
+func (index *memoryIndex) Search(ctx context.Context, source Source, query string, user access.Principal, limit int) ([]Result, error) {
+	candidates := index.rank(source.ResourceType, Terms(query))
+
+	// the index knows nothing about access, so the best candidates are
+	// checked against the collection until there are enough
+	results := []Result{}
+	for start := 0; start < len(candidates) && len(results) < limit; start += accessBatch {
+		batch := candidates[start:min(start+accessBatch, len(candidates))]
+		resourceIDs := make(bson.A, len(batch))
+		for i, found := range batch {
+			resourceIDs[i] = found.resourceID
+		}
+
+		filter := access.Filter(user)
+		filter[source.IDField] = bson.M{"$in": resourceIDs}
+		cursor, err := source.Collection.Find(ctx, filter, options.Find().SetProjection(recordProjection))
+		if err != nil {
+			return nil, err
+		}
+		var records []record
+		if err := cursor.All(ctx, &records); err != nil {
+			return nil, err
+		}
+
+		accessible := make(map[string]record, len(records))
+		for _, found := range records {
+			accessible[found.DocID+found.DrawingID] = found
+		}
+		for _, found := range batch {
+			if allowed, ok := accessible[found.resourceID]; ok && len(results) < limit {
+				allowed.Score = found.score
+				results = append(results, allowed.result(source, user))
+			}
+		}
+	}
+	return results, nil
+}
+
+func (index *memoryIndex) Refresh(ctx context.Context, source Source, resourceID string) error {
+	var found record
+	err := source.Collection.FindOne(ctx,
+		bson.M{source.IDField: resourceID},
+		options.FindOne().SetProjection(bson.M{"title": 1, "searchText": 1}),
+	).Decode(&found)
+	if err == mongo.ErrNoDocuments {
+		return index.Forget(ctx, source, []string{resourceID})
+	}
+	if err != nil {
+		return err
+	}
+
+	index.put(entryKey{source.ResourceType, resourceID}, found.Title, found.SearchText)
+	return nil
+}
+
+func (index *memoryIndex) Forget(ctx context.Context, source Source, resourceIDs []string) error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	for _, resourceID := range resourceIDs {
+		index.remove(entryKey{source.ResourceType, resourceID})
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package search
+
+import (
+	"collabify-backend/access"
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// titles count this many times more than text when ranking
+const titleWeight = 5
+
+// mongoIndex searches with a MongoDB text index over title and searchText,
+// the collections are the index so there is nothing to keep in sync
+type mongoIndex struct{}
+
+// NewMongoIndex is the default index, it needs MongoDB text search
+func NewMongoIndex() Index {
+	return mongoIndex{}
+}
+
+func (mongoIndex) Prepare(ctx context.Context, sources []Source) error {
+	for _, source := range sources {
+		_, err := source.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "title", Value: "text"}, {Key: "searchText", Value: "text"}},
+			Options: options.Index().
+				SetName("search_text").
+				SetWeights(bson.D{{Key: "title", Value: titleWeight}, {Key: "searchText", Value: 1}}).
+				// content is in any language, and no stemming keeps matches in line with snippets
+				SetDefaultLanguage("none"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mongoIndex) Search(ctx context.Context, source Source, query string, user access.Principal, limit int) ([]Result, error) {
+	projection := bson.M{"score": bson.M{"$meta": "textScore"}}
+	for field, value := range recordProjection {
+		projection[field] = value
+	}
+
+	cursor, err := source.Collection.Find(ctx,
+		bson.M{"$and": bson.A{access.Filter(user), bson.M{"$text": bson.M{"$search": query}}}},
+		options.Find().
+			SetProjection(projection).
+			SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}}).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(records))
+	for i, found := range records {
+		results[i] = found.result(source, user)
+	}
+	return results, nil
+}
+
+func (mongoIndex) Refresh(ctx context.Context, source Source, resourceID string) error {
+	return nil
+}
+
+func (mongoIndex) Forget(ctx context.Context, source Source, resourceIDs []string) error {
+	return nil
+}
@@ -0,0 +1,245 @@
+package search
+
+import (
+	"collabify-backend/access"
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	defaultLimit   = 20
+	maxLimit       = 50
+	maxQueryLength = 200
+)
+
+// Source is a collection of searchable records, documents or drawings. every
+// record keeps its extracted text in searchText next to its content
+type Source struct {
+	ResourceType string
+	Collection   *mongo.Collection
+	IDField      string
+}
+
+// Index finds records by their title and text. the mongo index uses a text
+// index on the collections, the memory index keeps its own inverted index
+type Index interface {
+	// Prepare runs once at startup, after searchText was backfilled
+	Prepare(ctx context.Context, sources []Source) error
+	// Search returns up to limit records of source user can open, best match first
+	Search(ctx context.Context, source Source, query string, user access.Principal, limit int) ([]Result, error)
+	// Refresh picks up a record's new title or text after it was written
+	Refresh(ctx context.Context, source Source, resourceID string) error
+	// Forget drops records after they were deleted
+	Forget(ctx context.Context, source Source, resourceIDs []string) error
+}
+
+// Result is one search hit
+type Result struct {
+	Type      string      `json:"type"` // access.ResourceDocument or access.ResourceDrawing
+	ID        string      `json:"id"`
+	Title     string      `json:"title"`
+	Snippet   []Fragment  `json:"snippet"`
+	Score     float64     `json:"score"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+	Role      access.Role `json:"role"`
+	text      string
+}
+
+// the fields of a document or drawing search needs
+type record struct {
+	DocID         string                `bson:"docId,omitempty"`
+	DrawingID     string                `bson:"drawingId,omitempty"`
+	Title         string                `bson:"title"`
+	SearchText    string                `bson:"searchText"`
+	OwnerID       string                `bson:"ownerId"`
+	WorkspaceID   string                `bson:"workspaceId,omitempty"`
+	Collaborators []access.Collaborator `bson:"collaborators,omitempty"`
+	UpdatedAt     time.Time             `bson:"updatedAt"`
+	Score         float64               `bson:"score,omitempty"`
+}
+
+// only what building a Result needs, never the content
+var recordProjection = bson.M{
+	"docId": 1, "drawingId": 1, "title": 1, "searchText": 1, "ownerId": 1,
+	"workspaceId": 1, "collaborators": 1, "updatedAt": 1,
+}
+
+func (r record) result(source Source, user access.Principal) Result {
+	return Result{
+		Type:      source.ResourceType,
+		ID:        r.DocID + r.DrawingID,
+		Title:     r.Title,
+		Score:     r.Score,
+		UpdatedAt: r.UpdatedAt,
+		Role:      access.RoleFor(r.OwnerID, r.WorkspaceID, r.Collaborators, user),
+		text:      r.SearchText,
+	}
+}
+
+var (
+	sources []Source
+	index   Index = NewMongoIndex()
+)
+
+// SetDocsCollection makes documents searchable
+func SetDocsCollection(collection *mongo.Collection) {
+	sources = append(sources, Source{access.ResourceDocument, collection, "docId"})
+}
+
+// SetDrawingsCollection makes drawings searchable
+func SetDrawingsCollection(collection *mongo.Collection) {
+	sources = append(sources, Source{access.ResourceDrawing, collection, "drawingId"})
+}
+
+// UseIndex swaps the search index, call it before Init
+func UseIndex(newIndex Index) {
+	index = newIndex
+}
+
+func sourceFor(resourceType string) (Source, bool) {
+	for _, source := range sources {
+		if source.ResourceType == resourceType {
+			return source, true
+		}
+	}
+	return Source{}, false
+}
+
+// Init fills in searchText on records saved before search existed and prepares the index
+func Init(ctx context.Context) error {
+	for _, source := range sources {
+		if err := backfill(ctx, source); err != nil {
+			return err
+		}
+	}
+	return index.Prepare(ctx, sources)
+}
+
+func backfill(ctx context.Context, source Source) error {
+	cursor, err := source.Collection.Find(ctx,
+		bson.M{"searchText": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"content": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var count int
+	for cursor.Next(ctx) {
+		var pending struct {
+			ID      interface{} `bson:"_id"`
+			Content string      `bson:"content"`
+		}
+		if err := cursor.Decode(&pending); err != nil {
+			return err
+		}
+		_, err := source.Collection.UpdateOne(ctx,
+			bson.M{"_id": pending.ID},
+			bson.M{"$set": bson.M{"searchText": Text(source.ResourceType, pending.Content)}},
+		)
+		if err != nil {
+			return err
+		}
+		count++
+	}
+	if count > 0 {
+		log.Printf("Indexed %d %s records for search", count, source.Collection.Name())
+	}
+	return cursor.Err()
+}
+
+// Refresh tells the index a record's title or text changed. failures are
+// logged, the record is picked up again on its next save
+func Refresh(resourceType string, resourceID string) {
+	source, ok := sourceFor(resourceType)
+	if !ok {
+		return
+	}
+	if err := index.Refresh(context.Background(), source, resourceID); err != nil {
+		log.Printf("refresh search index for %s %s error: %v", resourceType, resourceID, err)
+	}
+}
+
+// Forget removes deleted records from the index
+func Forget(resourceType string, resourceIDs []string) {
+	source, ok := sourceFor(resourceType)
+	if !ok || len(resourceIDs) == 0 {
+		return
+	}
+	if err := index.Forget(context.Background(), source, resourceIDs); err != nil {
+		log.Printf("remove %d %s records from search index error: %v", len(resourceIDs), resourceType, err)
+	}
+}
+
+// searches the titles and text of the documents and drawings the current
+// user can open. ?q= is the query, ?type= narrows it to document or drawing
+func Search(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	query := c.Query("q")
+	terms := Terms(query)
+	if len(terms) == 0 || len(query) > maxQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must have 1 to 200 characters of words"})
+		return
+	}
+
+	limit := defaultLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxLimit)})
+			return
+		}
+		limit = parsed
+	}
+
+	searched := sources
+	if resourceType := c.Query("type"); resourceType != "" {
+		source, ok := sourceFor(resourceType)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be document or drawing"})
+			return
+		}
+		searched = []Source{source}
+	}
+
+	results := []Result{}
+	for _, source := range searched {
+		found, err := index.Search(context.Background(), source, query, user, limit)
+		if err != nil {
+			log.Printf("search %s error: %v", source.ResourceType, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+			return
+		}
+		results = append(results, found...)
+	}
+
+	// scores of both sources come from the same index, so they can be merged
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	for i := range results {
+		results[i].Snippet = Snippet(results[i].text, terms)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+	})
+}
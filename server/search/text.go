@@ -0,0 +1,181 @@
+package search
+
+import (
+	"collabify-backend/access"
+	"encoding/json"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// searchText is capped so huge documents don't blow up the index
+	maxTextLength = 100000
+	// runes of context shown around the first match
+	snippetLength = 200
+	snippetLead   = 60
+)
+
+var (
+	htmlTag    = regexp.MustCompile(`<[^>]*>`)
+	htmlHidden = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	spaceRuns  = regexp.MustCompile(`[\s\p{Z}]+`)
+)
+
+// Fragment is a piece of a snippet, Match marks the parts that matched the query
+type Fragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// Text extracts the searchable text of a document or drawing's content.
+// documents are HTML from the editor, drawings are excalidraw scenes whose
+// text elements hold both free text and shape labels
+func Text(resourceType string, content string) string {
+	var text string
+	if resourceType == access.ResourceDrawing {
+		text = sceneText(content)
+	} else {
+		text = htmlHidden.ReplaceAllString(content, " ")
+		text = html.UnescapeString(htmlTag.ReplaceAllString(text, " "))
+	}
+
+	text = strings.TrimSpace(spaceRuns.ReplaceAllString(text, " "))
+	if len(text) > maxTextLength {
+		text = strings.ToValidUTF8(text[:maxTextLength], "")
+	}
+	return text
+}
+
+func sceneText(content string) string {
+	var scene struct {
+		Elements []struct {
+			Text      string `json:"text"`
+			Name      string `json:"name"` // frame titles
+			IsDeleted bool   `json:"isDeleted"`
+		} `json:"elements"`
+	}
+	if err := json.Unmarshal([]byte(content), &scene); err != nil {
+		return ""
+	}
+
+	var parts []string
+	for _, element := range scene.Elements {
+		if element.IsDeleted {
+			continue
+		}
+		if element.Text != "" {
+			parts = append(parts, element.Text)
+		}
+		if element.Name != "" {
+			parts = append(parts, element.Name)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// a run of letters or digits, in runes
+type token struct {
+	start, end int
+	term       string
+}
+
+func tokenize(runes []rune) []token {
+	var tokens []token
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		inWord := i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+		if inWord && start < 0 {
+			start = i
+		}
+		if !inWord && start >= 0 {
+			tokens = append(tokens, token{start, i, strings.ToLower(string(runes[start:i]))})
+			start = -1
+		}
+	}
+	return tokens
+}
+
+// Terms splits a query into lowercase words
+func Terms(query string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, token := range tokenize([]rune(query)) {
+		if !seen[token.term] {
+			seen[token.term] = true
+			terms = append(terms, token.term)
+		}
+	}
+	return terms
+}
+
+// words match a term when they start with it, so "collab" finds "collaborate"
+func matchesAny(word string, terms []string) bool {
+	for _, term := range terms {
+		if strings.HasPrefix(word, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// Snippet cuts the part of text around the first match and marks every match in it
+func Snippet(text string, terms []string) []Fragment {
+	runes := []rune(text)
+	var matches []token
+	for _, token := range tokenize(runes) {
+		if matchesAny(token.term, terms) {
+			matches = append(matches, token)
+		}
+	}
+
+	start := 0
+	if len(matches) > 0 && matches[0].start > snippetLead {
+		start = matches[0].start - snippetLead
+		// don't start in the middle of a word
+		for start < matches[0].start && !unicode.IsSpace(runes[start-1]) {
+			start++
+		}
+	}
+	end := start + snippetLength
+	if end > len(runes) {
+		end = len(runes)
+	}
+	for end < len(runes) && end > start && !unicode.IsSpace(runes[end]) {
+		end--
+	}
+	if end <= start {
+		end = start + snippetLength
+		if end > len(runes) {
+			end = len(runes)
+		}
+	}
+
+	fragments := []Fragment{}
+	position := start
+	for _, match := range matches {
+		if match.start < start {
+			continue
+		}
+		if match.end > end {
+			break
+		}
+		if match.start > position {
+			fragments = append(fragments, Fragment{Text: string(runes[position:match.start])})
+		}
+		fragments = append(fragments, Fragment{Text: string(runes[match.start:match.end]), Match: true})
+		position = match.end
+	}
+	if position < end {
+		fragments = append(fragments, Fragment{Text: string(runes[position:end])})
+	}
+
+	if start > 0 {
+		fragments = append([]Fragment{{Text: "…"}}, fragments...)
+	}
+	if end < len(runes) {
+		fragments = append(fragments, Fragment{Text: "…"})
+	}
+	return fragments
+}
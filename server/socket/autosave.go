@@ -0,0 +1,228 @@
+package socket
+
+import (
+	"collabify-backend/history"
+	"collabify-backend/search"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// persist found the record in the trash
+var errSessionTrashed = errors.New("session record is in the trash")
+
+var (
+	// quiet period after the last edit before a session is written back
+	autosaveDebounce = 2 * time.Second
+	// upper bound on how long a continuously edited session stays unsaved
+	autosaveMaxWait = 30 * time.Second
+)
+
+// reads AUTOSAVE_DEBOUNCE and AUTOSAVE_MAX_WAIT (Go durations like "2s"), called from init
+func loadAutosaveConfig() {
+	if value := os.Getenv("AUTOSAVE_DEBOUNCE"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			autosaveDebounce = d
+		} else {
+			log.Printf("ignoring invalid AUTOSAVE_DEBOUNCE %q", value)
+		}
+	}
+	if value := os.Getenv("AUTOSAVE_MAX_WAIT"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			autosaveMaxWait = d
+		} else {
+			log.Printf("ignoring invalid AUTOSAVE_MAX_WAIT %q", value)
+		}
+	}
+}
+
+// tracks unsaved changes for one session
+type autosaver struct {
+	mutex      sync.Mutex
+	timer      *time.Timer
+	dirtySince time.Time
+	lastEditor string // user or guest id credited as author of the autosaved revision
+	discarded  bool   // the record is gone, nothing is saved anymore
+}
+
+// sent to all clients after the session was written to MongoDB
+type SavedData struct {
+	Kind     string    `json:"kind"`
+	Revision int       `json:"revision"`
+	SavedAt  time.Time `json:"savedAt"`
+}
+
+// MarkDirty schedules a debounced save of the session state after editor changed it
+func (manager *WebSocketManager) MarkDirty(editor string) {
+	saver := &manager.autosave
+	saver.mutex.Lock()
+	defer saver.mutex.Unlock()
+
+	if saver.discarded {
+		return
+	}
+	saver.lastEditor = editor
+
+	if saver.dirtySince.IsZero() {
+		saver.dirtySince = time.Now()
+	}
+
+	delay := autosaveDebounce
+	if remaining := autosaveMaxWait - time.Since(saver.dirtySince); remaining < delay {
+		delay = max(remaining, 0)
+	}
+
+	if saver.timer != nil {
+		saver.timer.Stop()
+	}
+	saver.timer = time.AfterFunc(delay, manager.Flush)
+}
+
+// Flush writes the session state to MongoDB now if there are unsaved changes
+func (manager *WebSocketManager) Flush() {
+	saver := &manager.autosave
+	saver.mutex.Lock()
+	if saver.timer != nil {
+		saver.timer.Stop()
+		saver.timer = nil
+	}
+	dirty := !saver.dirtySince.IsZero() && !saver.discarded
+	author := saver.lastEditor
+	saver.dirtySince = time.Time{}
+	saver.mutex.Unlock()
+
+	if !dirty {
+		return
+	}
+
+	kind, content, revision := manager.currentState()
+	if kind == "" {
+		return
+	}
+
+	err := manager.persist(kind, content, revision, author)
+	if err == errSessionTrashed {
+		CloseSession(manager.SessionID, "This "+kind+" was moved to the trash")
+		return
+	}
+	if err != nil {
+		log.Printf("autosave session %s error: %v", manager.SessionID, err)
+		// try again on the next edit or flush
+		saver.mutex.Lock()
+		if saver.dirtySince.IsZero() {
+			saver.dirtySince = time.Now()
+		}
+		saver.mutex.Unlock()
+		return
+	}
+
+	log.Printf("Autosaved %s %s at revision %d", kind, manager.SessionID, revision)
+	manager.SendExcept(nil, "saved", SavedData{Kind: kind, Revision: revision, SavedAt: time.Now()})
+}
+
+// Discard drops unsaved changes and stops autosaving, so a deleted document
+// or drawing isn't written back by its live session
+func (manager *WebSocketManager) Discard() {
+	saver := &manager.autosave
+	saver.mutex.Lock()
+	defer saver.mutex.Unlock()
+
+	if saver.timer != nil {
+		saver.timer.Stop()
+		saver.timer = nil
+	}
+	saver.dirtySince = time.Time{}
+	saver.discarded = true
+}
+
+// what kind of session this is and its current content and revision
+func (manager *WebSocketManager) currentState() (string, string, int) {
+	manager.Scene.Mutex.Lock()
+	if manager.Kind == SessionDrawing || manager.Scene.Len() > 0 {
+		defer manager.Scene.Mutex.Unlock()
+		content, err := manager.Scene.Content()
+		if err != nil {
+			log.Printf("serialize scene for session %s error: %v", manager.SessionID, err)
+			return "", "", 0
+		}
+		return SessionDrawing, content, manager.Scene.Revision()
+	}
+	manager.Scene.Mutex.Unlock()
+
+	manager.Document.Mutex.Lock()
+	defer manager.Document.Mutex.Unlock()
+	content, revision := manager.Document.Snapshot()
+	return SessionDocument, content, revision
+}
+
+// writes the state into every saved copy of this session, or creates one for
+// the session creator if nobody saved it yet
+func (manager *WebSocketManager) persist(kind string, content string, revision int, author string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection, idField := docsCollection, "docId"
+	if kind == SessionDrawing {
+		collection, idField = drawingsCollection, "drawingId"
+	}
+	if collection == nil {
+		return nil
+	}
+
+	now := time.Now()
+	filter := bson.M{idField: manager.SessionID, "deletedAt": notTrashed}
+	update := bson.M{
+		"$set": bson.M{
+			"content":    content,
+			"searchText": search.Text(kind, content),
+			"revision":   revision,
+			"updatedAt":  now,
+		},
+	}
+
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		// a trashed record keeps its id, creating another one would bring it back
+		trashed, err := collection.CountDocuments(ctx, bson.M{idField: manager.SessionID})
+		if err != nil {
+			return err
+		}
+		if trashed > 0 {
+			return errSessionTrashed
+		}
+		if manager.CreatedBy.ID == "" {
+			return nil
+		}
+
+		_, err = collection.InsertOne(ctx, bson.M{
+			idField:      manager.SessionID,
+			"content":    content,
+			"searchText": search.Text(kind, content),
+			"title":      "",
+			"revision":   revision,
+			"ownerId":    manager.CreatedBy.ID,
+			"createdBy":  manager.CreatedBy.Email,
+			"createdAt":  now,
+			"updatedAt":  now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	search.Refresh(kind, manager.SessionID)
+
+	if _, err := history.Record(ctx, kind, manager.SessionID, content, author, history.SourceAutosave); err != nil {
+		log.Printf("record autosave revision for session %s error: %v", manager.SessionID, err)
+	}
+	return nil
+}
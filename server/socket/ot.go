@@ -0,0 +1,236 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"unicode/utf16"
+)
+
+// Operation is a single edit against the document text.
+// positions and lengths are counted in UTF-16 code units so they line up with JavaScript string indices
+type Operation struct {
+	Type     string `json:"type"` // "insert" or "delete"
+	Position int    `json:"position"`
+	Text     string `json:"text,omitempty"`   // inserted text
+	Length   int    `json:"length,omitempty"` // number of deleted code units
+}
+
+const (
+	OpInsert = "insert"
+	OpDelete = "delete"
+
+	// how many revisions we keep around to transform late operations against
+	maxOperationHistory = 1000
+
+	// positions and lengths past this are rejected before any arithmetic, so transforms can't overflow
+	maxOperationOffset = 1<<31 - 1
+)
+
+var ErrStaleRevision = errors.New("base revision is no longer available, resync required")
+
+// DocumentState is the server-authoritative text of a document session
+type DocumentState struct {
+	Mutex        sync.Mutex
+	content      []uint16
+	revision     int
+	history      [][]Operation // history[i] produced revision historyStart+i+1
+	historyStart int
+}
+
+func NewDocumentState(content string, revision int) *DocumentState {
+	return &DocumentState{
+		content:      utf16.Encode([]rune(content)),
+		revision:     revision,
+		historyStart: revision,
+	}
+}
+
+// starts over from saved content with no history, caller must hold Mutex
+func (state *DocumentState) reset(content string, revision int) {
+	state.content = utf16.Encode([]rune(content))
+	state.revision = revision
+	state.history = nil
+	state.historyStart = revision
+}
+
+// returns the current text and revision, caller must hold Mutex
+func (state *DocumentState) Snapshot() (string, int) {
+	return string(utf16.Decode(state.content)), state.revision
+}
+
+// Apply transforms ops made against baseRevision over everything applied since,
+// applies the result and returns the transformed ops with the new revision.
+// caller must hold Mutex so the result can be broadcast in revision order
+func (state *DocumentState) Apply(baseRevision int, ops []Operation) ([]Operation, int, error) {
+	if baseRevision > state.revision || baseRevision < state.historyStart {
+		return nil, state.revision, ErrStaleRevision
+	}
+	for _, op := range ops {
+		if op.Position < 0 || op.Position > maxOperationOffset || op.Length < 0 || op.Length > maxOperationOffset {
+			return nil, state.revision, fmt.Errorf("operation range %d+%d out of range", op.Position, op.Length)
+		}
+	}
+
+	for _, applied := range state.history[baseRevision-state.historyStart:] {
+		ops = transformOps(ops, applied)
+	}
+
+	content, err := applyOps(state.content, ops)
+	if err != nil {
+		return nil, state.revision, err
+	}
+
+	state.content = content
+	state.revision++
+	state.history = append(state.history, ops)
+
+	if len(state.history) > maxOperationHistory {
+		trim := len(state.history) - maxOperationHistory
+		state.history = append([][]Operation(nil), state.history[trim:]...)
+		state.historyStart += trim
+	}
+
+	return ops, state.revision, nil
+}
+
+// Replace swaps in a whole new text, recorded as a delete-all plus insert so
+// ops based on older revisions still transform. returns false if the text
+// was already current. caller must hold Mutex
+func (state *DocumentState) Replace(content string) (int, bool) {
+	if current, revision := state.Snapshot(); current == content {
+		return revision, false
+	}
+
+	ops := []Operation{
+		{Type: OpDelete, Position: 0, Length: len(state.content)},
+		{Type: OpInsert, Position: 0, Text: content},
+	}
+
+	_, revision, err := state.Apply(state.revision, ops)
+	if err != nil {
+		log.Printf("replace document content error: %v", err)
+		return revision, false
+	}
+	return revision, true
+}
+
+// applies a sequence of ops to a copy of content
+func applyOps(content []uint16, ops []Operation) ([]uint16, error) {
+	result := append([]uint16(nil), content...)
+
+	for _, op := range ops {
+		switch op.Type {
+		case OpInsert:
+			if op.Position < 0 || op.Position > len(result) {
+				return nil, fmt.Errorf("insert position %d out of range", op.Position)
+			}
+			text := utf16.Encode([]rune(op.Text))
+			result = append(result[:op.Position], append(text, result[op.Position:]...)...)
+		case OpDelete:
+			// compared without adding so huge values can't wrap around
+			if op.Position < 0 || op.Length < 0 || op.Position > len(result) || op.Length > len(result)-op.Position {
+				return nil, fmt.Errorf("delete range %d+%d out of range", op.Position, op.Length)
+			}
+			result = append(result[:op.Position], result[op.Position+op.Length:]...)
+		default:
+			return nil, fmt.Errorf("unknown operation type: %s", op.Type)
+		}
+	}
+
+	return result, nil
+}
+
+// transforms sequence a so it applies after sequence b, both made against the same revision
+func transformOps(a, b []Operation) []Operation {
+	for _, op := range b {
+		a = transformOpsAgainst(a, op)
+	}
+	return a
+}
+
+// transforms sequence a against a single op b
+func transformOpsAgainst(a []Operation, b Operation) []Operation {
+	if len(a) == 0 {
+		return nil
+	}
+
+	// a[0] is transformed against b, then b is moved past a[0] for the rest of the sequence
+	first := transformOp(a[0], b, true)
+	rest := transformOps(a[1:], transformOp(b, a[0], false))
+
+	return append(first, rest...)
+}
+
+// transforms op so it applies after other. otherFirst decides which insert goes first when both hit the same position
+func transformOp(op, other Operation, otherFirst bool) []Operation {
+	if isNoop(op) {
+		return nil
+	}
+	if isNoop(other) {
+		return []Operation{op}
+	}
+
+	otherLen := utf16Len(other.Text)
+
+	switch {
+	case op.Type == OpInsert && other.Type == OpInsert:
+		if other.Position < op.Position || (other.Position == op.Position && otherFirst) {
+			op.Position += otherLen
+		}
+		return []Operation{op}
+
+	case op.Type == OpInsert && other.Type == OpDelete:
+		otherEnd := other.Position + other.Length
+		if op.Position >= otherEnd {
+			op.Position -= other.Length
+		} else if op.Position > other.Position {
+			op.Position = other.Position
+		}
+		return []Operation{op}
+
+	case op.Type == OpDelete && other.Type == OpInsert:
+		opEnd := op.Position + op.Length
+		if other.Position <= op.Position {
+			op.Position += otherLen
+			return []Operation{op}
+		}
+		if other.Position >= opEnd {
+			return []Operation{op}
+		}
+		// the insert landed inside the deleted range, keep it and delete around it
+		before := Operation{Type: OpDelete, Position: op.Position, Length: other.Position - op.Position}
+		after := Operation{Type: OpDelete, Position: op.Position + otherLen, Length: opEnd - other.Position}
+		return []Operation{before, after}
+
+	case op.Type == OpDelete && other.Type == OpDelete:
+		opEnd := op.Position + op.Length
+		otherEnd := other.Position + other.Length
+		if opEnd <= other.Position {
+			return []Operation{op}
+		}
+		if op.Position >= otherEnd {
+			op.Position -= other.Length
+			return []Operation{op}
+		}
+		// overlapping deletes, only remove what is still there
+		overlap := min(opEnd, otherEnd) - max(op.Position, other.Position)
+		op.Length -= overlap
+		op.Position = min(op.Position, other.Position)
+		if op.Length == 0 {
+			return nil
+		}
+		return []Operation{op}
+	}
+
+	return []Operation{op}
+}
+
+func isNoop(op Operation) bool {
+	return (op.Type == OpInsert && op.Text == "") || (op.Type == OpDelete && op.Length == 0)
+}
+
+func utf16Len(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
@@ -0,0 +1,202 @@
+package socket
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+func ins(position int, text string) Operation {
+	return Operation{Type: OpInsert, Position: position, Text: text}
+}
+
+func del(position int, length int) Operation {
+	return Operation{Type: OpDelete, Position: position, Length: length}
+}
+
+func apply(t *testing.T, content string, ops []Operation) string {
+	t.Helper()
+	result, err := applyOps(utf16.Encode([]rune(content)), ops)
+	if err != nil {
+		t.Fatalf("apply %v to %q: %v", ops, content, err)
+	}
+	return string(utf16.Decode(result))
+}
+
+func TestTransformOp(t *testing.T) {
+	tests := []struct {
+		name       string
+		op         Operation
+		other      Operation
+		otherFirst bool
+		want       []Operation
+	}{
+		{"insert after insert", ins(5, "x"), ins(2, "ab"), false, []Operation{ins(7, "x")}},
+		{"insert before insert", ins(1, "x"), ins(2, "ab"), false, []Operation{ins(1, "x")}},
+		{"same position, other first", ins(2, "x"), ins(2, "ab"), true, []Operation{ins(4, "x")}},
+		{"same position, op first", ins(2, "x"), ins(2, "ab"), false, []Operation{ins(2, "x")}},
+		{"insert after delete", ins(8, "x"), del(2, 3), false, []Operation{ins(5, "x")}},
+		{"insert inside delete", ins(3, "x"), del(2, 3), false, []Operation{ins(2, "x")}},
+		{"insert at delete start", ins(2, "x"), del(2, 3), false, []Operation{ins(2, "x")}},
+		{"delete after insert", del(4, 2), ins(1, "ab"), false, []Operation{del(6, 2)}},
+		{"delete before insert", del(0, 2), ins(2, "ab"), false, []Operation{del(0, 2)}},
+		{"insert inside delete splits it", del(1, 4), ins(3, "ab"), false, []Operation{del(1, 2), del(3, 2)}},
+		{"disjoint deletes, other after", del(0, 2), del(4, 2), false, []Operation{del(0, 2)}},
+		{"disjoint deletes, other before", del(5, 2), del(1, 2), false, []Operation{del(3, 2)}},
+		{"overlapping deletes", del(2, 4), del(4, 4), false, []Operation{del(2, 2)}},
+		{"delete covered by other", del(3, 2), del(1, 6), false, nil},
+		{"delete covering other", del(1, 6), del(3, 2), false, []Operation{del(1, 4)}},
+		{"noop insert", ins(3, ""), del(0, 2), false, nil},
+		{"noop other", del(3, 1), ins(0, ""), false, []Operation{del(3, 1)}},
+		{"utf16 length of other", ins(4, "x"), ins(0, "😀"), false, []Operation{ins(6, "x")}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := transformOp(test.op, test.other, test.otherFirst)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("transformOp(%v, %v, %v) = %v, want %v", test.op, test.other, test.otherFirst, got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyOps(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		ops     []Operation
+		want    string
+		wantErr bool
+	}{
+		{"insert", "hello", []Operation{ins(5, " world")}, "hello world", false},
+		{"delete", "hello world", []Operation{del(5, 6)}, "hello", false},
+		{"sequence", "abc", []Operation{del(0, 1), ins(2, "d")}, "bcd", false},
+		{"positions are utf16", "😀b", []Operation{ins(2, "a")}, "😀ab", false},
+		{"delete a surrogate pair", "a😀b", []Operation{del(1, 2)}, "ab", false},
+		{"insert out of range", "abc", []Operation{ins(4, "x")}, "", true},
+		{"delete out of range", "abc", []Operation{del(2, 2)}, "", true},
+		{"unknown type", "abc", []Operation{{Type: "retain"}}, "", true},
+		{"delete length that overflows", "abc", []Operation{del(1, math.MaxInt)}, "", true},
+		{"delete position that overflows", "abc", []Operation{del(math.MaxInt, 2)}, "", true},
+		{"insert at a huge position", "abc", []Operation{ins(math.MaxInt, "x")}, "", true},
+		{"negative position", "abc", []Operation{ins(-1, "x")}, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := applyOps(utf16.Encode([]rune(test.content)), test.ops)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", string(utf16.Decode(result)))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(utf16.Decode(result)); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// both orders of two concurrent edits must end in the same text
+func TestTransformConverges(t *testing.T) {
+	const content = "abcdefgh"
+	tests := []struct {
+		name string
+		a, b []Operation
+	}{
+		{"inserts at the same position", []Operation{ins(3, "X")}, []Operation{ins(3, "Y")}},
+		{"insert inside a delete", []Operation{ins(4, "X")}, []Operation{del(2, 4)}},
+		{"overlapping deletes", []Operation{del(1, 4)}, []Operation{del(3, 4)}},
+		{"identical deletes", []Operation{del(2, 3)}, []Operation{del(2, 3)}},
+		{"replace against insert", []Operation{del(0, 8), ins(0, "new")}, []Operation{ins(5, "X")}},
+		{"sequences", []Operation{ins(1, "X"), del(4, 2)}, []Operation{del(0, 3), ins(2, "YY")}},
+		{"surrogate pairs", []Operation{ins(2, "😀")}, []Operation{ins(2, "é"), del(5, 1)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the server applied b first, so b wins ties
+			afterB := apply(t, apply(t, content, test.b), transformOps(test.a, test.b))
+			afterA := apply(t, apply(t, content, test.a), transformBAfterA(test.b, test.a))
+			if afterA != afterB {
+				t.Errorf("a then b gives %q, b then a gives %q", afterA, afterB)
+			}
+		})
+	}
+}
+
+// transforms b over a with b still winning ties, the mirror of transformOps(a, b)
+func transformBAfterA(b, a []Operation) []Operation {
+	for _, op := range a {
+		b = transformLoser(b, op)
+	}
+	return b
+}
+
+func transformLoser(b []Operation, a Operation) []Operation {
+	if len(b) == 0 {
+		return nil
+	}
+	first := transformOp(b[0], a, false)
+	rest := b[1:]
+	for _, moved := range transformOp(a, b[0], true) {
+		rest = transformLoser(rest, moved)
+	}
+	return append(first, rest...)
+}
+
+func TestDocumentStateApply(t *testing.T) {
+	state := NewDocumentState("hello", 3)
+
+	// two clients edit revision 3 at once
+	if _, revision, err := state.Apply(3, []Operation{ins(5, "!")}); err != nil || revision != 4 {
+		t.Fatalf("first apply: revision %d, err %v", revision, err)
+	}
+	ops, revision, err := state.Apply(3, []Operation{ins(0, ">")})
+	if err != nil || revision != 5 {
+		t.Fatalf("second apply: revision %d, err %v", revision, err)
+	}
+	if want := []Operation{ins(0, ">")}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("transformed ops = %v, want %v", ops, want)
+	}
+	if content, _ := state.Snapshot(); content != ">hello!" {
+		t.Errorf("content = %q, want %q", content, ">hello!")
+	}
+
+	// huge values from a client are rejected before they reach the transform
+	for _, op := range []Operation{del(1, math.MaxInt), del(math.MaxInt, 1), ins(math.MaxInt, "x"), del(0, -1)} {
+		if _, _, err := state.Apply(3, []Operation{op}); err == nil {
+			t.Errorf("apply %v should be an error", op)
+		}
+	}
+	if content, revision := state.Snapshot(); content != ">hello!" || revision != 5 {
+		t.Errorf("rejected ops changed the state to %q at revision %d", content, revision)
+	}
+
+	if _, _, err := state.Apply(2, []Operation{ins(0, "x")}); err != ErrStaleRevision {
+		t.Errorf("revision before the history: err = %v, want ErrStaleRevision", err)
+	}
+	if _, _, err := state.Apply(9, []Operation{ins(0, "x")}); err != ErrStaleRevision {
+		t.Errorf("revision from the future: err = %v, want ErrStaleRevision", err)
+	}
+
+	if _, changed := state.Replace(">hello!"); changed {
+		t.Error("replacing with the same text should not change anything")
+	}
+	if revision, changed := state.Replace("bye"); !changed || revision != 6 {
+		t.Errorf("replace: revision %d, changed %v", revision, changed)
+	}
+	ops, _, err = state.Apply(5, []Operation{ins(6, "?")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := state.Snapshot(); content != "bye?" {
+		t.Errorf("edit made before a replace: content = %q, ops %v", content, ops)
+	}
+}
@@ -0,0 +1,225 @@
+package socket
+
+import (
+	"collabify-backend/access"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// close code sent when a user loses access to a live session
+const CloseAccessRevoked = 4403
+
+var ErrSessionForbidden = errors.New("you do not have access to this session")
+
+// works out the role a user gets in a session from the saved document or drawing.
+// sessions nobody saved yet are open to every signed in user, like before sharing existed
+func authorizeSession(sessionID string, user access.Principal) (access.Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := access.LoadWorkspaces(ctx, &user); err != nil {
+		return "", err
+	}
+
+	sources := []struct {
+		collection *mongo.Collection
+		idField    string
+	}{
+		{docsCollection, "docId"},
+		{drawingsCollection, "drawingId"},
+	}
+
+	saved := false
+	for _, source := range sources {
+		if source.collection == nil {
+			continue
+		}
+
+		filter := access.Filter(user)
+		filter[source.idField] = sessionID
+
+		var record struct {
+			OwnerID       string                `bson:"ownerId"`
+			WorkspaceID   string                `bson:"workspaceId"`
+			Collaborators []access.Collaborator `bson:"collaborators"`
+		}
+		err := source.collection.FindOne(ctx, filter).Decode(&record)
+		if err == nil {
+			return access.RoleFor(record.OwnerID, record.WorkspaceID, record.Collaborators, user), nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return "", err
+		}
+
+		count, err := source.collection.CountDocuments(ctx, bson.M{source.idField: sessionID})
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			saved = true
+		}
+	}
+
+	if saved {
+		return "", ErrSessionForbidden
+	}
+	return access.RoleEditor, nil
+}
+
+// current role of a client, roles can change while connected
+func (manager *WebSocketManager) clientRole(client *Client) access.Role {
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+	return client.Role
+}
+
+// true when a content message would change the document or drawing rather than just move a cursor
+func isEditMessage(data ContentData) bool {
+	return data.Content != "" || len(data.Ops) > 0 || len(data.Elements) > 0
+}
+
+// UpdateUserRole changes the role of a user's live connections in a session
+func UpdateUserRole(sessionID string, userID string, role access.Role) {
+	manager, exists := GetSessionManager(sessionID)
+	if !exists {
+		return
+	}
+
+	manager.Mutex.Lock()
+	for client := range manager.Clients {
+		if client.ID == userID {
+			client.Role = role
+		}
+	}
+	manager.Mutex.Unlock()
+
+	log.Printf("Updated role of %s in session %s to %s", userID, sessionID, role)
+}
+
+// checks a share link token is valid for this session
+func authorizeShareLink(sessionID string, shareToken string, password string, ip string) (*access.ShareLink, error) {
+	link, err := access.ValidateShareLink(shareToken, password, ip)
+	if err != nil {
+		return nil, err
+	}
+	if link.ResourceID != sessionID {
+		return nil, access.ErrInvalidShareLink
+	}
+
+	// links to trashed records stop working until they are restored
+	collection, idField := docsCollection, "docId"
+	if link.ResourceType == access.ResourceDrawing {
+		collection, idField = drawingsCollection, "drawingId"
+	}
+	if collection != nil {
+		count, err := collection.CountDocuments(context.Background(), bson.M{idField: sessionID, "deletedAt": notTrashed})
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, access.ErrInvalidShareLink
+		}
+	}
+	return link, nil
+}
+
+// identity for link holders without an account
+func newGuestIdentity() (string, string) {
+	return fmt.Sprintf("guest-%08x", rand.Uint32()), "Guest"
+}
+
+// RevokeUserAccess disconnects a user from a live session after their access was removed
+func RevokeUserAccess(sessionID string, userID string) {
+	disconnectClients(sessionID, "Your access to this session was revoked", func(client *Client) bool {
+		return client.ID == userID && client.LinkID == ""
+	})
+}
+
+// RevokeShareLinkAccess disconnects everyone who joined a session through a revoked link
+func RevokeShareLinkAccess(sessionID string, linkID string) {
+	disconnectClients(sessionID, "Your access to this session was revoked", func(client *Client) bool {
+		return client.LinkID == linkID
+	})
+}
+
+// DisconnectAuthSessions drops every connection opened with a token of a revoked login session
+func DisconnectAuthSessions(authSessionIDs []string) {
+	revoked := make(map[string]bool, len(authSessionIDs))
+	for _, id := range authSessionIDs {
+		revoked[id] = true
+	}
+
+	sessionMutex.RLock()
+	sessionIDs := make([]string, 0, len(sessionManagers))
+	for sessionID := range sessionManagers {
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	sessionMutex.RUnlock()
+
+	for _, sessionID := range sessionIDs {
+		disconnectClients(sessionID, "Your access to this session was revoked", func(client *Client) bool {
+			return client.AuthSessionID != "" && revoked[client.AuthSessionID]
+		})
+	}
+}
+
+// CloseSession drops everyone from a live session and throws away its unsaved
+// edits, used after the document or drawing behind it was deleted
+func CloseSession(sessionID string, message string) {
+	// later joins start a fresh session instead of this discarded one
+	sessionMutex.Lock()
+	manager, exists := sessionManagers[sessionID]
+	delete(sessionManagers, sessionID)
+	sessionMutex.Unlock()
+	if !exists {
+		return
+	}
+
+	manager.Discard()
+	manager.disconnect(message, func(client *Client) bool {
+		return true
+	})
+}
+
+// sends an error and a close frame to matching clients and drops them
+func disconnectClients(sessionID string, message string, match func(client *Client) bool) {
+	manager, exists := GetSessionManager(sessionID)
+	if !exists {
+		return
+	}
+	manager.disconnect(message, match)
+}
+
+func (manager *WebSocketManager) disconnect(message string, match func(client *Client) bool) {
+	manager.Mutex.RLock()
+	var revoked []*Client
+	for client := range manager.Clients {
+		if match(client) {
+			revoked = append(revoked, client)
+		}
+	}
+	manager.Mutex.RUnlock()
+
+	for _, client := range revoked {
+		manager.SendTo(client, "error", ErrorData{Message: message})
+
+		// closing the conn ends HandleClientRead, which unregisters the client
+		closeMessage := websocket.FormatCloseMessage(CloseAccessRevoked, "access revoked")
+		if err := client.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second)); err != nil {
+			log.Printf("write close to %s error: %v", client.ID, err)
+		}
+		client.Conn.Close()
+	}
+
+	if len(revoked) > 0 {
+		log.Printf("Removed %d clients from session %s: %s", len(revoked), manager.SessionID, message)
+	}
+}
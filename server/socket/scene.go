@@ -0,0 +1,158 @@
+package socket
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// the bits of an excalidraw element we need for merging, the rest is kept raw
+type elementMeta struct {
+	ID           string `json:"id"`
+	Version      int    `json:"version"`
+	VersionNonce int    `json:"versionNonce"`
+	IsDeleted    bool   `json:"isDeleted"`
+}
+
+type sceneElement struct {
+	Meta elementMeta
+	Raw  json.RawMessage
+}
+
+// serialized drawing as stored in the drawings collection
+type sceneContent struct {
+	Elements []json.RawMessage `json:"elements"`
+	AppState json.RawMessage   `json:"appState,omitempty"`
+}
+
+// SceneState is the server-held excalidraw scene of a drawing session.
+// elements are merged per id using excalidraw's version/versionNonce rules so
+// concurrent edits to different shapes never overwrite each other
+type SceneState struct {
+	Mutex    sync.Mutex
+	elements map[string]sceneElement
+	order    []string // z-order, new elements go on top
+	appState json.RawMessage
+	revision int // bumped on every merge that changed something
+}
+
+func NewSceneState() *SceneState {
+	return &SceneState{
+		elements: make(map[string]sceneElement),
+	}
+}
+
+// parses a serialized drawing ({"elements": [...], "appState": {...}}) into raw elements
+func ParseSceneContent(content string) ([]json.RawMessage, json.RawMessage, error) {
+	var scene sceneContent
+	if err := json.Unmarshal([]byte(content), &scene); err != nil {
+		return nil, nil, err
+	}
+	if scene.Elements == nil {
+		return nil, nil, fmt.Errorf("content has no elements")
+	}
+	return scene.Elements, scene.AppState, nil
+}
+
+// Merge folds incoming elements into the scene. it returns the elements that
+// changed the scene and the stored elements that beat an incoming one, which
+// the sender should adopt. caller must hold Mutex
+func (scene *SceneState) Merge(incoming []json.RawMessage) ([]json.RawMessage, []json.RawMessage) {
+	var changed, rejected []json.RawMessage
+
+	for _, raw := range incoming {
+		var meta elementMeta
+		if err := json.Unmarshal(raw, &meta); err != nil || meta.ID == "" {
+			continue
+		}
+
+		existing, exists := scene.elements[meta.ID]
+		if exists && !shouldReplaceElement(existing.Meta, meta) {
+			if existing.Meta.Version != meta.Version || existing.Meta.VersionNonce != meta.VersionNonce {
+				rejected = append(rejected, existing.Raw)
+			}
+			continue
+		}
+
+		if !exists {
+			scene.order = append(scene.order, meta.ID)
+		}
+		element := sceneElement{Meta: meta, Raw: append(json.RawMessage(nil), raw...)}
+		scene.elements[meta.ID] = element
+		changed = append(changed, element.Raw)
+	}
+
+	if len(changed) > 0 {
+		scene.revision++
+	}
+
+	return changed, rejected
+}
+
+// same rule as excalidraw's reconciliation: higher version wins, ties go to the lower nonce
+func shouldReplaceElement(current, incoming elementMeta) bool {
+	if incoming.Version != current.Version {
+		return incoming.Version > current.Version
+	}
+	return incoming.VersionNonce < current.VersionNonce
+}
+
+// MergeContent merges a full serialized drawing and keeps its appState. caller must hold Mutex
+func (scene *SceneState) MergeContent(content string) ([]json.RawMessage, error) {
+	elements, appState, err := ParseSceneContent(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(appState) > 0 && string(appState) != "null" {
+		scene.appState = append(json.RawMessage(nil), appState...)
+	}
+
+	changed, _ := scene.Merge(elements)
+	return changed, nil
+}
+
+// Reset throws the scene away and loads content in its place, used when an
+// older revision is restored. caller must hold Mutex
+func (scene *SceneState) Reset(content string) error {
+	elements, appState, err := ParseSceneContent(content)
+	if err != nil {
+		return err
+	}
+
+	revision := scene.revision
+	scene.elements = make(map[string]sceneElement)
+	scene.order = nil
+	scene.appState = appState
+	scene.Merge(elements)
+	scene.revision = revision + 1
+
+	return nil
+}
+
+// serializes the scene in the same shape clients save drawings in. caller must hold Mutex
+func (scene *SceneState) Content() (string, error) {
+	out := sceneContent{
+		Elements: make([]json.RawMessage, 0, len(scene.order)),
+		AppState: scene.appState,
+	}
+	for _, id := range scene.order {
+		out.Elements = append(out.Elements, scene.elements[id].Raw)
+	}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// current scene revision. caller must hold Mutex
+func (scene *SceneState) Revision() int {
+	return scene.revision
+}
+
+// number of elements held, including deleted ones. caller must hold Mutex
+func (scene *SceneState) Len() int {
+	return len(scene.order)
+}
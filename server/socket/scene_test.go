@@ -0,0 +1,117 @@
+package socket
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func element(id string, version int, nonce int) json.RawMessage {
+	return json.RawMessage(fmt.Sprintf(`{"id":%q,"version":%d,"versionNonce":%d}`, id, version, nonce))
+}
+
+func ids(elements []json.RawMessage) []string {
+	var result []string
+	for _, raw := range elements {
+		var meta elementMeta
+		json.Unmarshal(raw, &meta)
+		result = append(result, fmt.Sprintf("%s@%d/%d", meta.ID, meta.Version, meta.VersionNonce))
+	}
+	return result
+}
+
+func TestShouldReplaceElement(t *testing.T) {
+	tests := []struct {
+		name              string
+		current, incoming elementMeta
+		want              bool
+	}{
+		{"higher version wins", elementMeta{Version: 2, VersionNonce: 1}, elementMeta{Version: 3, VersionNonce: 9}, true},
+		{"lower version loses", elementMeta{Version: 3, VersionNonce: 9}, elementMeta{Version: 2, VersionNonce: 1}, false},
+		{"tie goes to the lower nonce", elementMeta{Version: 2, VersionNonce: 5}, elementMeta{Version: 2, VersionNonce: 4}, true},
+		{"tie with a higher nonce loses", elementMeta{Version: 2, VersionNonce: 4}, elementMeta{Version: 2, VersionNonce: 5}, false},
+		{"identical element is kept", elementMeta{Version: 2, VersionNonce: 4}, elementMeta{Version: 2, VersionNonce: 4}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := shouldReplaceElement(test.current, test.incoming); got != test.want {
+				t.Errorf("shouldReplaceElement(%+v, %+v) = %v, want %v", test.current, test.incoming, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSceneMerge(t *testing.T) {
+	tests := []struct {
+		name         string
+		incoming     []json.RawMessage
+		wantChanged  []string
+		wantRejected []string
+		wantRevision int
+	}{
+		{"new element", []json.RawMessage{element("c", 1, 1)}, []string{"c@1/1"}, nil, 2},
+		{"newer version", []json.RawMessage{element("a", 3, 7)}, []string{"a@3/7"}, nil, 2},
+		{"stale version is rejected", []json.RawMessage{element("a", 1, 1)}, nil, []string{"a@2/5"}, 1},
+		{"same version, lower nonce", []json.RawMessage{element("a", 2, 4)}, []string{"a@2/4"}, nil, 2},
+		{"same version, higher nonce", []json.RawMessage{element("a", 2, 6)}, nil, []string{"a@2/5"}, 1},
+		{"echo of the stored element", []json.RawMessage{element("a", 2, 5)}, nil, nil, 1},
+		{"elements without an id are skipped", []json.RawMessage{json.RawMessage(`{"version":9}`), json.RawMessage(`nope`)}, nil, nil, 1},
+		{"mixed", []json.RawMessage{element("a", 1, 1), element("b", 2, 1)}, []string{"b@2/1"}, []string{"a@2/5"}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scene := NewSceneState()
+			scene.Merge([]json.RawMessage{element("a", 2, 5), element("b", 1, 3)})
+
+			changed, rejected := scene.Merge(test.incoming)
+			if got := fmt.Sprint(ids(changed)); got != fmt.Sprint(test.wantChanged) {
+				t.Errorf("changed = %s, want %v", got, test.wantChanged)
+			}
+			if got := fmt.Sprint(ids(rejected)); got != fmt.Sprint(test.wantRejected) {
+				t.Errorf("rejected = %s, want %v", got, test.wantRejected)
+			}
+			if scene.Revision() != test.wantRevision {
+				t.Errorf("revision = %d, want %d", scene.Revision(), test.wantRevision)
+			}
+		})
+	}
+}
+
+func TestSceneContent(t *testing.T) {
+	scene := NewSceneState()
+	if _, err := scene.MergeContent(`{"elements":[` + string(element("a", 1, 1)) + `,` + string(element("b", 1, 1)) + `],"appState":{"theme":"dark"}}`); err != nil {
+		t.Fatal(err)
+	}
+	// updates keep the z-order, new elements go on top
+	scene.Merge([]json.RawMessage{element("a", 2, 1), element("c", 1, 1)})
+
+	content, err := scene.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+	elements, appState, err := ParseSceneContent(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(ids(elements)), "[a@2/1 b@1/1 c@1/1]"; got != want {
+		t.Errorf("elements = %s, want %s", got, want)
+	}
+	if string(appState) != `{"theme":"dark"}` {
+		t.Errorf("appState = %s", appState)
+	}
+
+	if _, err := scene.MergeContent(`{"appState":{}}`); err == nil {
+		t.Error("content without elements should be an error")
+	}
+
+	// restoring an older revision replaces everything, even newer versions
+	revision := scene.Revision()
+	if err := scene.Reset(`{"elements":[` + string(element("a", 1, 1)) + `]}`); err != nil {
+		t.Fatal(err)
+	}
+	if scene.Len() != 1 || scene.Revision() != revision+1 {
+		t.Errorf("after reset: %d elements at revision %d", scene.Len(), scene.Revision())
+	}
+}
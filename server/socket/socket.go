@@ -1,18 +1,17 @@
 package socket
 
 import (
+	"collabify-backend/access"
+	"collabify-backend/auth"
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -20,9 +19,23 @@ import (
 )
 
 type ContentData struct {
-	Content  string   `json:"content"`
-	Position Position `json:"position"`
-	UserData UserData `json:"userData"`
+	Content  string      `json:"content"`
+	Position Position    `json:"position"`
+	UserData UserData    `json:"userData"`
+	Ops      []Operation `json:"ops,omitempty"`      // OT edits, when present Content is ignored
+	Revision int         `json:"revision,omitempty"` // base revision from the client, assigned revision from the server
+	Elements []json.RawMessage `json:"elements,omitempty"` // changed excalidraw elements
+}
+
+// sent back to the author once their ops are applied
+type AckData struct {
+	Revision int         `json:"revision"`
+	Ops      []Operation `json:"ops"`
+}
+
+type ErrorData struct {
+	Message  string `json:"message"`
+	Revision int    `json:"revision,omitempty"`
 }
 
 type Position struct {
@@ -36,17 +49,12 @@ type UserData struct {
 	UserColor string `json:"userColor"`
 }
 
-var JWT_KEY string
-
 func init() {
 	_ = godotenv.Load()
-	JWT_KEY = os.Getenv("JWT_KEY")
-	jwtSecret = []byte(JWT_KEY) // Set the global jwtSecret
-	log.Printf("JWT_KEY loaded successfully")
+	loadAutosaveConfig()
 }
 
 var (
-	jwtSecret []byte // Will be set from environment variable
 	animalEmojis = []string{"🦁", "🐮", "🐯", "🐰", "🐻", "🐼", "🐨", "🐸", "🐷", "🐵", "🦊", "🐺", "🐴", "🦄", "🐧", "🐦", "🦅", "🦆", "🐔", "🐢"}
 	usersCollection *mongo.Collection // Will be set from main package
 )
@@ -58,46 +66,38 @@ func SetUsersCollection(collection *mongo.Collection) {
 
 // user struct for database queries
 type User struct {
-	ID    interface{} `bson:"_id,omitempty"`
+	ID    bson.ObjectID `bson:"_id,omitempty"`
 	Email string      `bson:"email"`
 	Name  string      `bson:"name"`
+	CursorColor string `bson:"cursorColor,omitempty"` // presence color picked in the profile
+	EmailVerified bool `bson:"emailVerified"`
 }
 
-// extract user info from JWT and get name from DB
-func extractUserFromToken(tokenString string) (string, string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
-
-	if err != nil || !token.Valid {
-		return "", "", fmt.Errorf("invalid token")
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", "", fmt.Errorf("invalid token claims")
+// extract user info from JWT and get name and cursor color from DB
+func extractUserFromToken(tokenString string) (User, string, error) {
+	// same validation as the REST middleware, including revoked sessions
+	claims, err := auth.ParseAccessToken(tokenString)
+	if err != nil {
+		return User{}, "", err
 	}
-
-	userEmail, ok := claims["user_email"].(string)
-	if !ok {
-		return "", "", fmt.Errorf("invalid user email in token")
+	userID, err := bson.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return User{}, "", err
 	}
+	unknown := User{ID: userID, Email: claims.UserEmail, Name: "Unknown User"}
 
 	// get user name from database
 	var user User
 	if usersCollection != nil {
-		err := usersCollection.FindOne(context.Background(), bson.M{"email": userEmail}).Decode(&user)
+		err := usersCollection.FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user)
 		if err != nil {
 			log.Printf("Could not find user in database: %v", err)
-			return userEmail, "Unknown User", nil
+			return unknown, claims.SessionID, nil
 		}
-		return userEmail, user.Name, nil
+		return user, claims.SessionID, nil
 	}
 
-	return userEmail, "Unknown User", nil
+	return unknown, claims.SessionID, nil
 }
 
 // random animal emoji
@@ -105,6 +105,13 @@ func getRandomAnimalEmoji() string {
 	return animalEmojis[rand.Intn(len(animalEmojis))]
 }
 
+// message types clients may send besides content, relayed to the rest of the
+// session with the sender's user data. every other type is server only
+var clientRelayTypes = map[string]bool{
+	"cursor":   true,
+	"presence": true,
+}
+
 type ChatMessage struct {
 	Data interface{} `json:"data"` 
 	Type string      `json:"type"` // "content" or "user-data", "user-added", etc.
@@ -113,9 +120,13 @@ type ChatMessage struct {
 type Client struct{
 	Conn *websocket.Conn
 	Send chan []byte  
-	ID string
+	ID string // user id, or a guest id for link holders without an account
+	Email string // empty for guests
 	SessionID string // add session ID to client
 	Data map[string]UserData
+	Role access.Role // guarded by the manager Mutex, changes when access is updated
+	LinkID string // share link used to join, empty for regular members
+	AuthSessionID string // login session of the token used to join, empty for guests
 }
 
 // this manages websocket connections for a specific session
@@ -126,6 +137,12 @@ type WebSocketManager struct {
 	Unregister chan *Client
 	Mutex sync.RWMutex
 	SessionID string // Add session ID to manager
+	Kind string // SessionDocument, SessionDrawing or "" when nothing is saved yet
+	Document *DocumentState // authoritative text for OT content messages
+	Scene *SceneState // authoritative excalidraw scene for drawing sessions
+	CreatedBy access.Principal // first user to join, owns the record if autosave has to create one
+	autosave autosaver
+	loaded sync.Once // saved state is loaded outside sessionMutex, see waitLoaded
 }
 
 // global session managers
@@ -141,11 +158,33 @@ func NewWebSocketManager(sessionID string) *WebSocketManager {
 		Register: make(chan *Client),
 		Unregister: make(chan *Client),
 		SessionID: sessionID,
+		Document: NewDocumentState("", 0),
+		Scene: NewSceneState(),
 	}
 }
 
+// returns the live session manager if there is one, once its saved state is loaded
+func GetSessionManager(sessionID string) (*WebSocketManager, bool) {
+	sessionMutex.RLock()
+	manager, exists := sessionManagers[sessionID]
+	sessionMutex.RUnlock()
+
+	if exists {
+		manager.waitLoaded()
+	}
+	return manager, exists
+}
+
 // Get or create a session manager
 func GetOrCreateSessionManager(sessionID string) *WebSocketManager {
+	manager := getOrPublishSessionManager(sessionID)
+	// the database read happens without the global lock, joins of the same
+	// session wait here until it is done
+	manager.waitLoaded()
+	return manager
+}
+
+func getOrPublishSessionManager(sessionID string) *WebSocketManager {
 	sessionMutex.Lock()
 	defer sessionMutex.Unlock()
 	
@@ -165,6 +204,9 @@ func(manager *WebSocketManager) Run(){
 		select{
 		case client := <-manager.Register:
 			manager.Mutex.Lock()
+			if manager.CreatedBy.ID == "" && client.LinkID == "" && client.Email != "" {
+				manager.CreatedBy = access.Principal{ID: client.ID, Email: client.Email}
+			}
 			manager.Clients[client] = true
 			manager.Mutex.Unlock()
 
@@ -177,9 +219,12 @@ func(manager *WebSocketManager) Run(){
 				// notify other clients about the disconnection
 				go manager.HandleDeleteUser(client)
 				
-				// cleanup empty session managers
+				// flush unsaved edits, then cleanup empty session managers
 				if len(manager.Clients) == 0 {
-					go cleanupEmptySession(manager.SessionID)
+					go func() {
+						manager.Flush()
+						cleanupEmptySession(manager.SessionID)
+					}()
 				}
 			}
 			manager.Mutex.Unlock()
@@ -205,7 +250,7 @@ func(manager *WebSocketManager) Run(){
 	}
 }
 
-func HandleWBConnections(w http.ResponseWriter, r *http.Request) {
+func HandleWBConnections(w http.ResponseWriter, r *http.Request, clientIP string) {
 	
 	// extract session id
 	sessionID := r.URL.Query().Get("session")
@@ -223,19 +268,63 @@ func HandleWBConnections(w http.ResponseWriter, r *http.Request) {
 		tokenString = r.URL.Query().Get("token")
 	}
 
-	if tokenString == "" {
+	// share links let people without an account join
+	shareToken := r.URL.Query().Get("share")
+
+	if tokenString == "" && shareToken == "" {
 		http.Error(w, "Authorization token required", http.StatusUnauthorized)
 		return
 	}
 
-	// get user information from token
-	userEmail, userName, err := extractUserFromToken(tokenString)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
+	var userID, userEmail, userName, userColor, linkID, authSessionID string
+	var role access.Role
+	var err error
+
+	if tokenString != "" {
+		// get user information from token
+		var user User
+		user, authSessionID, err = extractUserFromToken(tokenString)
+		if err != nil {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		userID, userEmail, userName, userColor = user.ID.Hex(), user.Email, user.Name, user.CursorColor
+
+		// only owners and collaborators may join saved sessions
+		role, err = authorizeSession(sessionID, access.Principal{ID: userID, Email: userEmail, EmailVerified: user.EmailVerified})
+		if err != nil && (err != ErrSessionForbidden || shareToken == "") {
+			if err == ErrSessionForbidden {
+				http.Error(w, "You do not have access to this session", http.StatusForbidden)
+				return
+			}
+			log.Printf("authorize session %s for %s error: %v", sessionID, userEmail, err)
+			http.Error(w, "Could not check session access", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if role == "" {
+		link, err := authorizeShareLink(sessionID, shareToken, r.URL.Query().Get("sharePassword"), clientIP)
+		if err != nil {
+			if err == access.ErrShareLinkLocked {
+				http.Error(w, "Too many wrong passwords, try again later", http.StatusTooManyRequests)
+				return
+			}
+			if err == access.ErrShareLinkPassword {
+				http.Error(w, "Share link password required", http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, "Invalid or expired share link", http.StatusForbidden)
+			return
+		}
+
+		role = link.Role
+		linkID = link.LinkID
+		if userID == "" {
+			userID, userName = newGuestIdentity()
+		}
 	}
 
-	// Always allow WebSocket connection
 	manager := GetOrCreateSessionManager(sessionID)
 
 	// making Upgrader
@@ -256,24 +345,32 @@ func HandleWBConnections(w http.ResponseWriter, r *http.Request) {
 	}
 
 	emoji := getRandomAnimalEmoji()
+	// users without a profile color still get a random one
+	if userColor == "" {
+		userColor = GetRandomColor()
+	}
 	data := map[string]UserData{
 		"userData": {
-			UserId:    userEmail,
+			UserId:    userID,
 			UserName:  userName + " " + emoji,
-			UserColor: GetRandomColor(),
+			UserColor: userColor,
 		},
 	}
 
 	client := &Client{
 		Conn: conn,
 		Send: make(chan []byte, 512), 
-		ID: userEmail, 
+		ID: userID, 
+		Email: userEmail,
 		SessionID: sessionID,
 		Data: data,
+		Role: role,
+		LinkID: linkID,
+		AuthSessionID: authSessionID,
 	}
 
-  	// will hit case client := <-manager.Register: in Run() func 
-	manager.Register <- client
+	// snapshot goes out first, then the client starts getting live updates
+	manager.registerWithSnapshot(client)
 
 	go manager.HandleClientRead(client)
 	go manager.HandleClientWrite(client)
@@ -389,6 +486,39 @@ func(manager *WebSocketManager) HandleClientRead(client *Client) {
 		}
 
 		if typeOnly.Type == "content" {
+			var contentKind struct {
+				Data ContentData `json:"data"`
+			}
+			if err := json.Unmarshal(message, &contentKind); err != nil {
+				log.Printf("unmarshal content error: %v", err)
+				continue
+			}
+
+			// read-only participants still share their cursor but cannot edit
+			if isEditMessage(contentKind.Data) && !manager.clientRole(client).CanWrite() {
+				manager.SendTo(client, "error", ErrorData{Message: "You do not have permission to edit this session"})
+				continue
+			}
+
+			if len(contentKind.Data.Ops) > 0 {
+				manager.HandleContentOperations(client, message)
+				continue
+			}
+			if len(contentKind.Data.Elements) > 0 || isSceneContent(contentKind.Data.Content) {
+				manager.HandleSceneUpdate(client, message)
+				continue
+			}
+
+			// legacy editors send the whole text, keep the session document in step with it
+			if manager.Kind != SessionDrawing && contentKind.Data.Content != "" {
+				manager.Document.Mutex.Lock()
+				_, changed := manager.Document.Replace(contentKind.Data.Content)
+				manager.Document.Mutex.Unlock()
+				if changed {
+					manager.MarkDirty(client.ID)
+				}
+			}
+
 			// Check if there are other clients before processing content messages (optimization)
 			manager.Mutex.RLock()
 			clientCount := len(manager.Clients)
@@ -415,13 +545,156 @@ func(manager *WebSocketManager) HandleClientRead(client *Client) {
 				client.ID, contentMsg.Data.UserData.UserId, len(contentMsg.Data.Content), 
 				contentMsg.Data.Position.X, contentMsg.Data.Position.Y)
 
-			// Broadcast the original message
+			// stamped with the sender's own user data so nobody can pose as someone else
+			contentMsg.Data.UserData = client.Data["userData"]
+			stamped, err := json.Marshal(contentMsg)
+			if err != nil {
+				log.Printf("marshal content error: %v", err)
+				continue
+			}
 			log.Printf("Broadcasting content message from %s", client.ID)
-			manager.Broadcast <- message
+			manager.Broadcast <- stamped
+		} else if clientRelayTypes[typeOnly.Type] {
+			// presence only carries a position, any role may share it
+			var presence struct {
+				Data struct {
+					Position Position `json:"position"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(message, &presence); err != nil {
+				log.Printf("unmarshal %s error: %v", typeOnly.Type, err)
+				continue
+			}
+			manager.SendExcept(client, typeOnly.Type, ContentData{
+				Position: presence.Data.Position,
+				UserData: client.Data["userData"],
+			})
 		} else {
-			// Always process other message types (user-data, user-added, etc.) for proper WebSocket functionality
-			log.Printf("Received %s message from %s", typeOnly.Type, client.ID)
-			manager.Broadcast <- message
+			// user-data, user-added, snapshot, ack, error and the like only come from the server
+			log.Printf("Dropped %s message from %s", typeOnly.Type, client.ID)
+		}
+	}
+}
+
+// runs OT content messages through the session document and fans out the result
+func (manager *WebSocketManager) HandleContentOperations(client *Client, message []byte) {
+	var contentMsg struct {
+		Type string      `json:"type"`
+		Data ContentData `json:"data"`
+	}
+
+	if err := json.Unmarshal(message, &contentMsg); err != nil {
+		log.Printf("unmarshal content ops error: %v", err)
+		return
+	}
+
+	// hold the document lock until sent so every client sees revisions in order
+	manager.Document.Mutex.Lock()
+	defer manager.Document.Mutex.Unlock()
+
+	ops, revision, err := manager.Document.Apply(contentMsg.Data.Revision, contentMsg.Data.Ops)
+	if err != nil {
+		log.Printf("Rejected ops from %s at revision %d: %v", client.ID, contentMsg.Data.Revision, err)
+		manager.SendTo(client, "error", ErrorData{Message: err.Error(), Revision: revision})
+		return
+	}
+
+	log.Printf("Applied %d ops from %s, session %s now at revision %d", len(ops), client.ID, manager.SessionID, revision)
+	manager.MarkDirty(client.ID)
+
+	contentMsg.Data.Content = ""
+	contentMsg.Data.Ops = ops
+	contentMsg.Data.Revision = revision
+	contentMsg.Data.UserData = client.Data["userData"]
+	manager.SendExcept(client, "content", contentMsg.Data)
+	manager.SendTo(client, "ack", AckData{Revision: revision, Ops: ops})
+}
+
+// merges drawing elements into the session scene and sends only what changed
+func (manager *WebSocketManager) HandleSceneUpdate(client *Client, message []byte) {
+	var contentMsg struct {
+		Type string      `json:"type"`
+		Data ContentData `json:"data"`
+	}
+
+	if err := json.Unmarshal(message, &contentMsg); err != nil {
+		log.Printf("unmarshal scene update error: %v", err)
+		return
+	}
+
+	manager.Scene.Mutex.Lock()
+	defer manager.Scene.Mutex.Unlock()
+
+	var changed, rejected []json.RawMessage
+	if len(contentMsg.Data.Elements) > 0 {
+		changed, rejected = manager.Scene.Merge(contentMsg.Data.Elements)
+	} else {
+		elements, _, err := ParseSceneContent(contentMsg.Data.Content)
+		if err != nil {
+			log.Printf("parse scene content error: %v", err)
+			return
+		}
+		changed, rejected = manager.Scene.Merge(elements)
+	}
+
+	log.Printf("Merged scene update from %s: %d changed, %d rejected", client.ID, len(changed), len(rejected))
+
+	// sender is behind on some elements, give it the winning versions
+	if len(rejected) > 0 {
+		manager.SendTo(client, "content", ContentData{Elements: rejected})
+	}
+
+	if len(changed) > 0 {
+		contentMsg.Data.Content = ""
+		contentMsg.Data.Elements = changed
+		contentMsg.Data.UserData = client.Data["userData"]
+		manager.SendExcept(client, "content", contentMsg.Data)
+		manager.MarkDirty(client.ID)
+	}
+}
+
+// sends a message straight to one client, bypassing the broadcast channel
+func (manager *WebSocketManager) SendTo(client *Client, msgType string, data interface{}) {
+	jsonData, err := json.Marshal(ChatMessage{Data: data, Type: msgType})
+	if err != nil {
+		log.Printf("marshal %s error: %v", msgType, err)
+		return
+	}
+
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	if _, ok := manager.Clients[client]; !ok {
+		return
+	}
+
+	select {
+	case client.Send <- jsonData:
+	default:
+		log.Printf("Client %s send channel is full, dropping %s message", client.ID, msgType)
+	}
+}
+
+// sends a message to every client in the session except the given one
+func (manager *WebSocketManager) SendExcept(sender *Client, msgType string, data interface{}) {
+	jsonData, err := json.Marshal(ChatMessage{Data: data, Type: msgType})
+	if err != nil {
+		log.Printf("marshal %s error: %v", msgType, err)
+		return
+	}
+
+	manager.Mutex.RLock()
+	defer manager.Mutex.RUnlock()
+
+	for client := range manager.Clients {
+		if client == sender {
+			continue
+		}
+
+		select {
+		case client.Send <- jsonData:
+		default:
+			log.Printf("Client %s send channel is full, dropping %s message", client.ID, msgType)
 		}
 	}
 }
@@ -440,6 +713,44 @@ func(manager *WebSocketManager) HandleClientWrite(client *Client) {
 	}
 }
 
+// a full serialized drawing sent as content
+func isSceneContent(content string) bool {
+	if !strings.HasPrefix(strings.TrimSpace(content), "{") {
+		return false
+	}
+	_, _, err := ParseSceneContent(content)
+	return err == nil
+}
+
+// MergeSessionScene merges a saved drawing into its live session, if any, and
+// returns the merged scene so the saved state matches what collaborators see
+func MergeSessionScene(sessionID string, content string) (string, bool) {
+	manager, exists := GetSessionManager(sessionID)
+	if !exists {
+		return content, false
+	}
+
+	manager.Scene.Mutex.Lock()
+	defer manager.Scene.Mutex.Unlock()
+
+	changed, err := manager.Scene.MergeContent(content)
+	if err != nil {
+		log.Printf("merge saved drawing into session %s error: %v", sessionID, err)
+		return content, false
+	}
+
+	if len(changed) > 0 {
+		manager.SendExcept(nil, "content", ContentData{Elements: changed})
+	}
+
+	merged, err := manager.Scene.Content()
+	if err != nil {
+		log.Printf("serialize scene for session %s error: %v", sessionID, err)
+		return content, false
+	}
+	return merged, true
+}
+
 // cleanup empty session managers
 func cleanupEmptySession(sessionID string) {
 	time.Sleep(5 * time.Second) // Wait a bit to avoid premature cleanup
@@ -0,0 +1,59 @@
+package socket
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func testClient(id, name string) *Client {
+	return &Client{
+		ID:   id,
+		Send: make(chan []byte, 4),
+		Data: map[string]UserData{"userData": {UserId: id, UserName: name, UserColor: "#000"}},
+	}
+}
+
+// relayed edits carry the sender's identity from the server, not what the client claimed
+func TestBroadcastsStampSenderIdentity(t *testing.T) {
+	forged := UserData{UserId: "victim", UserName: "Victim", UserColor: "#fff"}
+
+	tests := []struct {
+		name    string
+		message ContentData
+		handle  func(*WebSocketManager, *Client, []byte)
+	}{
+		{"content ops", ContentData{Ops: []Operation{ins(0, "hi")}, UserData: forged}, (*WebSocketManager).HandleContentOperations},
+		{"scene update", ContentData{Elements: []json.RawMessage{element("a", 1, 1)}, UserData: forged}, (*WebSocketManager).HandleSceneUpdate},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := NewWebSocketManager("session")
+			manager.autosave.discarded = true
+			sender, peer := testClient("sender", "Sender"), testClient("peer", "Peer")
+			manager.Clients[sender] = true
+			manager.Clients[peer] = true
+
+			message, err := json.Marshal(ChatMessage{Type: "content", Data: test.message})
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.handle(manager, sender, message)
+
+			select {
+			case raw := <-peer.Send:
+				var received struct {
+					Data ContentData `json:"data"`
+				}
+				if err := json.Unmarshal(raw, &received); err != nil {
+					t.Fatal(err)
+				}
+				if received.Data.UserData != sender.Data["userData"] {
+					t.Errorf("peer got userData %+v, want %+v", received.Data.UserData, sender.Data["userData"])
+				}
+			default:
+				t.Fatal("peer got nothing")
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	SessionDocument = "document"
+	SessionDrawing  = "drawing"
+)
+
+var (
+	docsCollection     *mongo.Collection // Will be set from main package
+	drawingsCollection *mongo.Collection // Will be set from main package
+)
+
+// allows main package to set the documents collection used to seed sessions
+func SetDocsCollection(collection *mongo.Collection) {
+	docsCollection = collection
+}
+
+// allows main package to set the drawings collection used to seed sessions
+func SetDrawingsCollection(collection *mongo.Collection) {
+	drawingsCollection = collection
+}
+
+// sent to every new client before any live update
+type SnapshotData struct {
+	Kind     string `json:"kind"` // "document", "drawing" or "" for a brand new session
+	Content  string `json:"content"`
+	Revision int    `json:"revision"`
+}
+
+// stored content as it lives in the documents/drawings collections
+type storedContent struct {
+	Content  string `bson:"content"`
+	Revision int    `bson:"revision"`
+}
+
+// records in the trash never seed or receive a live session
+var notTrashed = bson.M{"$exists": false}
+
+// seeds the manager state from the latest saved document or drawing for this session
+func (manager *WebSocketManager) loadSessionState() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// several users may have saved their own copy, the latest one wins
+	findLatest := options.FindOne().SetSort(bson.D{{Key: "updatedAt", Value: -1}})
+
+	if docsCollection != nil {
+		var doc storedContent
+		err := docsCollection.FindOne(ctx, bson.M{"docId": manager.SessionID, "deletedAt": notTrashed}, findLatest).Decode(&doc)
+		if err == nil {
+			manager.Kind = SessionDocument
+			// filled in place, the manager is already published
+			manager.Document.Mutex.Lock()
+			manager.Document.reset(doc.Content, doc.Revision)
+			manager.Document.Mutex.Unlock()
+			log.Printf("Seeded session %s from saved document", manager.SessionID)
+			return
+		}
+		if err != mongo.ErrNoDocuments {
+			log.Printf("load document for session %s error: %v", manager.SessionID, err)
+		}
+	}
+
+	if drawingsCollection != nil {
+		var drawing storedContent
+		err := drawingsCollection.FindOne(ctx, bson.M{"drawingId": manager.SessionID, "deletedAt": notTrashed}, findLatest).Decode(&drawing)
+		if err == nil {
+			manager.Kind = SessionDrawing
+			manager.Scene.Mutex.Lock()
+			if _, err := manager.Scene.MergeContent(drawing.Content); err != nil {
+				log.Printf("parse saved drawing for session %s error: %v", manager.SessionID, err)
+			}
+			manager.Scene.revision = drawing.Revision
+			manager.Scene.Mutex.Unlock()
+			log.Printf("Seeded session %s from saved drawing", manager.SessionID)
+			return
+		}
+		if err != mongo.ErrNoDocuments {
+			log.Printf("load drawing for session %s error: %v", manager.SessionID, err)
+		}
+	}
+}
+
+// seeds the state on first use, concurrent callers block until it is seeded.
+// everything that reads or changes Kind, Document or Scene goes through it,
+// GetOrCreateSessionManager and GetSessionManager included
+func (manager *WebSocketManager) waitLoaded() {
+	manager.loaded.Do(manager.loadSessionState)
+}
+
+// queues the current session state on the client and registers it while the
+// state is locked, so no update can land between the snapshot and live messages
+func (manager *WebSocketManager) registerWithSnapshot(client *Client) {
+	manager.waitLoaded()
+	manager.Document.Mutex.Lock()
+	defer manager.Document.Mutex.Unlock()
+	manager.Scene.Mutex.Lock()
+	defer manager.Scene.Mutex.Unlock()
+
+	jsonData, err := json.Marshal(ChatMessage{Data: manager.snapshot(), Type: "snapshot"})
+	if err != nil {
+		log.Printf("marshal snapshot error: %v", err)
+	} else {
+		client.Send <- jsonData
+	}
+
+	// will hit case client := <-manager.Register: in Run() func
+	manager.Register <- client
+}
+
+// current session state, caller must hold the Document and Scene Mutex
+func (manager *WebSocketManager) snapshot() SnapshotData {
+	snapshot := SnapshotData{Kind: manager.Kind}
+
+	switch {
+	case manager.Kind == SessionDrawing || manager.Scene.Len() > 0:
+		content, err := manager.Scene.Content()
+		if err != nil {
+			log.Printf("serialize snapshot for session %s error: %v", manager.SessionID, err)
+			break
+		}
+		snapshot.Kind = SessionDrawing
+		snapshot.Content = content
+		snapshot.Revision = manager.Scene.Revision()
+	default:
+		snapshot.Content, snapshot.Revision = manager.Document.Snapshot()
+	}
+
+	return snapshot
+}
+
+// ResetSessionContent swaps the live state for restored content and sends every
+// client a fresh snapshot
+func ResetSessionContent(sessionID string, kind string, content string) {
+	manager, exists := GetSessionManager(sessionID)
+	if !exists {
+		return
+	}
+
+	manager.Document.Mutex.Lock()
+	defer manager.Document.Mutex.Unlock()
+	manager.Scene.Mutex.Lock()
+	defer manager.Scene.Mutex.Unlock()
+
+	if kind == SessionDrawing {
+		if err := manager.Scene.Reset(content); err != nil {
+			log.Printf("reset scene for session %s error: %v", sessionID, err)
+			return
+		}
+	} else {
+		manager.Document.Replace(content)
+	}
+
+	manager.SendExcept(nil, "snapshot", manager.snapshot())
+	log.Printf("Reset session %s to restored content", sessionID)
+}
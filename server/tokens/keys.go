@@ -0,0 +1,175 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is one key tokens can be signed or verified with, picked by the kid header
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod // HS256, RS256 or EdDSA
+	Secret  []byte            // HMAC keys only
+	Private crypto.PrivateKey // nil for keys that only verify
+	Public  crypto.PublicKey
+}
+
+// JWK is a public key in JSON Web Key form
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // OKP or EC curve
+	X         string `json:"x,omitempty"`   // OKP public key or EC x coordinate
+	Y         string `json:"y,omitempty"`   // EC y coordinate
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// HMACKey returns an HS256 key
+func HMACKey(id string, secret []byte) Key {
+	return Key{ID: id, Method: jwt.SigningMethodHS256, Secret: secret}
+}
+
+// RSAKey returns an RS256 key that can sign
+func RSAKey(id string, private *rsa.PrivateKey) Key {
+	return Key{ID: id, Method: jwt.SigningMethodRS256, Private: private, Public: &private.PublicKey}
+}
+
+// Ed25519Key returns an EdDSA key that can sign
+func Ed25519Key(id string, private ed25519.PrivateKey) Key {
+	return Key{ID: id, Method: jwt.SigningMethodEdDSA, Private: private, Public: private.Public()}
+}
+
+// ParsePEMKey reads an RSA or Ed25519 key, private keys can sign and public keys only verify
+func ParsePEMKey(id string, pem []byte) (Key, error) {
+	if private, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+		return RSAKey(id, private), nil
+	}
+	if private, err := jwt.ParseEdPrivateKeyFromPEM(pem); err == nil {
+		return Ed25519Key(id, private.(ed25519.PrivateKey)), nil
+	}
+	if public, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+		return Key{ID: id, Method: jwt.SigningMethodRS256, Public: public}, nil
+	}
+	if public, err := jwt.ParseEdPublicKeyFromPEM(pem); err == nil {
+		return Key{ID: id, Method: jwt.SigningMethodEdDSA, Public: public}, nil
+	}
+	return Key{}, fmt.Errorf("key %q is not an RSA or Ed25519 PEM key", id)
+}
+
+func (k Key) canSign() bool {
+	if k.Method == nil {
+		return false
+	}
+	if k.Method == jwt.SigningMethodHS256 {
+		return len(k.Secret) > 0
+	}
+	return k.Private != nil
+}
+
+func (k Key) signingKey() interface{} {
+	if k.Method == jwt.SigningMethodHS256 {
+		return k.Secret
+	}
+	return k.Private
+}
+
+func (k Key) verifyKey() interface{} {
+	if k.Method == jwt.SigningMethodHS256 {
+		return k.Secret
+	}
+	return k.Public
+}
+
+// JWK returns the public half of an asymmetric key, false for HMAC keys
+func (k Key) JWK() (JWK, bool) {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	switch public := k.Public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			KeyID:     k.ID,
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         encode(public.N.Bytes()),
+			E:         encode(big.NewInt(int64(public.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			KeyID:     k.ID,
+			Use:       "sig",
+			Algorithm: "EdDSA",
+			Curve:     "Ed25519",
+			X:         encode(public),
+		}, true
+	}
+	return JWK{}, false
+}
+
+// PublicKey decodes a JWK published by another issuer, used to verify OIDC ID tokens
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch j.KeyType {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.KeyType)
+}
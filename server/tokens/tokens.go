@@ -0,0 +1,265 @@
+package tokens
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joho/godotenv"
+)
+
+const (
+	defaultKeyID     = "primary"
+	defaultIssuer    = "collabify"
+	defaultAudience  = "collabify"
+	defaultClockSkew = 30 * time.Second
+)
+
+// token kinds, stored in the typ claim. every kind is signed with the same
+// keys, so Parse only accepts the kind the caller asks for
+const (
+	KindAccess    = "access" // login session access tokens
+	KindTwoFactor = "2fa"    // password step passed, 2FA code still needed
+	KindShare     = "share"  // share link tokens
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Config describes the keys and claims every Collabify token is checked against
+type Config struct {
+	SigningKey   Key   // new tokens are signed with this key
+	PreviousKeys []Key // still accepted for verification while old tokens expire
+	Issuer       string
+	Audience     string
+	ClockSkew    time.Duration // leeway for exp, nbf and iat
+}
+
+// Service signs and validates tokens for both REST and WebSocket auth
+type Service struct {
+	config Config
+	keys   map[string]Key
+}
+
+var defaultService *Service
+
+func init() {
+	_ = godotenv.Load()
+
+	config, err := LoadConfig()
+	if err == nil {
+		defaultService, err = NewService(config)
+	}
+	if err != nil {
+		log.Printf("token service config error: %v", err)
+		defaultService = &Service{keys: map[string]Key{}}
+	}
+}
+
+// LoadConfig reads the token settings from the environment:
+//
+//	JWT_SIGNING_ALG         HS256 (default), RS256 or EdDSA
+//	JWT_KEY                 secret of the signing key for HS256
+//	JWT_PRIVATE_KEY_FILE    PEM private key for RS256 and EdDSA
+//	JWT_KEY_ID              kid of the signing key, "primary" by default
+//	JWT_PREVIOUS_KEYS       retired HMAC keys still accepted, as "kid:secret,kid:secret"
+//	JWT_PREVIOUS_KEY_FILES  retired RSA/Ed25519 keys still accepted, as "kid:path.pem,..."
+//	JWT_ISSUER              defaults to "collabify"
+//	JWT_AUDIENCE            defaults to "collabify"
+//	JWT_CLOCK_SKEW          Go duration, defaults to 30s
+func LoadConfig() (Config, error) {
+	config := Config{
+		Issuer:    os.Getenv("JWT_ISSUER"),
+		Audience:  os.Getenv("JWT_AUDIENCE"),
+		ClockSkew: defaultClockSkew,
+	}
+
+	if config.Issuer == "" {
+		config.Issuer = defaultIssuer
+	}
+	if config.Audience == "" {
+		config.Audience = defaultAudience
+	}
+
+	if value := os.Getenv("JWT_CLOCK_SKEW"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			config.ClockSkew = d
+		} else {
+			log.Printf("ignoring invalid JWT_CLOCK_SKEW %q", value)
+		}
+	}
+
+	keyID := os.Getenv("JWT_KEY_ID")
+	if keyID == "" {
+		keyID = defaultKeyID
+	}
+
+	switch alg := os.Getenv("JWT_SIGNING_ALG"); alg {
+	case "", "HS256":
+		config.SigningKey = HMACKey(keyID, []byte(os.Getenv("JWT_KEY")))
+	case "RS256", "EdDSA":
+		pem, err := os.ReadFile(os.Getenv("JWT_PRIVATE_KEY_FILE"))
+		if err != nil {
+			return config, fmt.Errorf("read JWT_PRIVATE_KEY_FILE: %w", err)
+		}
+		config.SigningKey, err = ParsePEMKey(keyID, pem)
+		if err != nil {
+			return config, err
+		}
+		if config.SigningKey.Method.Alg() != alg {
+			return config, fmt.Errorf("JWT_PRIVATE_KEY_FILE holds a %s key, not %s", config.SigningKey.Method.Alg(), alg)
+		}
+	default:
+		return config, fmt.Errorf("unsupported JWT_SIGNING_ALG %q", alg)
+	}
+
+	for _, entry := range splitKeyList(os.Getenv("JWT_PREVIOUS_KEYS")) {
+		config.PreviousKeys = append(config.PreviousKeys, HMACKey(entry[0], []byte(entry[1])))
+	}
+
+	for _, entry := range splitKeyList(os.Getenv("JWT_PREVIOUS_KEY_FILES")) {
+		pem, err := os.ReadFile(entry[1])
+		if err != nil {
+			return config, fmt.Errorf("read previous key %q: %w", entry[0], err)
+		}
+		key, err := ParsePEMKey(entry[0], pem)
+		if err != nil {
+			return config, err
+		}
+		config.PreviousKeys = append(config.PreviousKeys, key)
+	}
+
+	return config, nil
+}
+
+// splits "kid:value,kid:value" lists, skipping malformed entries
+func splitKeyList(value string) [][2]string {
+	var entries [][2]string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, rest, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || rest == "" {
+			log.Printf("ignoring invalid key list entry")
+			continue
+		}
+		entries = append(entries, [2]string{id, rest})
+	}
+	return entries
+}
+
+// NewService builds a token service, key ids must be unique
+func NewService(config Config) (*Service, error) {
+	if !config.SigningKey.canSign() {
+		return nil, errors.New("signing key is missing")
+	}
+
+	service := &Service{config: config, keys: make(map[string]Key)}
+	for _, key := range append([]Key{config.SigningKey}, config.PreviousKeys...) {
+		if _, exists := service.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		service.keys[key.ID] = key
+	}
+	return service, nil
+}
+
+// Configure replaces the service used by Sign and Parse
+func Configure(service *Service) {
+	defaultService = service
+}
+
+// Sign signs claims as a token of kind with the default service
+func Sign(kind string, claims jwt.MapClaims) (string, error) {
+	return defaultService.Sign(kind, claims)
+}
+
+// Parse validates a token of kind with the default service
+func Parse(kind string, tokenString string) (jwt.MapClaims, error) {
+	return defaultService.Parse(kind, tokenString)
+}
+
+// PublicKeys returns the JWKS of the default service
+func PublicKeys() JWKS {
+	return defaultService.PublicKeys()
+}
+
+// Sign adds issuer, audience, issued-at and typ claims and signs with the current key
+func (s *Service) Sign(kind string, claims jwt.MapClaims) (string, error) {
+	key := s.config.SigningKey
+	if !key.canSign() {
+		return "", errors.New("no signing key configured")
+	}
+	if kind == "" {
+		return "", errors.New("token kind is missing")
+	}
+
+	signed := jwt.MapClaims{
+		"iss": s.config.Issuer,
+		"aud": s.config.Audience,
+		"iat": time.Now().Unix(),
+	}
+	for name, value := range claims {
+		signed[name] = value
+	}
+	signed["typ"] = kind
+
+	token := jwt.NewWithClaims(key.Method, signed)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signingKey())
+}
+
+// Parse checks the signature, expiry, issuer, audience and kind of a token
+func (s *Service) Parse(kind string, tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, s.keyFor,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}),
+		jwt.WithLeeway(s.config.ClockSkew),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(s.config.Issuer),
+		jwt.WithAudience(s.config.Audience),
+	)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	// a 2FA challenge or share link must never pass as an access token
+	if typ, _ := claims["typ"].(string); kind == "" || typ != kind {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// picks the verification key by kid, the token's alg has to match the key so
+// a public key can never be used as an HMAC secret
+func (s *Service) keyFor(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+	key, exists := s.keys[id]
+	if !exists {
+		return nil, fmt.Errorf("unknown key id %q", id)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not use %s", id, token.Method.Alg())
+	}
+	return key.verifyKey(), nil
+}
+
+// PublicKeys lists the RSA and Ed25519 keys other services can verify tokens with,
+// HMAC secrets are never published
+func (s *Service) PublicKeys() JWKS {
+	set := JWKS{Keys: []JWK{}}
+
+	// signing key first, then previous keys in config order
+	for _, key := range append([]Key{s.config.SigningKey}, s.config.PreviousKeys...) {
+		if jwk, ok := key.JWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
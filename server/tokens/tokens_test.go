@@ -0,0 +1,100 @@
+package tokens
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func testService(t *testing.T) *Service {
+	t.Helper()
+	service, err := NewService(Config{
+		SigningKey: HMACKey("primary", []byte("test secret")),
+		Issuer:     "collabify",
+		Audience:   "collabify",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestParseChecksKind(t *testing.T) {
+	service := testService(t)
+	kinds := []string{KindAccess, KindTwoFactor, KindShare}
+
+	for _, signed := range kinds {
+		// a typ in the claims can't override the kind
+		token, err := service.Sign(signed, jwt.MapClaims{"typ": KindAccess, "exp": time.Now().Add(time.Minute).Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, wanted := range kinds {
+			_, err := service.Parse(wanted, token)
+			if ok := err == nil; ok != (signed == wanted) {
+				t.Errorf("%s token parsed as %s: err = %v", signed, wanted, err)
+			}
+		}
+	}
+
+	if _, err := service.Sign("", jwt.MapClaims{}); err == nil {
+		t.Error("signing without a kind should be an error")
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	service := testService(t)
+	key := []byte("test secret")
+	exp := time.Now().Add(time.Minute).Unix()
+
+	sign := func(claims jwt.MapClaims, keyID string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		if keyID != "" {
+			token.Header["kid"] = keyID
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"iss": "collabify", "aud": "collabify", "typ": KindAccess, "exp": exp}
+	}
+	with := func(name string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	if _, err := service.Parse(KindAccess, sign(valid(), "primary")); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no kid", sign(valid(), "")},
+		{"unknown kid", sign(valid(), "other")},
+		{"no issuer", sign(with("iss", nil), "primary")},
+		{"other issuer", sign(with("iss", "someone-else"), "primary")},
+		{"no audience", sign(with("aud", nil), "primary")},
+		{"other audience", sign(with("aud", "someone-else"), "primary")},
+		{"no typ", sign(with("typ", nil), "primary")},
+		{"expired", sign(with("exp", time.Now().Add(-time.Hour).Unix()), "primary")},
+		{"no expiry", sign(with("exp", nil), "primary")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := service.Parse(KindAccess, test.token); err != ErrInvalidToken {
+				t.Errorf("err = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,574 @@
+package workspaces
+
+import (
+	"collabify-backend/access"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// a workspace can't grow past this many members
+const maxMembers = 500
+
+// Workspace is a shared space whose members reach every document and drawing
+// in it with their workspace role. the owner is also in Members
+type Workspace struct {
+	ID          interface{} `json:"-" bson:"_id,omitempty"`
+	WorkspaceID string      `json:"id" bson:"workspaceId"`
+	Name        string      `json:"name" bson:"name"`
+	OwnerID     string      `json:"ownerId" bson:"ownerId"`
+	Members     []Member    `json:"members" bson:"members"`
+	CreatedAt   time.Time   `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time   `json:"updatedAt" bson:"updatedAt"`
+	Role        access.Role `json:"role,omitempty" bson:"-"` // caller's role, filled per request
+}
+
+// Member is someone in a workspace and the role they get on its content
+type Member struct {
+	UserID  string      `json:"userId" bson:"userId"`
+	Email   string      `json:"email" bson:"email"` // for display, kept current on email changes
+	Role    access.Role `json:"role" bson:"role"`
+	AddedBy string      `json:"addedBy" bson:"addedBy"`
+	AddedAt time.Time   `json:"addedAt" bson:"addedAt"`
+}
+
+type WorkspaceRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// ContentHooks let documents and drawings react to membership changes
+// without this package importing them
+type ContentHooks struct {
+	MemberChanged    func(workspaceID string, userID string) error      // role changed or the user left
+	WorkspaceDeleted func(workspaceID string, memberIDs []string) error // content moves out of the workspace
+}
+
+var (
+	workspacesCollection *mongo.Collection
+	contentHooks         []ContentHooks
+)
+
+// SetWorkspacesCollection sets the workspaces collection
+func SetWorkspacesCollection(collection *mongo.Collection) {
+	workspacesCollection = collection
+}
+
+// OnContentChanges registers hooks for membership changes and deleted workspaces
+func OnContentChanges(hooks ContentHooks) {
+	contentHooks = append(contentHooks, hooks)
+}
+
+// EnsureIndexes creates the indexes workspace lookups rely on
+func EnsureIndexes(ctx context.Context) error {
+	_, err := workspacesCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "workspaceId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "members.userId", Value: 1}}},
+		{Keys: bson.D{{Key: "ownerId", Value: 1}}},
+	})
+	return err
+}
+
+// RolesFor returns the role userID has in each workspace they belong to
+func RolesFor(ctx context.Context, userID string) (map[string]access.Role, error) {
+	cursor, err := workspacesCollection.Find(ctx,
+		bson.M{"members.userId": userID},
+		options.Find().SetProjection(bson.M{"workspaceId": 1, "members.$": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var workspaces []Workspace
+	if err := cursor.All(ctx, &workspaces); err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]access.Role, len(workspaces))
+	for _, workspace := range workspaces {
+		if len(workspace.Members) > 0 {
+			roles[workspace.WorkspaceID] = workspace.Members[0].Role
+		}
+	}
+	return roles, nil
+}
+
+func memberChanged(workspaceID string, userID string) {
+	for _, hooks := range contentHooks {
+		if hooks.MemberChanged == nil {
+			continue
+		}
+		if err := hooks.MemberChanged(workspaceID, userID); err != nil {
+			log.Printf("workspace %s member %s hook error: %v", workspaceID, userID, err)
+		}
+	}
+}
+
+func workspaceDeleted(workspace Workspace) error {
+	memberIDs := make([]string, len(workspace.Members))
+	for i, member := range workspace.Members {
+		memberIDs[i] = member.UserID
+	}
+
+	for _, hooks := range contentHooks {
+		if hooks.WorkspaceDeleted == nil {
+			continue
+		}
+		if err := hooks.WorkspaceDeleted(workspace.WorkspaceID, memberIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finds a workspace the user is a member of, along with their role
+func findWorkspace(workspaceID string, user access.Principal) (Workspace, access.Role, error) {
+	var workspace Workspace
+	err := workspacesCollection.FindOne(context.Background(), bson.M{
+		"workspaceId":    workspaceID,
+		"members.userId": user.ID,
+	}).Decode(&workspace)
+	if err != nil {
+		return workspace, "", err
+	}
+
+	workspace.Role = roleOf(workspace, user.ID)
+	return workspace, workspace.Role, nil
+}
+
+func roleOf(workspace Workspace, userID string) access.Role {
+	for _, member := range workspace.Members {
+		if member.UserID == userID {
+			return member.Role
+		}
+	}
+	return ""
+}
+
+func validName(name string) bool {
+	return name != "" && len(name) <= 100
+}
+
+func newWorkspaceID() (string, error) {
+	bytes := make([]byte, 12)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// creates a workspace owned by the current user
+func CreateWorkspace(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if !validName(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be 1 to 100 characters"})
+		return
+	}
+
+	workspaceID, err := newWorkspaceID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workspace"})
+		return
+	}
+
+	now := time.Now()
+	workspace := Workspace{
+		WorkspaceID: workspaceID,
+		Name:        req.Name,
+		OwnerID:     user.ID,
+		Members: []Member{{
+			UserID:  user.ID,
+			Email:   user.Email,
+			Role:    access.RoleOwner,
+			AddedBy: user.Email,
+			AddedAt: now,
+		}},
+		CreatedAt: now,
+		UpdatedAt: now,
+		Role:      access.RoleOwner,
+	}
+
+	if _, err := workspacesCollection.InsertOne(context.Background(), workspace); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workspace"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   "Workspace created successfully",
+		"workspace": workspace,
+	})
+}
+
+// lists the workspaces the current user is a member of
+func GetUserWorkspaces(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	cursor, err := workspacesCollection.Find(context.Background(),
+		bson.M{"members.userId": user.ID},
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspaces"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var workspaces []Workspace
+	if err = cursor.All(context.Background(), &workspaces); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode workspaces"})
+		return
+	}
+
+	if workspaces == nil {
+		workspaces = []Workspace{}
+	}
+
+	for i := range workspaces {
+		workspaces[i].Role = roleOf(workspaces[i], user.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"workspaces": workspaces,
+	})
+}
+
+// retrieves a workspace and its members
+func GetWorkspace(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	workspace, _, err := findWorkspace(c.Param("workspaceId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspace"})
+		return
+	}
+
+	c.JSON(http.StatusOK, workspace)
+}
+
+// renames a workspace, owner only
+func UpdateWorkspace(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if !validName(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be 1 to 100 characters"})
+		return
+	}
+
+	workspace, role, err := findWorkspace(c.Param("workspaceId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspace"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can rename this workspace"})
+		return
+	}
+
+	workspace.Name = req.Name
+	workspace.UpdatedAt = time.Now()
+	_, err = workspacesCollection.UpdateOne(context.Background(),
+		bson.M{"_id": workspace.ID},
+		bson.M{"$set": bson.M{"name": workspace.Name, "updatedAt": workspace.UpdatedAt}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update workspace"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Workspace updated successfully",
+		"workspace": workspace,
+	})
+}
+
+// deletes a workspace, owner only. its documents and drawings are kept by
+// their owners and members lose the access the workspace gave them
+func DeleteWorkspace(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	workspace, role, err := findWorkspace(c.Param("workspaceId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspace"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can delete this workspace"})
+		return
+	}
+
+	if _, err := workspacesCollection.DeleteOne(context.Background(), bson.M{"_id": workspace.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete workspace"})
+		return
+	}
+
+	if err := workspaceDeleted(workspace); err != nil {
+		log.Printf("move content out of workspace %s error: %v", workspace.WorkspaceID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Workspace deleted successfully",
+	})
+}
+
+// adds a member or changes their role, owner only. members need an account
+func AddMember(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req access.ShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !req.Role.Grantable() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be editor, commenter or viewer"})
+		return
+	}
+
+	workspace, role, err := findWorkspace(c.Param("workspaceId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspace"})
+		return
+	}
+
+	if !role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can add members"})
+		return
+	}
+
+	invitee, err := access.LookupUser(context.Background(), req.Email)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No account with that email"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+
+	// an unverified account may have been registered by someone else
+	if !invitee.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "That account has not verified its email yet"})
+		return
+	}
+
+	if invitee.ID == workspace.OwnerID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Owner already has full access"})
+		return
+	}
+
+	member := Member{
+		UserID:  invitee.ID,
+		Email:   invitee.Email,
+		Role:    req.Role,
+		AddedBy: user.Email,
+		AddedAt: time.Now(),
+	}
+
+	// existing members only get their role changed
+	result, err := workspacesCollection.UpdateOne(context.Background(),
+		bson.M{"_id": workspace.ID, "members.userId": member.UserID},
+		bson.M{"$set": bson.M{"members.$.role": member.Role, "updatedAt": time.Now()}},
+	)
+	if err == nil && result.MatchedCount == 0 {
+		if len(workspace.Members) >= maxMembers {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This workspace has too many members"})
+			return
+		}
+		_, err = workspacesCollection.UpdateOne(context.Background(),
+			bson.M{"_id": workspace.ID, "members.userId": bson.M{"$ne": member.UserID}},
+			bson.M{"$push": bson.M{"members": member}, "$set": bson.M{"updatedAt": time.Now()}},
+		)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
+		return
+	}
+
+	memberChanged(workspace.WorkspaceID, member.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Member added successfully",
+		"member":  member,
+	})
+}
+
+// removes a member, the owner can remove anyone and members can leave
+func RemoveMember(c *gin.Context) {
+	user, exists := access.CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	memberID := c.Param("userId")
+	workspace, role, err := findWorkspace(c.Param("workspaceId"), user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspace"})
+		return
+	}
+
+	if !role.CanManage() && memberID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can remove members"})
+		return
+	}
+	if memberID == workspace.OwnerID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The owner can't leave, delete the workspace instead"})
+		return
+	}
+
+	result, err := workspacesCollection.UpdateOne(context.Background(),
+		bson.M{"_id": workspace.ID},
+		bson.M{"$pull": bson.M{"members": bson.M{"userId": memberID}}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+
+	if result.ModifiedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	// drop them from live sessions they only had through the workspace
+	memberChanged(workspace.WorkspaceID, memberID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Member removed successfully",
+	})
+}
+
+// RenameUser refreshes the member email shown in workspaces after an email change
+func RenameUser(user access.Principal, oldEmail string) error {
+	_, err := workspacesCollection.UpdateMany(context.Background(),
+		bson.M{"members.userId": user.ID},
+		bson.M{"$set": bson.M{"members.$[entry].email": user.Email}},
+		options.UpdateMany().SetArrayFilters([]interface{}{bson.M{"entry.userId": user.ID}}),
+	)
+	return err
+}
+
+// RemoveUser cleans up after a deleted account. owned workspaces go to
+// transferTo, or are deleted when it is empty, and the user leaves the rest
+func RemoveUser(user access.Principal, transferTo access.Principal) error {
+	ctx := context.Background()
+
+	cursor, err := workspacesCollection.Find(ctx, bson.M{"ownerId": user.ID})
+	if err != nil {
+		return err
+	}
+	var owned []Workspace
+	if err := cursor.All(ctx, &owned); err != nil {
+		return err
+	}
+
+	for _, workspace := range owned {
+		if transferTo.ID == "" {
+			if _, err := workspacesCollection.DeleteOne(ctx, bson.M{"_id": workspace.ID}); err != nil {
+				return err
+			}
+			if err := workspaceDeleted(workspace); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// the new owner takes the old owner's entry, dropping any membership they had
+		_, err := workspacesCollection.UpdateOne(ctx,
+			bson.M{"_id": workspace.ID},
+			bson.M{"$pull": bson.M{"members": bson.M{"userId": transferTo.ID}}},
+		)
+		if err != nil {
+			return err
+		}
+		_, err = workspacesCollection.UpdateOne(ctx,
+			bson.M{"_id": workspace.ID, "members.userId": user.ID},
+			bson.M{"$set": bson.M{
+				"ownerId":          transferTo.ID,
+				"members.$.userId": transferTo.ID,
+				"members.$.email":  transferTo.Email,
+				"updatedAt":        time.Now(),
+			}},
+		)
+		if err != nil {
+			return err
+		}
+		memberChanged(workspace.WorkspaceID, transferTo.ID)
+	}
+
+	_, err = workspacesCollection.UpdateMany(ctx,
+		bson.M{"members.userId": user.ID},
+		bson.M{"$pull": bson.M{"members": bson.M{"userId": user.ID}}},
+	)
+	return err
+}